@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"time"
+)
+
+// auditActor returns the employee and station of the request, which the models record
+// in the audit log together with the changes they make.
+func (app *Application) auditActor(r *http.Request) model.AuditActor {
+	var by model.AuditActor
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		by.EmployeeId = &user.Id
+	}
+	if station := app.contextGetStation(r); station != nil {
+		by.StationId = &station.Id
+	}
+	return by
+}
+
+// getAuditLog returns a page of the audit log, newest first unless sorted otherwise,
+// narrowed down by employee, station, action, entity and a range of dates (both
+// inclusive).
+func (app *Application) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		model.AuditQuery
+		model.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	input.EmployeeId = app.readInt(qs, "employee_id", 0, v)
+	input.StationId = app.readInt(qs, "station_id", 0, v)
+	input.Action = app.readString(qs, "action", "")
+	input.Entity = app.readString(qs, "entity", "")
+	input.EntityId = app.readInt(qs, "entity_id", 0, v)
+	input.From = app.readDate(qs, "from", time.Time{}, v)
+	input.To = app.readDate(qs, "to", today, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(input.Action == "" || validator.In(input.Action, model.AuditActions...), "action", "must be create, update, delete or import")
+	v.Check(input.Entity == "" || validator.In(input.Entity, model.AuditEntities...), "entity", "must be employee, product, category, order or permissions")
+	v.Check(!input.To.Before(input.From), "to", "must not be before from")
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	input.To = input.To.AddDate(0, 0, 1)
+	entries, metadata, err := app.Models.Audit.GetAll(input.AuditQuery, input.Filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"entries": entries, "metadata": metadata})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"pos-rs/pkg/pos/catalog"
+	"pos-rs/pkg/pos/model"
+	"sort"
+	"strings"
+)
+
+// maxCatalogBytes caps the size of an uploaded catalog.
+const maxCatalogBytes = 32 << 20
+
+// exportFlushRows is how many catalog rows are buffered before they are sent.
+const exportFlushRows = 500
+
+// importProducts upserts a CSV or XLSX catalog, sent either as the request body or as
+// the "file" field of a multipart form. The format comes from ?format=, the file
+// name or the content type. With ?dry_run=true every row is checked without saving
+// anything; a real import is only saved when no row has errors.
+func (app *Application) importProducts(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCatalogBytes)
+
+	qs := r.URL.Query()
+	format := strings.ToLower(app.readString(qs, "format", ""))
+	dryRun := app.readString(qs, "dry_run", "false") == "true"
+
+	var body io.Reader = r.Body
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			app.respondWithError(w, http.StatusBadRequest, "Missing Catalog File")
+			return
+		}
+		defer file.Close()
+
+		body = file
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+		}
+	}
+	if format == "" {
+		format = catalogFormat(mediaType)
+	}
+
+	rows, parseErrs, err := catalog.Read(body, format)
+	if err != nil {
+		var parseErr *csv.ParseError
+		switch {
+		case errors.Is(err, catalog.ErrUnknownFormat), errors.Is(err, catalog.ErrMissingColumn),
+			errors.Is(err, catalog.ErrEmptyCatalog), errors.Is(err, catalog.ErrInvalidXLSX),
+			errors.As(err, &parseErr):
+			app.failedValidationResponse(w, r, map[string]string{"file": err.Error()})
+		default:
+			app.respondWithError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	// Rows are still checked against the database when others failed to parse, so
+	// that one pass reports every error, but nothing is saved.
+	result, err := app.Models.Product.Import(rows, dryRun || len(parseErrs) > 0, app.auditActor(r))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result.DryRun = dryRun
+	lines := make(map[int]bool)
+	for _, e := range parseErrs {
+		lines[e.Line] = true
+	}
+	result.Rows += len(lines)
+	result.Errors = append(result.Errors, parseErrs...)
+	sort.SliceStable(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Line < result.Errors[j].Line
+	})
+
+	status := http.StatusOK
+	if len(result.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	app.respondWithJSON(w, status, envelope{"import": result})
+}
+
+// exportProducts streams the whole catalog as CSV in the import format.
+func (app *Application) exportProducts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="catalog.csv"`)
+
+	cw, err := catalog.NewCSVWriter(w)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	n := 0
+	err = app.Models.Product.Export(r.Context(), func(row model.ProductExportRow) error {
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+		if n++; n%exportFlushRows == 0 {
+			if err := cw.Flush(); err != nil {
+				return err
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = cw.Flush()
+	}
+	if err != nil {
+		// The header is already sent, so the failure can only be logged.
+		app.logger.PrintError(err, map[string]string{"request_url": r.URL.String()})
+	}
+}
+
+func catalogFormat(mediaType string) string {
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return catalog.FormatCSV
+	case "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":
+		return catalog.FormatXLSX
+	}
+	return ""
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
 	"strconv"
 
 	"github.com/gorilla/mux"
@@ -19,9 +21,15 @@ func (app *Application) createCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Category.Create(&newCategory)
+	v := validator.New()
+	if model.ValidateCategory(v, &newCategory); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Category.Create(&newCategory, app.auditActor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		app.categoryErrorResponse(w, r, err)
 		return
 	}
 
@@ -44,6 +52,7 @@ func (app *Application) getCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.setETag(w, Category.Version)
 	app.respondWithJSON(w, http.StatusFound, Category)
 }
 
@@ -74,13 +83,26 @@ func (app *Application) updateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Category.Update(categoryId, &updatedCategory)
+	v := validator.New()
+	updatedCategory.Version = app.readIfMatch(r, updatedCategory.Version, v)
+	v.Check(updatedCategory.Version > 0, "version", "must be provided in the body or as If-Match")
+	if model.ValidateCategory(v, &updatedCategory); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Category.Update(categoryId, &updatedCategory, app.auditActor(r))
 	updatedCategory.Id = categoryId
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		if errors.Is(err, model.ErrEditConflict) {
+			app.editConflictResponse(w, r, updatedCategory.Version)
+			return
+		}
+		app.categoryErrorResponse(w, r, err)
 		return
 	}
 
+	app.setETag(w, updatedCategory.Version)
 	app.respondWithJSON(w, http.StatusOK, updatedCategory)
 }
 
@@ -94,11 +116,108 @@ func (app *Application) deleteCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Category.Delete(categoryId)
+	err = app.Models.Category.Delete(categoryId, app.auditActor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		app.categoryErrorResponse(w, r, err)
 		return
 	}
 
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
+
+// getCategoryTree returns the root categories with their subcategories nested.
+func (app *Application) getCategoryTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := app.Models.Category.Tree()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"categories": tree})
+}
+
+// moveCategory puts a category under parent_id (null for the root) at position among
+// its new siblings; a missing position appends it.
+func (app *Application) moveCategory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["categoryId"]
+
+	categoryId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Category ID")
+		return
+	}
+
+	var input struct {
+		ParentId *int `json:"parent_id"`
+		Position *int `json:"position"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	position := -1
+	if input.Position != nil {
+		position = *input.Position
+	}
+
+	v := validator.New()
+	v.Check(input.ParentId == nil || *input.ParentId > 0, "parent_id", "must be a positive integer")
+	v.Check(input.Position == nil || *input.Position >= 0, "position", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	category, err := app.Models.Category.Move(categoryId, input.ParentId, position, app.auditActor(r))
+	if err != nil {
+		app.categoryErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"category": category})
+}
+
+// reorderCategories sets the order of the children of parent_id (null for the root
+// categories) to the given ids.
+func (app *Application) reorderCategories(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ParentId *int  `json:"parent_id"`
+		Ids      []int `json:"ids"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Ids) > 0, "ids", "must contain at least one id")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Category.Reorder(input.ParentId, input.Ids, app.auditActor(r))
+	if err != nil {
+		app.categoryErrorResponse(w, r, err)
+		return
+	}
+
+	app.getCategoryTree(w, r)
+}
+
+func (app *Application) categoryErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, model.ErrCategoryCycle), errors.Is(err, model.ErrInvalidOrder):
+		app.failedValidationResponse(w, r, map[string]string{"category": err.Error()})
+	case errors.Is(err, model.ErrCategoryInUse):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
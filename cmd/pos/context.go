@@ -9,6 +9,10 @@ type contextKey string
 
 const userContextKey = contextKey("employee")
 
+const stationContextKey = contextKey("station")
+
+const idempotencyKeyContextKey = contextKey("idempotency_key")
+
 func (app *Application) contextSetUser(r *http.Request, user *model.Employee) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
@@ -20,4 +24,28 @@ func (app *Application) contextGetUser(r *http.Request) *model.Employee {
 		panic("missing user value in request context")
 	}
 	return user
-}
\ No newline at end of file
+}
+
+func (app *Application) contextSetStation(r *http.Request, station *model.Station) *http.Request {
+	ctx := context.WithValue(r.Context(), stationContextKey, station)
+	return r.WithContext(ctx)
+}
+
+// contextGetStation returns the station the request came from, or nil when it carried
+// no station credential.
+func (app *Application) contextGetStation(r *http.Request) *model.Station {
+	station, _ := r.Context().Value(stationContextKey).(*model.Station)
+	return station
+}
+
+func (app *Application) contextSetIdempotencyKey(r *http.Request, key *model.IdempotencyKey) *http.Request {
+	ctx := context.WithValue(r.Context(), idempotencyKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetIdempotencyKey returns the idempotency key claimed for the request, or nil
+// when it carried none.
+func (app *Application) contextGetIdempotencyKey(r *http.Request) *model.IdempotencyKey {
+	key, _ := r.Context().Value(idempotencyKeyContextKey).(*model.IdempotencyKey)
+	return key
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createCustomer(w http.ResponseWriter, r *http.Request) {
+	var newCustomer model.Customer
+
+	err := json.NewDecoder(r.Body).Decode(&newCustomer)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	newCustomer.Phone = model.NormalizePhone(newCustomer.Phone)
+	v := validator.New()
+	if model.ValidateCustomer(v, &newCustomer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Customers.Create(&newCustomer)
+	if err != nil {
+		if errors.Is(err, model.ErrDuplicatePhone) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"customer": newCustomer})
+}
+
+func (app *Application) getCustomer(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.Atoi(mux.Vars(r)["customerId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+		return
+	}
+
+	customer, err := app.Models.Customers.Get(customerId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"customer": customer})
+}
+
+// lookupCustomer finds the customer with the phone number typed at the till.
+func (app *Application) lookupCustomer(w http.ResponseWriter, r *http.Request) {
+	phone := app.readString(r.URL.Query(), "phone", "")
+	if model.NormalizePhone(phone) == "" {
+		app.failedValidationResponse(w, r, map[string]string{"phone": "must be provided"})
+		return
+	}
+
+	customer, err := app.Models.Customers.GetByPhone(phone)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Customer Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"customer": customer})
+}
+
+// getAllCustomers returns a page of customers, optionally narrowed by a search on
+// name, phone and email.
+func (app *Application) getAllCustomers(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search string
+		model.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "name")
+	input.Filters.SortSafelist = []string{"id", "name", "phone", "points", "created_at", "-id", "-name", "-phone", "-points", "-created_at"}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	customers, metadata, err := app.Models.Customers.GetAll(input.Search, input.Filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"customers": customers, "metadata": metadata})
+}
+
+func (app *Application) updateCustomer(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.Atoi(mux.Vars(r)["customerId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+		return
+	}
+
+	var updatedCustomer model.Customer
+	err = json.NewDecoder(r.Body).Decode(&updatedCustomer)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	updatedCustomer.Phone = model.NormalizePhone(updatedCustomer.Phone)
+	v := validator.New()
+	if model.ValidateCustomer(v, &updatedCustomer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Customers.Update(customerId, &updatedCustomer)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+		case errors.Is(err, model.ErrDuplicatePhone):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"customer": updatedCustomer})
+}
+
+func (app *Application) deleteCustomer(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.Atoi(mux.Vars(r)["customerId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+		return
+	}
+
+	err = app.Models.Customers.Delete(customerId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// getCustomerOrders returns a page of the customer's purchase history.
+func (app *Application) getCustomerOrders(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.Atoi(mux.Vars(r)["customerId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+		return
+	}
+
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"id", "created_at", "total_price", "-id", "-created_at", "-total_price"}
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if _, err := app.Models.Customers.Get(customerId); err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Customer Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	orders, metadata, err := app.Models.Customers.Orders(customerId, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata})
+}
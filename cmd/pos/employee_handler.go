@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	// "go/token"
 	"net/http"
 	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
 	"strconv"
 	"time"
 
@@ -35,14 +37,14 @@ func (app *Application) registerEmployee(w http.ResponseWriter, r *http.Request)
 
 
 
-	err = app.Models.Employee.Register(&newEmployee)
+	err = app.Models.Employee.Register(&newEmployee, app.auditActor(r))
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
 	}
 
 	fmt.Println(newEmployee.Id)	
-	err = app.Models.Permissions.AddForUser(newEmployee.Id, "products:read")
+	err = app.Models.Permissions.AddForUser(newEmployee.Id, app.auditActor(r), "products:read")
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -78,7 +80,7 @@ func (app *Application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	employee.Activated = true
 
-	err = app.Models.Employee.Update(employee.Id, employee)
+	err = app.Models.Employee.Update(employee.Id, employee, app.auditActor(r))
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -184,12 +186,27 @@ func (app *Application) updateEmployee(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Employee.Update(employeeId, &updatedEmployee)
+	v := validator.New()
+	updatedEmployee.Version = app.readIfMatch(r, updatedEmployee.Version, v)
+	if v.Check(updatedEmployee.Version > 0, "version", "must be provided in the body or as If-Match"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Employee.Update(employeeId, &updatedEmployee, app.auditActor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r, updatedEmployee.Version)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "404 Employee Not Found")
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
+	app.setETag(w, updatedEmployee.Version)
 	app.respondWithJSON(w, http.StatusOK, updatedEmployee)
 }
 
@@ -219,6 +236,7 @@ func (app *Application) getEmployee(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.setETag(w, Employee.Version)
 	app.respondWithJSON(w, http.StatusFound, Employee)
 }
 
@@ -232,8 +250,12 @@ func (app *Application) deleteEmployee(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Employee.Delete(employeeId)
+	err = app.Models.Employee.Delete(employeeId, app.auditActor(r))
 	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "404 Employee Not Found")
+			return
+		}
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
 		return
 	}
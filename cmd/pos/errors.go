@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"pos-rs/pkg/pos/model"
 )
 
 func (app *Application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
@@ -25,7 +27,42 @@ func (app *Application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.respondWithError(w, http.StatusForbidden, message)
 }
 
+func (app *Application) invalidStationCredentialResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid station credential"
+	app.respondWithError(w, http.StatusUnauthorized, message)
+}
+
+func (app *Application) stationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource must be accessed from a registered station"
+	app.respondWithError(w, http.StatusUnauthorized, message)
+}
+
+func (app *Application) stationDisabledResponse(w http.ResponseWriter, r *http.Request, station *model.Station) {
+	message := fmt.Sprintf("station %q is disabled", station.Name)
+	if station.DisabledReason != "" {
+		message += ": " + station.DisabledReason
+	}
+	app.respondWithError(w, http.StatusForbidden, message)
+}
+
 func (app *Application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.respondWithError(w, http.StatusForbidden, message)
 }
+
+
+func (app *Application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	app.respondWithJSON(w, http.StatusUnprocessableEntity, envelope{"error": errors})
+}
+
+func (app *Application) outOfStockResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.respondWithError(w, http.StatusConflict, err.Error())
+}
+
+// editConflictResponse tells the client its change was made against an outdated
+// version, and which version is current.
+func (app *Application) editConflictResponse(w http.ResponseWriter, r *http.Request, version int) {
+	app.setETag(w, version)
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.respondWithJSON(w, http.StatusConflict, envelope{"error": message, "version": version})
+}
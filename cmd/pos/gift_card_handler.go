@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// issueGiftCard creates an inactive gift card that is loaded once an order sells it.
+func (app *Application) issueGiftCard(w http.ResponseWriter, r *http.Request) {
+	var newCard model.GiftCard
+
+	err := json.NewDecoder(r.Body).Decode(&newCard)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	newCard.Code = model.NormalizeGiftCardCode(newCard.Code)
+	v := validator.New()
+	if model.ValidateGiftCard(v, &newCard); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.GiftCards.Issue(&newCard)
+	if err != nil {
+		if errors.Is(err, model.ErrDuplicateGiftCard) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"gift_card": newCard})
+}
+
+func (app *Application) getGiftCard(w http.ResponseWriter, r *http.Request) {
+	giftCardId, err := strconv.Atoi(mux.Vars(r)["giftCardId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Gift Card ID")
+		return
+	}
+
+	card, err := app.Models.GiftCards.Get(giftCardId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"gift_card": card})
+}
+
+// lookupGiftCard finds the gift card with the code scanned or typed at the till, for a
+// balance check.
+func (app *Application) lookupGiftCard(w http.ResponseWriter, r *http.Request) {
+	code := app.readString(r.URL.Query(), "code", "")
+	if model.NormalizeGiftCardCode(code) == "" {
+		app.failedValidationResponse(w, r, map[string]string{"code": "must be provided"})
+		return
+	}
+
+	card, err := app.Models.GiftCards.GetByCode(code)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Gift Card Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"gift_card": card})
+}
+
+// getAllGiftCards returns a page of gift cards, optionally of one status or customer.
+func (app *Application) getAllGiftCards(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status     string
+		CustomerId int
+		model.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.CustomerId = app.readInt(qs, "customer_id", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "balance", "created_at", "-id", "-balance", "-created_at"}
+
+	v.Check(input.Status == "" || validator.In(input.Status, model.GiftCardStatuses...), "status", "must be inactive, active or void")
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cards, metadata, err := app.Models.GiftCards.GetAll(input.Status, input.CustomerId, input.Filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"gift_cards": cards, "metadata": metadata})
+}
+
+// getGiftCardTransactions returns the whole ledger of a gift card.
+func (app *Application) getGiftCardTransactions(w http.ResponseWriter, r *http.Request) {
+	giftCardId, err := strconv.Atoi(mux.Vars(r)["giftCardId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Gift Card ID")
+		return
+	}
+
+	transactions, err := app.Models.GiftCards.Transactions(giftCardId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"transactions": transactions})
+}
+
+// voidGiftCard takes a lost or fraudulent card out of use along with its balance.
+func (app *Application) voidGiftCard(w http.ResponseWriter, r *http.Request) {
+	giftCardId, err := strconv.Atoi(mux.Vars(r)["giftCardId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Gift Card ID")
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Reason != "", "reason", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	card, err := app.Models.GiftCards.Void(giftCardId, app.contextGetUser(r).Id, input.Reason)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"gift_card": card})
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"net/http"
 	"net/url" // New import
 	"strconv"
 	"strings"
+	"time"
 
 	"pos-rs/pkg/pos/validator" // New import
 )
@@ -59,3 +61,47 @@ func (app *Application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// The readDate() helper reads a YYYY-MM-DD date from the query string. If no matching
+// key could be found it returns the provided default value. If the value couldn't be
+// parsed, then we record an error message in the provided Validator instance.
+func (app *Application) readDate(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		v.AddError(key, "must be a date in YYYY-MM-DD format")
+		return defaultValue
+	}
+
+	return t
+}
+
+// The readIfMatch() helper returns the version named by the If-Match header, which
+// the ETag of an earlier response carries as "N". It returns the provided default
+// value when the header is missing or "*". If the header couldn't be parsed, then we
+// record an error message in the provided Validator instance.
+func (app *Application) readIfMatch(r *http.Request, defaultValue int, v *validator.Validator) int {
+	s := strings.TrimSpace(r.Header.Get("If-Match"))
+	if s == "" || s == "*" {
+		return defaultValue
+	}
+
+	s = strings.Trim(strings.TrimPrefix(s, "W/"), `"`)
+	version, err := strconv.Atoi(s)
+	if err != nil || version < 1 {
+		v.AddError("If-Match", "must be the ETag of the current version")
+		return defaultValue
+	}
+
+	return version
+}
+
+// The setETag() helper tags the response with the version of the entity it carries,
+// for the client to send back in If-Match.
+func (app *Application) setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createLoyaltyRule(w http.ResponseWriter, r *http.Request) {
+	var newRule model.LoyaltyRule
+
+	err := json.NewDecoder(r.Body).Decode(&newRule)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateLoyaltyRule(v, &newRule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Loyalty.CreateRule(&newRule)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"rule": newRule})
+}
+
+func (app *Application) getLoyaltyRule(w http.ResponseWriter, r *http.Request) {
+	ruleId, err := strconv.Atoi(mux.Vars(r)["ruleId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Rule ID")
+		return
+	}
+
+	rule, err := app.Models.Loyalty.GetRule(ruleId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"rule": rule})
+}
+
+func (app *Application) getAllLoyaltyRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.Models.Loyalty.GetRules()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"rules": rules, "point_value": model.LoyaltyPointValue})
+}
+
+func (app *Application) updateLoyaltyRule(w http.ResponseWriter, r *http.Request) {
+	ruleId, err := strconv.Atoi(mux.Vars(r)["ruleId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Rule ID")
+		return
+	}
+
+	var updatedRule model.LoyaltyRule
+	err = json.NewDecoder(r.Body).Decode(&updatedRule)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateLoyaltyRule(v, &updatedRule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Loyalty.UpdateRule(ruleId, &updatedRule)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"rule": updatedRule})
+}
+
+func (app *Application) deleteLoyaltyRule(w http.ResponseWriter, r *http.Request) {
+	ruleId, err := strconv.Atoi(mux.Vars(r)["ruleId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Rule ID")
+		return
+	}
+
+	err = app.Models.Loyalty.DeleteRule(ruleId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// getCustomerPoints returns a page of the customer's points ledger, newest first.
+func (app *Application) getCustomerPoints(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.Atoi(mux.Vars(r)["customerId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+		return
+	}
+
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "-id"
+	filters.SortSafelist = []string{"-id"}
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transactions, metadata, err := app.Models.Loyalty.Transactions(customerId, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"transactions": transactions, "metadata": metadata})
+}
+
+// adjustCustomerPoints books a manual correction of a customer's points balance.
+func (app *Application) adjustCustomerPoints(w http.ResponseWriter, r *http.Request) {
+	customerId, err := strconv.Atoi(mux.Vars(r)["customerId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+		return
+	}
+
+	var adjustment model.LoyaltyTransaction
+	err = json.NewDecoder(r.Body).Decode(&adjustment)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePointsAdjustment(v, &adjustment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	adjustment.EmployeeId = app.contextGetUser(r).Id
+	err = app.Models.Loyalty.Adjust(customerId, &adjustment)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Customer Not Found")
+		case errors.Is(err, model.ErrInsufficientPoints):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"transaction": adjustment})
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
-
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 
 	"net/http"
 	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
 	"strings"
 	"golang.org/x/time/rate"
 )
@@ -75,6 +79,50 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 }
 
 
+// identifyStation resolves the station credential a till sends in the X-Station-Token
+// header. Requests without one go through without a station, which only routes behind
+// requireStation refuse; requests from a disabled station are refused.
+func (app *Application) identifyStation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-Station-Token")
+
+		credential := r.Header.Get("X-Station-Token")
+		if credential == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		station, err := app.Models.Stations.GetForCredential(credential)
+		if err != nil {
+			if errors.Is(err, model.ErrRecordNotFound) {
+				app.invalidStationCredentialResponse(w, r)
+				return
+			}
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if station.Disabled() {
+			app.stationDisabledResponse(w, r, station)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetStation(r, station))
+	})
+}
+
+// requireStation refuses requests that do not come from a registered till, so that
+// disabling a station stops it from selling even with a valid employee token.
+func (app *Application) requireStation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetStation(r) == nil {
+			app.stationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 func (app *Application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -122,3 +170,128 @@ func (app *Application) requirePermission(code string, next http.HandlerFunc) ht
 
 	return app.requireActivatedUser(fn)
 }
+
+// hasPermission reports whether the authenticated user holds the given permission
+// code. Anonymous users hold none.
+func (app *Application) hasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+
+	permissions, err := app.Models.Permissions.GetAllForUser(user.Id)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
+// idempotent lets a till retry a request safely by sending the same Idempotency-Key
+// header. The first response for a key, employee and station is stored and replayed
+// on retries with the Idempotent-Replayed header set; the same key with a different
+// request is refused. The handler commits the key with its change, so a key is only
+// given back, and a retry runs again, when the request saved nothing. Server errors
+// are not stored.
+func (app *Application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+		if model.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+
+		k := &model.IdempotencyKey{
+			Key:         key,
+			EmployeeId:  app.contextGetUser(r).Id,
+			RequestHash: hash.Sum(nil),
+		}
+		if station := app.contextGetStation(r); station != nil {
+			k.StationId = station.Id
+		}
+
+		saved, err := app.Models.Idempotency.Begin(k)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrIdempotencyKeyReused):
+				app.respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			case errors.Is(err, model.ErrIdempotencyKeyInUse), errors.Is(err, model.ErrIdempotencyResponseLost):
+				app.respondWithError(w, http.StatusConflict, err.Error())
+			default:
+				app.respondWithError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+
+		if saved != nil {
+			w.Header().Set("Content-Type", saved.ContentType)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(saved.Status)
+			w.Write(saved.Body)
+			return
+		}
+
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := app.Models.Idempotency.Release(k); err != nil {
+				app.logger.PrintError(err, map[string]string{"request_url": r.URL.String()})
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, app.contextSetIdempotencyKey(r, k))
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+
+		saved = &model.SavedResponse{Status: rec.status, ContentType: w.Header().Get("Content-Type"), Body: rec.body.Bytes()}
+		if err := app.Models.Idempotency.Complete(k, saved); err != nil {
+			app.logger.PrintError(err, map[string]string{"request_url": r.URL.String()})
+			return
+		}
+		completed = true
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of its status and
+// body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createModifierGroup(w http.ResponseWriter, r *http.Request) {
+	var group model.ModifierGroup
+
+	err := json.NewDecoder(r.Body).Decode(&group)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateModifierGroup(v, &group); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Modifiers.Create(&group)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"modifier_group": group})
+}
+
+func (app *Application) getModifierGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.Atoi(mux.Vars(r)["groupId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Modifier Group ID")
+		return
+	}
+
+	group, err := app.Models.Modifiers.Get(groupId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"modifier_group": group})
+}
+
+func (app *Application) getAllModifierGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := app.Models.Modifiers.GetAll()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"modifier_groups": groups})
+}
+
+func (app *Application) updateModifierGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.Atoi(mux.Vars(r)["groupId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Modifier Group ID")
+		return
+	}
+
+	var group model.ModifierGroup
+	err = json.NewDecoder(r.Body).Decode(&group)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateModifierGroup(v, &group); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Modifiers.Update(groupId, &group)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"modifier_group": group})
+}
+
+func (app *Application) deleteModifierGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.Atoi(mux.Vars(r)["groupId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Modifier Group ID")
+		return
+	}
+
+	err = app.Models.Modifiers.Delete(groupId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (app *Application) getProductModifierGroups(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	groups, err := app.Models.Modifiers.GetForProduct(productId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"modifier_groups": groups})
+}
+
+// setProductModifierGroups replaces the modifier groups attached to a product with
+// the given group_ids.
+func (app *Application) setProductModifierGroups(w http.ResponseWriter, r *http.Request) {
+	productId, err := strconv.Atoi(mux.Vars(r)["productId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	var input struct {
+		GroupIds []int `json:"group_ids"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	groups, err := app.Models.Modifiers.SetForProduct(productId, input.GroupIds)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"modifier_groups": groups})
+}
@@ -2,9 +2,10 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
 	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
 	"strconv"
 
 	"github.com/gorilla/mux"
@@ -19,12 +20,46 @@ func (app *Application) createOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Order.Create(&newOrder)
-	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	v := validator.New()
+	if model.ValidateOrderProducts(v, newOrder.Products); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.allowManualDiscounts(w, r, newOrder.Products) {
 		return
 	}
 
+	newOrder.StoreId = app.Config.Store
+	if station := app.contextGetStation(r); station != nil {
+		newOrder.StationId = &station.Id
+		newOrder.StoreId = station.StoreId
+	}
+	newOrder.TaxInclusive = app.Config.PricesIncludeTax
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		newOrder.EmployeeID = user.Id
+	}
+
+	err = app.Models.Order.Checkout(&newOrder, app.auditActor(r), app.contextGetIdempotencyKey(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrOutOfStock):
+			app.outOfStockResponse(w, r, err)
+		case errors.Is(err, model.ErrInvalidModifiers):
+			app.failedValidationResponse(w, r, map[string]string{"modifiers": err.Error()})
+		case errors.Is(err, model.ErrProductHasVariants), errors.Is(err, model.ErrGiftCardSold), errors.Is(err, model.ErrIdempotencyKeyInUse):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, model.ErrUnknownGiftCard):
+			app.failedValidationResponse(w, r, map[string]string{"gift_card_code": err.Error()})
+		case errors.Is(err, model.ErrUnknownCustomer):
+			app.failedValidationResponse(w, r, map[string]string{"customer_id": err.Error()})
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Product Not Found")
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
 	app.respondWithJSON(w, http.StatusCreated, newOrder)
 }
 
@@ -44,6 +79,7 @@ func (app *Application) getOrder(w http.ResponseWriter, r *http.Request) {
 		return 
 	}
 
+	app.setETag(w, Order.Version)
 	app.respondWithJSON(w, http.StatusFound, Order)
 }
 
@@ -66,44 +102,54 @@ func (app *Application) addProductToOrder(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var product model.OrderProduct
-	err = json.NewDecoder(r.Body).Decode(&product)
+	var input struct {
+		model.OrderProduct
+		Version int `json:"version"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
 		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
 		return
 	}
+	product := input.OrderProduct
 
-	existingOrder, err := app.Models.Order.Get(orderId)
-	if err != nil {
-		app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+	v := validator.New()
+	expected := app.readIfMatch(r, input.Version, v)
+	v.Check(expected > 0, "version", "must be provided in the body or as If-Match")
+	if model.ValidateOrderProducts(v, []model.OrderProduct{product}); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	existingOrder.Products = append(existingOrder.Products, product)
-	existingOrder.TotalPrice += float64(product.Price) * float64(product.Qty)
+	if !app.allowManualDiscounts(w, r, []model.OrderProduct{product}) {
+		return
+	}
 
-	err = app.Models.Order.Update(orderId, existingOrder)
+	existingOrder, err := app.Models.Order.AddProduct(orderId, expected, &product, app.auditActor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r, existingOrder.Version)
+		case errors.Is(err, model.ErrOutOfStock):
+			app.outOfStockResponse(w, r, err)
+		case errors.Is(err, model.ErrInvalidModifiers):
+			app.failedValidationResponse(w, r, map[string]string{"modifiers": err.Error()})
+		case errors.Is(err, model.ErrProductHasVariants), errors.Is(err, model.ErrGiftCardSold), errors.Is(err, model.ErrOrderNotOpen):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, model.ErrUnknownGiftCard):
+			app.failedValidationResponse(w, r, map[string]string{"gift_card_code": err.Error()})
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Order Or Product Not Found")
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
+	app.setETag(w, existingOrder.Version)
 	app.respondWithJSON(w, http.StatusOK, existingOrder)
 }
 
-func removeProduct(products []model.OrderProduct, productId int) []model.OrderProduct {
-	var updatedProducts []model.OrderProduct
-	for _, p := range products {
-		if p.Id != productId {
-			updatedProducts = append(updatedProducts, p)
-		}
-	}
-	if updatedProducts != nil{
-		return updatedProducts
-	}
-	return []model.OrderProduct{}
-}
-
 func (app *Application) removeProductFromOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderIDStr := vars["id"]
@@ -121,33 +167,77 @@ func (app *Application) removeProductFromOrder(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	existingOrder, err := app.Models.Order.Get(orderID)
+	v := validator.New()
+	expected := app.readIfMatch(r, 0, v)
+	if v.Check(expected > 0, "If-Match", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	existingOrder, err := app.Models.Order.RemoveProduct(orderID, expected, productID, app.auditActor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r, existingOrder.Version)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		case errors.Is(err, model.ErrOrderNotOpen):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
-	updatedProducts := removeProduct(existingOrder.Products, productID)
-	updatedTotalPrice := calculateTotalPrice(updatedProducts)
+	app.setETag(w, existingOrder.Version)
+	app.respondWithJSON(w, http.StatusOK, existingOrder)
+}
 
-	existingOrder.Products = updatedProducts
-	existingOrder.TotalPrice = updatedTotalPrice
+// setOrderCustomer attaches a customer to an open order, or detaches it when
+// customer_id is null.
+func (app *Application) setOrderCustomer(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
 
-	err = app.Models.Order.Update(orderID, existingOrder)
+	var input struct {
+		CustomerId *int `json:"customer_id"`
+		Version    int  `json:"version"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
 		return
 	}
 
-	app.respondWithJSON(w, http.StatusOK, existingOrder)
-}
+	v := validator.New()
+	expected := app.readIfMatch(r, input.Version, v)
+	if v.Check(expected > 0, "version", "must be provided in the body or as If-Match"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-func calculateTotalPrice(products []model.OrderProduct) float64 {
-	totalPrice := 0.0
-	for _, p := range products {
-		totalPrice += float64(p.Price) * float64(p.Qty)
+	order, err := app.Models.Order.SetCustomer(orderId, expected, input.CustomerId, app.auditActor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r, order.Version)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		case errors.Is(err, model.ErrUnknownCustomer):
+			app.failedValidationResponse(w, r, map[string]string{"customer_id": err.Error()})
+		case errors.Is(err, model.ErrOrderAlreadyPaid), errors.Is(err, model.ErrOrderNotOpen), errors.Is(err, model.ErrPointsRedeemed):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
 	}
-	return totalPrice
+
+	app.setETag(w, order.Version)
+	app.respondWithJSON(w, http.StatusOK, order)
 }
 
 func (app *Application) deleteOrder(w http.ResponseWriter, r *http.Request) {
@@ -155,17 +245,138 @@ func (app *Application) deleteOrder(w http.ResponseWriter, r *http.Request) {
 	param := vars["id"]
 
 	orderId, err := strconv.Atoi(param)
-	fmt.Println(orderId)
 	if err != nil {
 		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
 		return
 	}
 
-	err = app.Models.Order.Delete(orderId)
+	err = app.Models.Order.Delete(orderId, app.auditActor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		case errors.Is(err, model.ErrOrderNotDeletable):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
-
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
+
+// holdOrder parks an open order so the till can start another one.
+func (app *Application) holdOrder(w http.ResponseWriter, r *http.Request) {
+	app.transitionOrder(w, r, app.Models.Order.Hold)
+}
+
+// resumeOrder reopens a held order.
+func (app *Application) resumeOrder(w http.ResponseWriter, r *http.Request) {
+	app.transitionOrder(w, r, app.Models.Order.Resume)
+}
+
+func (app *Application) transitionOrder(w http.ResponseWriter, r *http.Request, transition func(int, model.AuditActor) (*model.Order, error)) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	order, err := transition(orderId, app.auditActor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		case errors.Is(err, model.ErrInvalidTransition):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	app.respondWithJSON(w, http.StatusOK, order)
+}
+
+// voidOrder closes an order for good with a reason. A paid order is refunded in full
+// with the given method first.
+func (app *Application) voidOrder(w http.ResponseWriter, r *http.Request) {
+	orderId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	var input struct {
+		Reason       string `json:"reason"`
+		Method       string `json:"method"`
+		GiftCardCode string `json:"gift_card_code"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 characters long")
+	v.Check(input.Method == "" || validator.In(input.Method, model.TenderCash, model.TenderCard, model.TenderTransfer, model.TenderPoints, model.RefundStoreCredit),
+		"method", "must be cash, card, transfer, points or store_credit")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refund := &model.Refund{Method: input.Method, GiftCardCode: input.GiftCardCode}
+	order, err := app.Models.Order.Void(orderId, app.contextGetUser(r).Id, input.Reason, refund, app.auditActor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		case errors.Is(err, model.ErrVoidNeedsRefund):
+			app.failedValidationResponse(w, r, map[string]string{"method": err.Error()})
+		case errors.Is(err, model.ErrUnknownGiftCard):
+			app.failedValidationResponse(w, r, map[string]string{"gift_card_code": err.Error()})
+		case errors.Is(err, model.ErrInvalidTransition), errors.Is(err, model.ErrOrderHasPayments), errors.Is(err, model.ErrRefundExceedsSale),
+			errors.Is(err, model.ErrNoCustomer), errors.Is(err, model.ErrGiftCardInactive), errors.Is(err, model.ErrGiftCardUsed),
+			errors.Is(err, model.ErrNoOpenShift):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	resp := envelope{"order": order}
+	if refund.Id != 0 {
+		resp["refund"] = refund
+	}
+	app.respondWithJSON(w, http.StatusOK, resp)
+}
+
+// allowManualDiscounts checks that the user may give the manual discounts requested
+// on the lines. It writes the error response itself and reports whether the handler
+// should continue.
+func (app *Application) allowManualDiscounts(w http.ResponseWriter, r *http.Request, products []model.OrderProduct) bool {
+	requested := false
+	for _, p := range products {
+		if p.ManualDiscount.IsPositive() {
+			requested = true
+			break
+		}
+	}
+	if !requested {
+		return true
+	}
+
+	allowed, err := app.hasPermission(r, model.PermissionManualDiscount)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
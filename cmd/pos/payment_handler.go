@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createPayment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["id"]
+
+	orderId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	var input struct {
+		Tenders []model.Payment `json:"tenders"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	model.ValidatePayments(v, input.Tenders)
+	if station := app.contextGetStation(r); station != nil {
+		for i, t := range input.Tenders {
+			v.Check(station.AcceptsTender(t.Method), fmt.Sprintf("tenders[%d].method", i), "is not enabled on this station")
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	employee := app.contextGetUser(r)
+
+	result, err := app.Models.Payments.Create(orderId, employee.Id, input.Tenders, app.auditActor(r), app.contextGetIdempotencyKey(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+		case errors.Is(err, model.ErrOrderAlreadyPaid), errors.Is(err, model.ErrOrderNotOpen), errors.Is(err, model.ErrTenderExceedsDue),
+			errors.Is(err, model.ErrNoCustomer), errors.Is(err, model.ErrInsufficientPoints),
+			errors.Is(err, model.ErrGiftCardInactive), errors.Is(err, model.ErrInsufficientBalance), errors.Is(err, model.ErrNoOpenShift),
+			errors.Is(err, model.ErrIdempotencyKeyInUse):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, model.ErrUnknownGiftCard):
+			app.failedValidationResponse(w, r, map[string]string{"gift_card_code": err.Error()})
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, result)
+}
+
+func (app *Application) getOrderPayments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["id"]
+
+	orderId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	payments, err := app.Models.Payments.GetAllForOrder(orderId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"payments": payments})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 
 	"net/http"
 	"pos-rs/pkg/pos/model"
@@ -20,9 +21,15 @@ func (app *Application) createProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Product.Create(&newProduct)
+	v := validator.New()
+	if model.ValidateProduct(v, &newProduct); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Product.Create(&newProduct, app.auditActor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		app.productErrorResponse(w, r, err)
 		return
 	}
 
@@ -45,9 +52,33 @@ func (app *Application) getProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.setETag(w, Product.Version)
 	app.respondWithJSON(w, http.StatusFound, Product)
 }
 
+// lookupProduct finds a product by a scanned EAN-8, UPC-A or EAN-13 barcode.
+func (app *Application) lookupProduct(w http.ResponseWriter, r *http.Request) {
+	barcode := app.readString(r.URL.Query(), "barcode", "")
+
+	v := validator.New()
+	if v.Check(model.ValidBarcode(barcode), "barcode", "must be a valid EAN-8, UPC-A or EAN-13 code"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	product, err := app.Models.Product.GetByBarcode(barcode)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, product)
+}
+
 func (app *Application) getAllProduct(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name     string
@@ -59,15 +90,17 @@ func (app *Application) getAllProduct(w http.ResponseWriter, r *http.Request) {
 	qs := r.URL.Query()
 
 	input.Name = app.readString(qs, "name", "")
-	input.Cateogry = app.readInt(qs, "category", 1, v)
+	input.Cateogry = app.readInt(qs, "category", 0, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "name", "price", "-id", "-name", "-price"}
 
-	if !v.Valid() {
-		app.respondWithError(w, http.StatusForbidden, "Failed Validation")
+	v.Check(input.Cateogry >= 0, "category", "must not be negative")
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	products, metadata, err := app.Models.Product.GetAll(input.Name, input.Cateogry, input.Filters)
@@ -96,13 +129,26 @@ func (app *Application) updateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Product.Update(productId, &updatedProduct)
+	v := validator.New()
+	updatedProduct.Version = app.readIfMatch(r, updatedProduct.Version, v)
+	v.Check(updatedProduct.Version > 0, "version", "must be provided in the body or as If-Match")
+	if model.ValidateProduct(v, &updatedProduct); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Product.Update(productId, &updatedProduct, app.auditActor(r))
 	updatedProduct.Id = productId
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		if errors.Is(err, model.ErrEditConflict) {
+			app.editConflictResponse(w, r, updatedProduct.Version)
+			return
+		}
+		app.productErrorResponse(w, r, err)
 		return
 	}
 
+	app.setETag(w, updatedProduct.Version)
 	app.respondWithJSON(w, http.StatusOK, updatedProduct)
 }
 
@@ -115,11 +161,53 @@ func (app *Application) deleteProduct(w http.ResponseWriter, r *http.Request) {
 		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
 		return
 	}
-	err = app.Models.Product.Delete(productId)
+	err = app.Models.Product.Delete(productId, app.auditActor(r))
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+		case errors.Is(err, model.ErrProductHasVariants), errors.Is(err, model.ErrProductHasHistory):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
 	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
+
+// getProductVariants lists the variants grouped under a product.
+func (app *Application) getProductVariants(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["productId"]
+
+	productId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	variants, err := app.Models.Product.GetVariants(productId)
+	if err != nil {
+		app.productErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"variants": variants})
+}
+
+func (app *Application) productErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, model.ErrDuplicateBarcode), errors.Is(err, model.ErrDuplicateSku):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, model.ErrInvalidParent):
+		app.failedValidationResponse(w, r, map[string]string{"parentId": err.Error()})
+	case errors.Is(err, model.ErrProductHasVariants):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, "Not Found")
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
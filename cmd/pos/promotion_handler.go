@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createPromotion(w http.ResponseWriter, r *http.Request) {
+	var newPromotion model.Promotion
+
+	err := json.NewDecoder(r.Body).Decode(&newPromotion)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePromotion(v, &newPromotion); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Promotions.Create(&newPromotion)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, newPromotion)
+}
+
+func (app *Application) getPromotion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["promotionId"]
+
+	promotionId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Promotion ID")
+		return
+	}
+
+	promotion, err := app.Models.Promotions.Get(promotionId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, promotion)
+}
+
+func (app *Application) getAllPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := app.Models.Promotions.GetAll()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"promotions": promotions})
+}
+
+func (app *Application) updatePromotion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["promotionId"]
+
+	promotionId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Promotion ID")
+		return
+	}
+
+	var updatedPromotion model.Promotion
+	err = json.NewDecoder(r.Body).Decode(&updatedPromotion)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePromotion(v, &updatedPromotion); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Promotions.Update(promotionId, &updatedPromotion)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, updatedPromotion)
+}
+
+func (app *Application) deletePromotion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["promotionId"]
+
+	promotionId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Promotion ID")
+		return
+	}
+
+	err = app.Models.Promotions.Delete(promotionId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var po model.PurchaseOrder
+
+	err := json.NewDecoder(r.Body).Decode(&po)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePurchaseOrder(v, &po); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	po.EmployeeId = app.contextGetUser(r).Id
+
+	err = app.Models.Purchasing.Create(&po)
+	if err != nil {
+		app.purchaseOrderErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"purchase_order": po})
+}
+
+func (app *Application) getPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	po, err := app.Models.Purchasing.Get(id)
+	if err != nil {
+		app.purchaseOrderErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"purchase_order": po})
+}
+
+// getAllPurchaseOrders returns a page of purchase orders, optionally filtered by
+// status and supplier_id.
+func (app *Application) getAllPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status     string
+		SupplierId int
+		model.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.SupplierId = app.readInt(qs, "supplier_id", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "status", "-id", "-created_at", "-status"}
+
+	v.Check(input.Status == "" || validator.In(input.Status, model.PurchaseOrderStatuses...), "status", "invalid status")
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.Models.Purchasing.GetAll(input.Status, input.SupplierId, input.Filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"purchase_orders": orders, "metadata": metadata})
+}
+
+func (app *Application) updatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	var po model.PurchaseOrder
+	err = json.NewDecoder(r.Body).Decode(&po)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidatePurchaseOrder(v, &po); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Purchasing.Update(id, &po)
+	if err != nil {
+		app.purchaseOrderErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"purchase_order": po})
+}
+
+func (app *Application) sendPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	app.setPurchaseOrderStatus(w, r, model.PurchaseSent)
+}
+
+func (app *Application) cancelPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	app.setPurchaseOrderStatus(w, r, model.PurchaseCancelled)
+}
+
+func (app *Application) setPurchaseOrderStatus(w http.ResponseWriter, r *http.Request, status string) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	po, err := app.Models.Purchasing.SetStatus(id, status)
+	if err != nil {
+		app.purchaseOrderErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"purchase_order": po})
+}
+
+// receivePurchaseOrder books a goods receipt: the received lines go into stock and
+// update the products' cost prices.
+func (app *Application) receivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	var receipt model.GoodsReceipt
+	err = json.NewDecoder(r.Body).Decode(&receipt)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateGoodsReceipt(v, &receipt); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	receipt.EmployeeId = app.contextGetUser(r).Id
+
+	po, err := app.Models.Purchasing.Receive(id, &receipt)
+	if err != nil {
+		app.purchaseOrderErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"receipt": receipt, "purchase_order": po})
+}
+
+func (app *Application) getPurchaseOrderReceipts(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Purchase Order ID")
+		return
+	}
+
+	receipts, err := app.Models.Purchasing.GetReceipts(id)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"receipts": receipts})
+}
+
+func (app *Application) purchaseOrderErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, model.ErrUnknownSupplier):
+		app.failedValidationResponse(w, r, map[string]string{"supplier_id": err.Error()})
+	case errors.Is(err, model.ErrUnknownProduct):
+		app.failedValidationResponse(w, r, map[string]string{"lines": err.Error()})
+	case errors.Is(err, model.ErrPurchaseOrderStatus), errors.Is(err, model.ErrReceiptExceedsOrder):
+		app.respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
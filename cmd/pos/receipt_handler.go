@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/receipt"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// getOrderReceipt renders the receipt of a paid order. The format query parameter
+// selects text, escpos or json output and width selects 58 or 80mm paper, by default
+// the printer width of the requesting station.
+func (app *Application) getOrderReceipt(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["id"]
+
+	orderId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	defaultWidth := receipt.Width80
+	if station := app.contextGetStation(r); station != nil {
+		defaultWidth = station.PrinterWidth
+	}
+
+	format := app.readString(qs, "format", "json")
+	width := app.readInt(qs, "width", defaultWidth, v)
+
+	v.Check(validator.In(format, "text", "escpos", "json"), "format", "must be text, escpos or json")
+	v.Check(receipt.ValidWidth(width), "width", "must be 58 or 80")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	order, err := app.Models.Order.Get(orderId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Order Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if order.PaidAt == nil {
+		app.respondWithError(w, http.StatusConflict, "order is not paid yet")
+		return
+	}
+
+	payments, err := app.Models.Payments.GetAllForOrder(orderId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rcpt := receipt.New(order, payments)
+
+	switch format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(rcpt.Text(width)))
+	case "escpos":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write(rcpt.ESCPOS(width))
+	default:
+		app.respondWithJSON(w, http.StatusOK, envelope{"receipt": rcpt})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createRefund(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["id"]
+
+	orderId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	var refund model.Refund
+	err = json.NewDecoder(r.Body).Decode(&refund)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateRefund(v, &refund); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refund.EmployeeId = app.contextGetUser(r).Id
+
+	err = app.Models.Refunds.Create(orderId, &refund, app.auditActor(r), app.contextGetIdempotencyKey(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, model.ErrOrderNotPaid), errors.Is(err, model.ErrInvalidTransition), errors.Is(err, model.ErrRefundExceedsSale), errors.Is(err, model.ErrNoCustomer),
+			errors.Is(err, model.ErrGiftCardInactive), errors.Is(err, model.ErrGiftCardUsed), errors.Is(err, model.ErrNoOpenShift),
+			errors.Is(err, model.ErrIdempotencyKeyInUse):
+			app.respondWithError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, model.ErrUnknownGiftCard):
+			app.failedValidationResponse(w, r, map[string]string{"gift_card_code": err.Error()})
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"refund": refund})
+}
+
+func (app *Application) getOrderRefunds(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["id"]
+
+	orderId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Order ID")
+		return
+	}
+
+	refunds, err := app.Models.Refunds.GetAllForOrder(orderId)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"refunds": refunds})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"time"
+)
+
+// getSalesReport aggregates sales between from and to (both inclusive dates) grouped
+// by day, hour, product, category or employee.
+func (app *Application) getSalesReport(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		From    time.Time
+		To      time.Time
+		GroupBy string
+		model.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	input.From = app.readDate(qs, "from", today.AddDate(0, 0, -30), v)
+	input.To = app.readDate(qs, "to", today, v)
+	input.GroupBy = app.readString(qs, "group_by", "day")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "key")
+	input.Filters.SortSafelist = []string{"key", "label", "quantity", "gross", "refunds", "tax", "net",
+		"-key", "-label", "-quantity", "-gross", "-refunds", "-tax", "-net"}
+
+	v.Check(validator.In(input.GroupBy, model.SalesGroupings...), "group_by", "must be day, hour, product, category or employee")
+	v.Check(!input.To.Before(input.From), "to", "must not be before from")
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	report, total, metadata, err := app.Models.Reports.Sales(input.From, input.To.AddDate(0, 0, 1), input.GroupBy, input.Filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"sales": report, "total": total, "metadata": metadata})
+}
+
+// getTaxReport sums the VAT collected between from and to (both inclusive dates) per
+// tax rate.
+func (app *Application) getTaxReport(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	from := app.readDate(qs, "from", today.AddDate(0, 0, -30), v)
+	to := app.readDate(qs, "to", today, v)
+
+	if v.Check(!to.Before(from), "to", "must not be before from"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	taxes, err := app.Models.Reports.Tax(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"taxes": taxes})
+}
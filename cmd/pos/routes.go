@@ -24,23 +24,121 @@ func (app *Application) routes() http.Handler {
 	v1.HandleFunc("/employees/{id}", app.requireActivatedUser(app.deleteEmployee)).Methods("DELETE")
 
 	v1.HandleFunc("/categories", app.getAllCategory).Methods("GET")
+	v1.HandleFunc("/categories/tree", app.getCategoryTree).Methods("GET")
 	v1.HandleFunc("/categories/{categoryId}", app.getCategory).Methods("GET")
 	v1.HandleFunc("/categories", app.createCategory).Methods("POST")
 	v1.HandleFunc("/categories/{categoryId}", app.updateCategory).Methods("PUT")
 	v1.HandleFunc("/categories/{categoryId}", app.deleteCategory).Methods("DELETE")
+	v1.HandleFunc("/categories/{categoryId}/move", app.moveCategory).Methods("POST")
+	v1.HandleFunc("/categories/reorder", app.reorderCategories).Methods("POST")
 
 	v1.HandleFunc("/products", app.getAllProduct).Methods("GET")
+	v1.HandleFunc("/products/lookup", app.lookupProduct).Methods("GET")
+	v1.HandleFunc("/products/export", app.requireActivatedUser(app.exportProducts)).Methods("GET")
+	v1.HandleFunc("/products/import", app.requirePermission("products:write", app.importProducts)).Methods("POST")
 	v1.HandleFunc("/products/{productId}", app.getProduct).Methods("GET")
 	v1.HandleFunc("/products", app.createProduct).Methods("POST")
 	v1.HandleFunc("/products/{productId}", app.updateProduct).Methods("PUT")
 	v1.HandleFunc("/products/{productId}", app.requirePermission("products:write",app.deleteProduct)).Methods("DELETE")
+	v1.HandleFunc("/products/{productId}/variants", app.getProductVariants).Methods("GET")
+	v1.HandleFunc("/products/{productId}/modifier-groups", app.getProductModifierGroups).Methods("GET")
+	v1.HandleFunc("/products/{productId}/modifier-groups", app.requirePermission("products:write", app.setProductModifierGroups)).Methods("PUT")
+	v1.HandleFunc("/products/{productId}/stock-movements", app.requireActivatedUser(app.getProductStockMovements)).Methods("GET")
+	v1.HandleFunc("/products/{productId}/stock-movements", app.requirePermission("inventory:write", app.createStockMovement)).Methods("POST")
+
+	v1.HandleFunc("/modifier-groups", app.getAllModifierGroups).Methods("GET")
+	v1.HandleFunc("/modifier-groups/{groupId}", app.getModifierGroup).Methods("GET")
+	v1.HandleFunc("/modifier-groups", app.requirePermission("products:write", app.createModifierGroup)).Methods("POST")
+	v1.HandleFunc("/modifier-groups/{groupId}", app.requirePermission("products:write", app.updateModifierGroup)).Methods("PUT")
+	v1.HandleFunc("/modifier-groups/{groupId}", app.requirePermission("products:write", app.deleteModifierGroup)).Methods("DELETE")
+
+	v1.HandleFunc("/inventory/discrepancies", app.requireActivatedUser(app.getStockDiscrepancies)).Methods("GET")
+	v1.HandleFunc("/inventory/low-stock", app.requireActivatedUser(app.getLowStock)).Methods("GET")
+
+	v1.HandleFunc("/suppliers", app.requireActivatedUser(app.getAllSuppliers)).Methods("GET")
+	v1.HandleFunc("/suppliers/{supplierId}", app.requireActivatedUser(app.getSupplier)).Methods("GET")
+	v1.HandleFunc("/suppliers", app.requirePermission("purchasing:write", app.createSupplier)).Methods("POST")
+	v1.HandleFunc("/suppliers/{supplierId}", app.requirePermission("purchasing:write", app.updateSupplier)).Methods("PUT")
+	v1.HandleFunc("/suppliers/{supplierId}", app.requirePermission("purchasing:write", app.deleteSupplier)).Methods("DELETE")
+
+	v1.HandleFunc("/purchase-orders", app.requireActivatedUser(app.getAllPurchaseOrders)).Methods("GET")
+	v1.HandleFunc("/purchase-orders/{id}", app.requireActivatedUser(app.getPurchaseOrder)).Methods("GET")
+	v1.HandleFunc("/purchase-orders", app.requirePermission("purchasing:write", app.createPurchaseOrder)).Methods("POST")
+	v1.HandleFunc("/purchase-orders/{id}", app.requirePermission("purchasing:write", app.updatePurchaseOrder)).Methods("PUT")
+	v1.HandleFunc("/purchase-orders/{id}/send", app.requirePermission("purchasing:write", app.sendPurchaseOrder)).Methods("POST")
+	v1.HandleFunc("/purchase-orders/{id}/cancel", app.requirePermission("purchasing:write", app.cancelPurchaseOrder)).Methods("POST")
+	v1.HandleFunc("/purchase-orders/{id}/receipts", app.requireActivatedUser(app.getPurchaseOrderReceipts)).Methods("GET")
+	v1.HandleFunc("/purchase-orders/{id}/receipts", app.requirePermission("purchasing:write", app.receivePurchaseOrder)).Methods("POST")
 
 	v1.HandleFunc("/orders", app.getAllOrders).Methods("GET")
 	v1.HandleFunc("/orders/{id}", app.getOrder).Methods("GET")
-	v1.HandleFunc("/orders", app.createOrder).Methods("POST")
-	v1.HandleFunc("/orders/{id}/products", app.addProductToOrder).Methods("PUT")
-	v1.HandleFunc("/orders/{id}/products/{productId}", app.removeProductFromOrder).Methods("PUT")
-	v1.HandleFunc("/orders/{id}", app.deleteOrder).Methods("DELETE")
+	v1.HandleFunc("/orders", app.requireStation(app.idempotent(app.createOrder))).Methods("POST")
+	v1.HandleFunc("/orders/{id}/products", app.requireStation(app.addProductToOrder)).Methods("PUT")
+	v1.HandleFunc("/orders/{id}/products/{productId}", app.requireStation(app.removeProductFromOrder)).Methods("PUT")
+	v1.HandleFunc("/orders/{id}", app.requireStation(app.deleteOrder)).Methods("DELETE")
+	v1.HandleFunc("/orders/{id}/customer", app.requireStation(app.requireActivatedUser(app.setOrderCustomer))).Methods("PUT")
+	v1.HandleFunc("/orders/{id}/hold", app.requireStation(app.requireActivatedUser(app.holdOrder))).Methods("POST")
+	v1.HandleFunc("/orders/{id}/resume", app.requireStation(app.requireActivatedUser(app.resumeOrder))).Methods("POST")
+	v1.HandleFunc("/orders/{id}/void", app.requireStation(app.requirePermission("orders:void", app.voidOrder))).Methods("POST")
+	v1.HandleFunc("/orders/{id}/payments", app.getOrderPayments).Methods("GET")
+	v1.HandleFunc("/orders/{id}/payments", app.requireStation(app.requireActivatedUser(app.idempotent(app.createPayment)))).Methods("POST")
+	v1.HandleFunc("/orders/{id}/receipt", app.getOrderReceipt).Methods("GET")
+	v1.HandleFunc("/orders/{id}/refunds", app.getOrderRefunds).Methods("GET")
+	v1.HandleFunc("/orders/{id}/refunds", app.requireStation(app.requireActivatedUser(app.idempotent(app.createRefund)))).Methods("POST")
+
+	v1.HandleFunc("/customers", app.requireActivatedUser(app.getAllCustomers)).Methods("GET")
+	v1.HandleFunc("/customers/lookup", app.requireActivatedUser(app.lookupCustomer)).Methods("GET")
+	v1.HandleFunc("/customers/{customerId}", app.requireActivatedUser(app.getCustomer)).Methods("GET")
+	v1.HandleFunc("/customers", app.requireActivatedUser(app.createCustomer)).Methods("POST")
+	v1.HandleFunc("/customers/{customerId}", app.requireActivatedUser(app.updateCustomer)).Methods("PUT")
+	v1.HandleFunc("/customers/{customerId}", app.requirePermission("loyalty:write", app.deleteCustomer)).Methods("DELETE")
+	v1.HandleFunc("/customers/{customerId}/orders", app.requireActivatedUser(app.getCustomerOrders)).Methods("GET")
+	v1.HandleFunc("/customers/{customerId}/points", app.requireActivatedUser(app.getCustomerPoints)).Methods("GET")
+	v1.HandleFunc("/customers/{customerId}/points", app.requirePermission("loyalty:write", app.adjustCustomerPoints)).Methods("POST")
+
+	v1.HandleFunc("/loyalty/rules", app.requireActivatedUser(app.getAllLoyaltyRules)).Methods("GET")
+	v1.HandleFunc("/loyalty/rules/{ruleId}", app.requireActivatedUser(app.getLoyaltyRule)).Methods("GET")
+	v1.HandleFunc("/loyalty/rules", app.requirePermission("loyalty:write", app.createLoyaltyRule)).Methods("POST")
+	v1.HandleFunc("/loyalty/rules/{ruleId}", app.requirePermission("loyalty:write", app.updateLoyaltyRule)).Methods("PUT")
+	v1.HandleFunc("/loyalty/rules/{ruleId}", app.requirePermission("loyalty:write", app.deleteLoyaltyRule)).Methods("DELETE")
+
+	v1.HandleFunc("/gift-cards", app.requireActivatedUser(app.getAllGiftCards)).Methods("GET")
+	v1.HandleFunc("/gift-cards/lookup", app.requireActivatedUser(app.lookupGiftCard)).Methods("GET")
+	v1.HandleFunc("/gift-cards/{giftCardId}", app.requireActivatedUser(app.getGiftCard)).Methods("GET")
+	v1.HandleFunc("/gift-cards/{giftCardId}/transactions", app.requireActivatedUser(app.getGiftCardTransactions)).Methods("GET")
+	v1.HandleFunc("/gift-cards", app.requirePermission("giftcards:write", app.issueGiftCard)).Methods("POST")
+	v1.HandleFunc("/gift-cards/{giftCardId}/void", app.requirePermission("giftcards:write", app.voidGiftCard)).Methods("POST")
+
+	v1.HandleFunc("/promotions", app.getAllPromotions).Methods("GET")
+	v1.HandleFunc("/promotions/{promotionId}", app.getPromotion).Methods("GET")
+	v1.HandleFunc("/promotions", app.requirePermission("promotions:write", app.createPromotion)).Methods("POST")
+	v1.HandleFunc("/promotions/{promotionId}", app.requirePermission("promotions:write", app.updatePromotion)).Methods("PUT")
+	v1.HandleFunc("/promotions/{promotionId}", app.requirePermission("promotions:write", app.deletePromotion)).Methods("DELETE")
+
+	v1.HandleFunc("/shifts", app.requireStation(app.requireActivatedUser(app.openShift))).Methods("POST")
+	v1.HandleFunc("/shifts/current", app.requireStation(app.requireActivatedUser(app.getCurrentShift))).Methods("GET")
+	v1.HandleFunc("/shifts/current/cash-movements", app.requireStation(app.requireActivatedUser(app.createCashMovement))).Methods("POST")
+	v1.HandleFunc("/shifts/current/x-report", app.requireStation(app.requireActivatedUser(app.getXReport))).Methods("GET")
+	v1.HandleFunc("/shifts/current/close", app.requireStation(app.requireActivatedUser(app.closeShift))).Methods("POST")
+	v1.HandleFunc("/shifts/{id}/z-report", app.requireActivatedUser(app.getZReport)).Methods("GET")
+
+	v1.HandleFunc("/stations", app.requireActivatedUser(app.getAllStations)).Methods("GET")
+	v1.HandleFunc("/stations/current", app.getCurrentStation).Methods("GET")
+	v1.HandleFunc("/stations/{stationId}", app.requireActivatedUser(app.getStation)).Methods("GET")
+	v1.HandleFunc("/stations", app.requirePermission("stations:write", app.registerStation)).Methods("POST")
+	v1.HandleFunc("/stations/{stationId}", app.requirePermission("stations:write", app.updateStation)).Methods("PUT")
+	v1.HandleFunc("/stations/{stationId}/credential", app.requirePermission("stations:write", app.rotateStationCredential)).Methods("POST")
+	v1.HandleFunc("/stations/{stationId}/disable", app.requirePermission("stations:write", app.disableStation)).Methods("POST")
+	v1.HandleFunc("/stations/{stationId}/enable", app.requirePermission("stations:write", app.enableStation)).Methods("POST")
+
+	v1.HandleFunc("/sync/catalog", app.requireStation(app.requireActivatedUser(app.getCatalogSnapshot))).Methods("GET")
+	v1.HandleFunc("/sync/changes", app.requireStation(app.requireActivatedUser(app.getCatalogChanges))).Methods("GET")
+	v1.HandleFunc("/sync/orders", app.requireStation(app.requireActivatedUser(app.uploadOfflineOrders))).Methods("POST")
+
+	v1.HandleFunc("/reports/sales", app.requireActivatedUser(app.getSalesReport)).Methods("GET")
+	v1.HandleFunc("/reports/tax", app.requireActivatedUser(app.getTaxReport)).Methods("GET")
+
+	v1.HandleFunc("/audit", app.requirePermission("audit:read", app.getAuditLog)).Methods("GET")
 
-	return app.recoverPanic(app.rateLimit(app.authenticate(r)))
+	return app.recoverPanic(app.rateLimit(app.authenticate(app.identifyStation(r))))
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) openShift(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		OpeningFloat model.Money `json:"opening_float"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if v.Check(!input.OpeningFloat.IsNegative(), "opening_float", "must not be negative"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	shift := &model.Shift{
+		EmployeeId:   app.contextGetUser(r).Id,
+		StoreId:      app.Config.Store,
+		OpeningFloat: input.OpeningFloat,
+	}
+	if station := app.contextGetStation(r); station != nil {
+		shift.StationId = &station.Id
+		shift.StoreId = station.StoreId
+	}
+
+	err = app.Models.Shifts.Open(shift)
+	if err != nil {
+		if errors.Is(err, model.ErrShiftAlreadyOpen) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"shift": shift})
+}
+
+func (app *Application) getCurrentShift(w http.ResponseWriter, r *http.Request) {
+	shift, ok := app.currentShift(w, r)
+	if !ok {
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"shift": shift})
+}
+
+func (app *Application) createCashMovement(w http.ResponseWriter, r *http.Request) {
+	shift, ok := app.currentShift(w, r)
+	if !ok {
+		return
+	}
+
+	var movement model.CashMovement
+	err := json.NewDecoder(r.Body).Decode(&movement)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateCashMovement(v, &movement); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movement.ShiftId = shift.Id
+	movement.EmployeeId = shift.EmployeeId
+
+	err = app.Models.Shifts.AddCashMovement(&movement)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"cash_movement": movement})
+}
+
+func (app *Application) getXReport(w http.ResponseWriter, r *http.Request) {
+	shift, ok := app.currentShift(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := app.Models.Shifts.Report(shift)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"report": report})
+}
+
+func (app *Application) closeShift(w http.ResponseWriter, r *http.Request) {
+	shift, ok := app.currentShift(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CountedCash *model.Money `json:"counted_cash"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CountedCash != nil, "counted_cash", "must be provided")
+	v.Check(input.CountedCash == nil || !input.CountedCash.IsNegative(), "counted_cash", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	report, err := app.Models.Shifts.Close(shift, *input.CountedCash)
+	if err != nil {
+		if errors.Is(err, model.ErrNoOpenShift) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"report": report})
+}
+
+func (app *Application) getZReport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["id"]
+
+	shiftId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Shift ID")
+		return
+	}
+
+	shift, err := app.Models.Shifts.Get(shiftId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Shift Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if shift.ClosedAt == nil {
+		app.respondWithError(w, http.StatusConflict, "shift is still open, use the X report")
+		return
+	}
+
+	report, err := app.Models.Shifts.Report(shift)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"report": report})
+}
+
+// currentShift loads the open shift of the authenticated employee. It writes the
+// error response itself and reports whether the handler should continue.
+func (app *Application) currentShift(w http.ResponseWriter, r *http.Request) (*model.Shift, bool) {
+	shift, err := app.Models.Shifts.GetOpenForEmployee(app.contextGetUser(r).Id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoOpenShift) {
+			app.respondWithError(w, http.StatusNotFound, err.Error())
+			return nil, false
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	return shift, true
+}
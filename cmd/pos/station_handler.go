@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/receipt"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// registerStation creates a station. The response carries its device credential,
+// which is not shown again.
+func (app *Application) registerStation(w http.ResponseWriter, r *http.Request) {
+	newStation := model.Station{
+		StoreId:      app.Config.Store,
+		PrinterWidth: receipt.Width80,
+		Tenders:      append([]string{}, model.Tenders...),
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&newStation)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateStation(v, &newStation, receipt.Width58, receipt.Width80); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Stations.Register(&newStation)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"station": newStation})
+}
+
+func (app *Application) getStation(w http.ResponseWriter, r *http.Request) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	station, err := app.Models.Stations.Get(stationId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"station": station})
+}
+
+// getCurrentStation returns the configuration of the station whose credential the
+// request carries, for the till to set itself up with.
+func (app *Application) getCurrentStation(w http.ResponseWriter, r *http.Request) {
+	station := app.contextGetStation(r)
+	if station == nil {
+		app.invalidStationCredentialResponse(w, r)
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"station": station})
+}
+
+func (app *Application) getAllStations(w http.ResponseWriter, r *http.Request) {
+	stations, err := app.Models.Stations.GetAll()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"stations": stations})
+}
+
+func (app *Application) updateStation(w http.ResponseWriter, r *http.Request) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	var updatedStation model.Station
+	err = json.NewDecoder(r.Body).Decode(&updatedStation)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateStation(v, &updatedStation, receipt.Width58, receipt.Width80); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Stations.Update(stationId, &updatedStation)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"station": updatedStation})
+}
+
+// rotateStationCredential issues a new device credential for a station, for a
+// replaced device or a leaked credential. The old credential stops working at once.
+func (app *Application) rotateStationCredential(w http.ResponseWriter, r *http.Request) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	station, err := app.Models.Stations.RotateCredential(stationId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"station": station})
+}
+
+// disableStation remotely locks a station out; every request carrying its credential
+// is refused until it is enabled again.
+func (app *Application) disableStation(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	app.setStationDisabled(w, r, true, input.Reason)
+}
+
+func (app *Application) enableStation(w http.ResponseWriter, r *http.Request) {
+	app.setStationDisabled(w, r, false, "")
+}
+
+func (app *Application) setStationDisabled(w http.ResponseWriter, r *http.Request, disabled bool, reason string) {
+	stationId, err := strconv.Atoi(mux.Vars(r)["stationId"])
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Station ID")
+		return
+	}
+
+	station, err := app.Models.Stations.SetDisabled(stationId, disabled, reason)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"station": station})
+}
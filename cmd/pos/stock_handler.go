@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// createStockMovement records a receipt, adjustment, write-off or transfer against a
+// product. Qty is the signed change of the on-hand amount.
+func (app *Application) createStockMovement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["productId"]
+
+	productId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	var movement model.StockMovement
+	err = json.NewDecoder(r.Body).Decode(&movement)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateStockMovement(v, &movement, app.Config.Store); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movement.ProductId = productId
+	movement.EmployeeId = app.contextGetUser(r).Id
+
+	err = app.Models.Stock.Create(&movement)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "Product Not Found")
+		case errors.Is(err, model.ErrOutOfStock):
+			app.outOfStockResponse(w, r, err)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"movement": movement})
+}
+
+// getProductStockMovements returns a page of the product's stock ledger, newest first
+// by default.
+func (app *Application) getProductStockMovements(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["productId"]
+
+	productId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Product ID")
+		return
+	}
+
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"id", "created_at", "type", "-id", "-created_at", "-type"}
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movements, metadata, err := app.Models.Stock.GetAllForProduct(productId, filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"movements": movements, "metadata": metadata})
+}
+
+// getStockDiscrepancies lists the products whose amount does not match their ledger.
+func (app *Application) getStockDiscrepancies(w http.ResponseWriter, r *http.Request) {
+	discrepancies, err := app.Models.Stock.Discrepancies()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"discrepancies": discrepancies})
+}
+
+// getLowStock lists products at or under their minimum stock with reorder
+// suggestions based on the average daily sales of the last `days` days.
+func (app *Application) getLowStock(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Days      int
+		CoverDays int
+		model.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Days = app.readInt(qs, "days", 28, v)
+	input.CoverDays = app.readInt(qs, "cover_days", 14, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "days_of_cover")
+	input.Filters.SortSafelist = []string{"id", "name", "amount", "days_of_cover", "suggested_qty",
+		"-id", "-name", "-amount", "-days_of_cover", "-suggested_qty"}
+
+	v.Check(input.Days > 0 && input.Days <= 365, "days", "must be between 1 and 365")
+	v.Check(input.CoverDays > 0 && input.CoverDays <= 365, "cover_days", "must be between 1 and 365")
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	items, metadata, err := app.Models.Stock.LowStock(input.Days, input.CoverDays, input.Filters)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"products": items, "metadata": metadata})
+}
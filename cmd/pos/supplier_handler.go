@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (app *Application) createSupplier(w http.ResponseWriter, r *http.Request) {
+	var newSupplier model.Supplier
+
+	err := json.NewDecoder(r.Body).Decode(&newSupplier)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateSupplier(v, &newSupplier); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Suppliers.Create(&newSupplier)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusCreated, envelope{"supplier": newSupplier})
+}
+
+func (app *Application) getSupplier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["supplierId"]
+
+	supplierId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Supplier ID")
+		return
+	}
+
+	supplier, err := app.Models.Suppliers.Get(supplierId)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"supplier": supplier})
+}
+
+func (app *Application) getAllSuppliers(w http.ResponseWriter, r *http.Request) {
+	suppliers, err := app.Models.Suppliers.GetAll()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"suppliers": suppliers})
+}
+
+func (app *Application) updateSupplier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["supplierId"]
+
+	supplierId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Supplier ID")
+		return
+	}
+
+	var updatedSupplier model.Supplier
+	err = json.NewDecoder(r.Body).Decode(&updatedSupplier)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateSupplier(v, &updatedSupplier); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Models.Suppliers.Update(supplierId, &updatedSupplier)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			app.respondWithError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"supplier": updatedSupplier})
+}
+
+func (app *Application) deleteSupplier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	param := vars["supplierId"]
+
+	supplierId, err := strconv.Atoi(param)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Supplier ID")
+		return
+	}
+
+	err = app.Models.Suppliers.Delete(supplierId)
+	if err != nil {
+		if errors.Is(err, model.ErrSupplierInUse) {
+			app.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
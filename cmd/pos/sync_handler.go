@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"pos-rs/pkg/pos/model"
+	"pos-rs/pkg/pos/validator"
+)
+
+// getCatalogSnapshot returns the whole catalog for a till to work offline with, and
+// the cursor to ask for the changes after it.
+func (app *Application) getCatalogSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := app.Models.Sync.Snapshot()
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"catalog": snapshot})
+}
+
+// getCatalogChanges returns a page of the catalog changes after the cursor. A till
+// keeps asking with the returned cursor while has_more is set.
+func (app *Application) getCatalogChanges(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	cursor, err := model.ParseSyncCursor(app.readString(qs, "cursor", ""))
+	v.Check(err == nil, "cursor", "must be a cursor returned by the server")
+	limit := app.readInt(qs, "limit", 500, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 1000, "limit", "must be a maximum of 1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	changes, err := app.Models.Sync.Changes(cursor, limit)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, changes)
+}
+
+// uploadOfflineOrders records the orders a till took while offline. Each order gets
+// its own result; uploading the same orders again returns them as duplicates.
+func (app *Application) uploadOfflineOrders(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Orders []model.OfflineOrder `json:"orders"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		app.respondWithError(w, http.StatusBadRequest, "Invalid Request Payload")
+		return
+	}
+
+	v := validator.New()
+	if model.ValidateOfflineOrders(v, input.Orders); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	for _, o := range input.Orders {
+		lines := make([]model.OrderProduct, len(o.Products))
+		for i, l := range o.Products {
+			lines[i] = l.OrderProduct
+		}
+		if !app.allowManualDiscounts(w, r, lines) {
+			return
+		}
+	}
+
+	header := model.Order{
+		EmployeeID:   app.contextGetUser(r).Id,
+		StoreId:      app.Config.Store,
+		TaxInclusive: app.Config.PricesIncludeTax,
+	}
+	if station := app.contextGetStation(r); station != nil {
+		header.StationId = &station.Id
+		header.StoreId = station.StoreId
+	}
+
+	manualDiscounts, err := app.hasPermission(r, model.PermissionManualDiscount)
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	results, err := app.Models.Sync.UploadOrders(header, input.Orders, manualDiscounts, app.auditActor(r))
+	if err != nil {
+		app.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	app.respondWithJSON(w, http.StatusOK, envelope{"results": results})
+}
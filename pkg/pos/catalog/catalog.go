@@ -0,0 +1,251 @@
+// Package catalog reads product catalogs from CSV and XLSX files for import and
+// writes the catalog back out as CSV.
+package catalog
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pos-rs/pkg/pos/model"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Columns are the catalog columns in export order. Imports match them by header name
+// in any order; name, category and price are required.
+var Columns = []string{
+	"sku",
+	"name",
+	"category",
+	"price",
+	"tax_rate",
+	"description",
+	"amount",
+	"min_stock",
+	"reorder_qty",
+	"barcodes",
+	"parent_sku",
+	"attributes",
+}
+
+var requiredColumns = []string{"name", "category", "price"}
+
+// listSeparator separates barcodes and attribute pairs within a cell, e.g.
+// "4006381333931|012345678905" or "size=L|color=red".
+const listSeparator = "|"
+
+var (
+	ErrUnknownFormat = errors.New("unknown catalog format")
+	ErrMissingColumn = errors.New("missing catalog column")
+	ErrEmptyCatalog  = errors.New("catalog has no header row")
+)
+
+// Read parses a catalog file into import rows. Cells that cannot be parsed are
+// reported as errors of their row and the row is left out; rows without any values
+// are skipped. Line numbers count the header as line 1.
+func Read(r io.Reader, format string) ([]model.ProductImportRow, []model.ImportError, error) {
+	var records [][]string
+	var err error
+	switch format {
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		records, err = cr.ReadAll()
+	case FormatXLSX:
+		records, err = readXLSX(r)
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil, ErrEmptyCatalog
+	}
+
+	header := make(map[string]int)
+	for i, name := range records[0] {
+		header[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range requiredColumns {
+		if _, ok := header[name]; !ok {
+			return nil, nil, fmt.Errorf("%w: %q", ErrMissingColumn, name)
+		}
+	}
+
+	rows := []model.ProductImportRow{}
+	errs := []model.ImportError{}
+	for i, record := range records[1:] {
+		if blank(record) {
+			continue
+		}
+
+		cell := func(name string) string {
+			if j, ok := header[name]; ok && j < len(record) {
+				return strings.TrimSpace(record[j])
+			}
+			return ""
+		}
+
+		row, rowErrs := parseRow(i+2, cell)
+		if len(rowErrs) > 0 {
+			errs = append(errs, rowErrs...)
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, errs, nil
+}
+
+func parseRow(line int, cell func(string) string) (model.ProductImportRow, []model.ImportError) {
+	row := model.ProductImportRow{
+		Line:        line,
+		Sku:         cell("sku"),
+		Name:        cell("name"),
+		Category:    cell("category"),
+		Description: cell("description"),
+		ParentSku:   cell("parent_sku"),
+	}
+	errs := []model.ImportError{}
+	fail := func(field, message string) {
+		errs = append(errs, model.ImportError{Line: line, Field: field, Message: message})
+	}
+
+	price, err := model.ParseMoney(cell("price"))
+	if err != nil {
+		fail("price", "must be a decimal amount")
+	}
+	row.Price = price
+
+	if s := cell("tax_rate"); s != "" {
+		rate, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			fail("tax_rate", "must be a number")
+		}
+		row.TaxRate = &rate
+	}
+
+	if s := cell("amount"); s != "" {
+		amount, err := parseInt(s)
+		if err != nil {
+			fail("amount", "must be an integer")
+		}
+		row.Amount = &amount
+	}
+
+	if s := cell("min_stock"); s != "" {
+		if row.MinStock, err = parseInt(s); err != nil {
+			fail("min_stock", "must be an integer")
+		}
+	}
+
+	if s := cell("reorder_qty"); s != "" {
+		if row.ReorderQty, err = parseInt(s); err != nil {
+			fail("reorder_qty", "must be an integer")
+		}
+	}
+
+	for _, code := range strings.Split(cell("barcodes"), listSeparator) {
+		if code = strings.TrimSpace(code); code != "" {
+			row.Barcodes = append(row.Barcodes, code)
+		}
+	}
+
+	row.Attributes = model.VariantAttributes{}
+	for _, pair := range strings.Split(cell("attributes"), listSeparator) {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			fail("attributes", fmt.Sprintf("%q must be written as key=value", pair))
+			continue
+		}
+		row.Attributes[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return row, errs
+}
+
+// parseInt accepts integers written as decimals, as spreadsheets often store them.
+func parseInt(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != float64(int(f)) {
+		return 0, strconv.ErrSyntax
+	}
+	return int(f), nil
+}
+
+func blank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// CSVWriter writes catalog rows as CSV with a header of Columns.
+type CSVWriter struct {
+	w *csv.Writer
+}
+
+func NewCSVWriter(w io.Writer) (*CSVWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(Columns); err != nil {
+		return nil, err
+	}
+	return &CSVWriter{w: cw}, nil
+}
+
+func (c *CSVWriter) Write(p model.ProductExportRow) error {
+	taxRate := ""
+	if p.TaxRate != nil {
+		taxRate = strconv.FormatFloat(*p.TaxRate, 'f', -1, 64)
+	}
+
+	keys := make([]string, 0, len(p.Attributes))
+	for k := range p.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attributes := make([]string, 0, len(keys))
+	for _, k := range keys {
+		attributes = append(attributes, k+"="+p.Attributes[k])
+	}
+
+	return c.w.Write([]string{
+		p.Sku,
+		p.Name,
+		p.CategoryPath,
+		p.Price.String(),
+		taxRate,
+		p.Description,
+		strconv.Itoa(p.Amount),
+		strconv.Itoa(p.MinStock),
+		strconv.Itoa(p.ReorderQty),
+		strings.Join(p.Barcodes, listSeparator),
+		p.ParentSku,
+		strings.Join(attributes, listSeparator),
+	})
+}
+
+// Flush writes buffered rows to the underlying writer.
+func (c *CSVWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
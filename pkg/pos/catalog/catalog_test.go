@@ -0,0 +1,342 @@
+package catalog
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pos-rs/pkg/pos/model"
+)
+
+const testWorkbook = `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+	<sheets><sheet name="Catalog" sheetId="1" r:id="rId2"/></sheets>
+</workbook>`
+
+const testWorkbookRels = `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+	<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>
+	<Relationship Id="rId2" Target="worksheets/catalog.xml"/>
+</Relationships>`
+
+const testSharedStrings = `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+	<si><t>name</t></si>
+	<si><t>category</t></si>
+	<si><t>price</t></si>
+	<si><r><t>Green </t></r><r><t>Tea</t></r></si>
+</sst>`
+
+// xlsxFile zips the given parts into a workbook.
+func xlsxFile(t *testing.T, parts map[string]string) *bytes.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func worksheet(rows string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+	<sheetData>` + rows + `</sheetData>
+</worksheet>`
+}
+
+func TestReadXLSX(t *testing.T) {
+	sheet := worksheet(`
+		<row r="1">
+			<c r="A1" t="s"><v>0</v></c>
+			<c r="B1" t="s"><v>1</v></c>
+			<c r="C1" t="s"><v>2</v></c>
+			<c r="E1" t="inlineStr"><is><t>barcodes</t></is></c>
+		</row>
+		<row r="2">
+			<c r="A2" t="s"><v>3</v></c>
+			<c r="B2" t="inlineStr"><is><r><t>Drinks/</t></r><r><t>Tea</t></r></is></c>
+			<c r="C2"><v>12.5</v></c>
+			<c r="E2" t="n"><v>4.006381333931E+12</v></c>
+		</row>
+		<row r="3">
+			<c t="str"><v>Mug</v></c>
+			<c t="b"><v>1</v></c>
+		</row>`)
+
+	f := xlsxFile(t, map[string]string{
+		"xl/workbook.xml":            testWorkbook,
+		"xl/_rels/workbook.xml.rels": testWorkbookRels,
+		"xl/sharedStrings.xml":       testSharedStrings,
+		"xl/worksheets/sheet1.xml":   worksheet(`<row r="1"><c r="A1" t="inlineStr"><is><t>wrong sheet</t></is></c></row>`),
+		"xl/worksheets/catalog.xml":  sheet,
+	})
+
+	got, err := readXLSX(f)
+	if err != nil {
+		t.Fatalf("readXLSX: %v", err)
+	}
+
+	want := [][]string{
+		{"name", "category", "price", "", "barcodes"},
+		{"Green Tea", "Drinks/Tea", "12.5", "", "4006381333931"},
+		{"Mug", "1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readXLSX = %q, want %q", got, want)
+	}
+}
+
+func TestReadXLSXFallbackSheet(t *testing.T) {
+	f := xlsxFile(t, map[string]string{
+		"xl/worksheets/sheet1.xml": worksheet(`<row><c r="B1" t="inlineStr"><is><t>only</t></is></c></row>`),
+	})
+
+	got, err := readXLSX(f)
+	if err != nil {
+		t.Fatalf("readXLSX: %v", err)
+	}
+	if want := [][]string{{"", "only"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("readXLSX = %q, want %q", got, want)
+	}
+}
+
+func TestReadXLSXInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts map[string]string
+	}{
+		{"no worksheet", map[string]string{"xl/workbook.xml": testWorkbook}},
+		{"shared string out of range", map[string]string{
+			"xl/sharedStrings.xml":     testSharedStrings,
+			"xl/worksheets/sheet1.xml": worksheet(`<row><c r="A1" t="s"><v>4</v></c></row>`),
+		}},
+		{"bad cell reference", map[string]string{
+			"xl/worksheets/sheet1.xml": worksheet(`<row><c r="12"><v>1</v></c></row>`),
+		}},
+		{"malformed xml", map[string]string{"xl/worksheets/sheet1.xml": "<worksheet><sheetData>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readXLSX(xlsxFile(t, tt.parts))
+			if !errors.Is(err, ErrInvalidXLSX) {
+				t.Errorf("readXLSX error = %v, want %v", err, ErrInvalidXLSX)
+			}
+		})
+	}
+
+	if _, err := readXLSX(strings.NewReader("sku,name")); !errors.Is(err, ErrInvalidXLSX) {
+		t.Errorf("readXLSX of a CSV error = %v, want %v", err, ErrInvalidXLSX)
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"A1", 0},
+		{"B7", 1},
+		{"Z10", 25},
+		{"AA1", 26},
+		{"AB12", 27},
+		{"AZ3", 51},
+		{"BA3", 52},
+		{"XFD1048576", 16383},
+	}
+
+	for _, tt := range tests {
+		got, err := columnIndex(tt.ref)
+		if err != nil {
+			t.Errorf("columnIndex(%q): %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("columnIndex(%q) = %d, want %d", tt.ref, got, tt.want)
+		}
+	}
+
+	for _, ref := range []string{"", "12", "a1"} {
+		if _, err := columnIndex(ref); !errors.Is(err, ErrInvalidXLSX) {
+			t.Errorf("columnIndex(%q) error = %v, want %v", ref, err, ErrInvalidXLSX)
+		}
+	}
+}
+
+func TestPlainNumber(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"12.5", "12.5"},
+		{"4006381333931", "4006381333931"},
+		{"4.006381333931E+12", "4006381333931"},
+		{"1.2345678905e11", "123456789050"},
+		{"1E-2", "0.01"},
+		{"Ean", "Ean"},
+	}
+
+	for _, tt := range tests {
+		if got := plainNumber(tt.in); got != tt.want {
+			t.Errorf("plainNumber(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"12", 12, false},
+		{"-3", -3, false},
+		{"12.0", 12, false},
+		{"1E3", 1000, false},
+		{"12.5", 0, true},
+		{"twelve", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseInt(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseInt(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseInt(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	in := "\ufeffSKU, Name,category,price,tax_rate,amount,barcodes,parent_sku,attributes\n" +
+		"TEA-1,Green Tea,Drinks/Tea,12.50,12%,10.0,4006381333931| 012345678905,,\n" +
+		",,,,,,,,\n" +
+		"TEA-1-L,Green Tea L,Drinks/Tea,15,,,,TEA-1,size=L|color = green\n" +
+		"BAD,Broken,Drinks,abc,,1.5,,,size\n"
+
+	rows, errs, err := Read(strings.NewReader(in), FormatCSV)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	rate := 12.0
+	amount := 10
+	wantRows := []model.ProductImportRow{
+		{
+			Line:       2,
+			Sku:        "TEA-1",
+			Name:       "Green Tea",
+			Category:   "Drinks/Tea",
+			Price:      model.NewMoney(1250),
+			TaxRate:    &rate,
+			Amount:     &amount,
+			Barcodes:   []string{"4006381333931", "012345678905"},
+			Attributes: model.VariantAttributes{},
+		},
+		{
+			Line:       4,
+			Sku:        "TEA-1-L",
+			Name:       "Green Tea L",
+			Category:   "Drinks/Tea",
+			Price:      model.NewMoney(1500),
+			ParentSku:  "TEA-1",
+			Attributes: model.VariantAttributes{"size": "L", "color": "green"},
+		},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("Read rows = %+v, want %+v", rows, wantRows)
+	}
+
+	var fields []string
+	for _, e := range errs {
+		if e.Line != 5 {
+			t.Errorf("error %+v on line %d, want 5", e, e.Line)
+		}
+		fields = append(fields, e.Field)
+	}
+	if want := []string{"price", "amount", "attributes"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("Read error fields = %q, want %q", fields, want)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		format string
+		want   error
+	}{
+		{"unknown format", "name,category,price\n", "ods", ErrUnknownFormat},
+		{"empty", "", FormatCSV, ErrEmptyCatalog},
+		{"missing column", "name,price\nTea,1\n", FormatCSV, ErrMissingColumn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Read(strings.NewReader(tt.in), tt.format)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Read error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewCSVWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rate := 12.5
+	err = w.Write(model.ProductExportRow{
+		Product: model.Product{
+			Sku:        "TEA-1-L",
+			Name:       "Green Tea, large",
+			Price:      model.NewMoney(1500),
+			TaxRate:    &rate,
+			Amount:     3,
+			Barcodes:   []string{"4006381333931", "012345678905"},
+			Attributes: model.VariantAttributes{"size": "L", "color": "green"},
+		},
+		CategoryPath: "Drinks/Tea",
+		ParentSku:    "TEA-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join(Columns, ",") + "\n" +
+		`TEA-1-L,"Green Tea, large",Drinks/Tea,15.00,12.5,,3,0,0,4006381333931|012345678905,TEA-1,color=green|size=L` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("CSVWriter wrote\n%s\nwant\n%s", got, want)
+	}
+
+	rows, errs, err := Read(strings.NewReader(buf.String()), FormatCSV)
+	if err != nil || len(errs) != 0 || len(rows) != 1 {
+		t.Fatalf("Read of the export = %+v, %+v, %v", rows, errs, err)
+	}
+	if got := rows[0].Attributes; !reflect.DeepEqual(got, model.VariantAttributes{"size": "L", "color": "green"}) {
+		t.Errorf("round-tripped attributes = %v", got)
+	}
+}
@@ -0,0 +1,215 @@
+package catalog
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidXLSX is returned for files that are not readable XLSX workbooks.
+var ErrInvalidXLSX = errors.New("invalid xlsx file")
+
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Id string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX returns the cell values of the first worksheet of a workbook, row by row.
+// Only what catalogs need is supported: shared, inline and plain strings, numbers and
+// booleans. Formulas yield their cached values.
+func readXLSX(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidXLSX, err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+
+	var shared xlsxSharedStrings
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeXML(f, &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	sheetPath, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var sheet xlsxWorksheet
+	if err := decodeXML(files[sheetPath], &sheet); err != nil {
+		return nil, err
+	}
+
+	records := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		record := []string{}
+		for i, c := range row.Cells {
+			col := i
+			if c.Ref != "" {
+				if col, err = columnIndex(c.Ref); err != nil {
+					return nil, err
+				}
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+
+			switch c.Type {
+			case "s":
+				n, err := strconv.Atoi(c.Value)
+				if err != nil || n < 0 || n >= len(shared.Items) {
+					return nil, fmt.Errorf("%w: bad shared string in %s", ErrInvalidXLSX, c.Ref)
+				}
+				record[col] = shared.Items[n].String()
+			case "inlineStr":
+				record[col] = c.Inline.String()
+			case "", "n":
+				record[col] = plainNumber(c.Value)
+			default:
+				record[col] = c.Value
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// firstSheet finds the part of the workbook's first worksheet.
+func firstSheet(files map[string]*zip.File) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var wb xlsxWorkbook
+	var rels xlsxRelationships
+	wbFile, ok1 := files["xl/workbook.xml"]
+	relsFile, ok2 := files["xl/_rels/workbook.xml.rels"]
+	if ok1 && ok2 {
+		if err := decodeXML(wbFile, &wb); err != nil {
+			return "", err
+		}
+		if err := decodeXML(relsFile, &rels); err != nil {
+			return "", err
+		}
+	}
+
+	if len(wb.Sheets) > 0 {
+		for _, rel := range rels.Relationships {
+			if rel.Id != wb.Sheets[0].Id {
+				continue
+			}
+			target := strings.TrimPrefix(rel.Target, "/")
+			if !strings.HasPrefix(target, "xl/") {
+				target = path.Join("xl", target)
+			}
+			if _, ok := files[target]; ok {
+				return target, nil
+			}
+		}
+	}
+
+	if _, ok := files[fallback]; ok {
+		return fallback, nil
+	}
+	return "", fmt.Errorf("%w: no worksheet", ErrInvalidXLSX)
+}
+
+func decodeXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidXLSX, err)
+	}
+	defer rc.Close()
+
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidXLSX, f.Name, err)
+	}
+	return nil
+}
+
+// columnIndex turns the column letters of a cell reference such as "AB12" into a
+// zero-based index.
+func columnIndex(ref string) (int, error) {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: bad cell reference %q", ErrInvalidXLSX, ref)
+	}
+	return col - 1, nil
+}
+
+// plainNumber writes numbers stored in exponent form, as long barcodes often are,
+// out in full.
+func plainNumber(v string) string {
+	if !strings.ContainsAny(v, "eE") {
+		return v
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return v
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
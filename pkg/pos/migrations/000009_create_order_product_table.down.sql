@@ -0,0 +1,20 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS products JSONB DEFAULT '[]';
+
+UPDATE orders o
+SET products = lines.products
+FROM (
+    SELECT order_id,
+           jsonb_agg(jsonb_build_object(
+               'id', product_id,
+               'order_id', order_id::TEXT,
+               'product_id', product_id::TEXT,
+               'qty', qty,
+               'price', price,
+               'total_normal_price', total_normal_price
+           ) ORDER BY id) AS products
+    FROM order_product
+    GROUP BY order_id
+) lines
+WHERE lines.order_id = o.id;
+
+DROP TABLE IF EXISTS order_product CASCADE;
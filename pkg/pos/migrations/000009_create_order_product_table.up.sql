@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS order_product (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    product_id INT NOT NULL REFERENCES products(id),
+    qty INT NOT NULL CHECK (qty > 0),
+    price INT NOT NULL,
+    total_normal_price INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS order_product_order_id_idx ON order_product (order_id);
+CREATE INDEX IF NOT EXISTS order_product_product_id_idx ON order_product (product_id);
+
+-- Older databases kept the lines as a JSON blob on the order. Lines are matched to
+-- products by product_id, falling back to id which the handlers used to fill with
+-- the product id. Lines pointing at products that no longer exist, and lines whose
+-- product id or quantity is not a whole number, are dropped; a price that is not a
+-- number is taken as 0. Blobs that are not arrays are skipped.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS products JSONB DEFAULT '[]';
+
+INSERT INTO order_product (order_id, product_id, qty, price, total_normal_price, created_at, updated_at)
+SELECT o.id,
+       p.id,
+       l.qty,
+       l.price,
+       l.qty * l.price,
+       COALESCE(o.created_at, CURRENT_TIMESTAMP),
+       COALESCE(o.updated_at, CURRENT_TIMESTAMP)
+FROM orders o
+CROSS JOIN LATERAL jsonb_array_elements(
+    CASE WHEN jsonb_typeof(o.products::JSONB) = 'array' THEN o.products::JSONB ELSE '[]'::JSONB END
+) AS line
+CROSS JOIN LATERAL (
+    SELECT CASE
+               WHEN line->>'product_id' ~ '^\d{1,9}$' THEN (line->>'product_id')::INT
+               WHEN COALESCE(line->>'product_id', '') = '' AND line->>'id' ~ '^\d{1,9}$' THEN (line->>'id')::INT
+           END AS product_id,
+           CASE WHEN line->>'qty' ~ '^\d{1,9}$' THEN (line->>'qty')::INT END AS qty,
+           CASE WHEN line->>'price' ~ '^\d{1,9}(\.\d+)?$' THEN ROUND((line->>'price')::NUMERIC)::INT ELSE 0 END AS price
+) AS l
+JOIN products p ON p.id = l.product_id
+WHERE l.qty > 0
+AND l.qty::BIGINT * l.price <= 2147483647;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS products;
@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS paid_at;
+
+DROP TABLE IF EXISTS payments CASCADE;
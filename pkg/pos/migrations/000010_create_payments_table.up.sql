@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS payments (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    employee_id INT REFERENCES employee(id) ON DELETE SET NULL,
+    method TEXT NOT NULL CHECK (method IN ('cash', 'card', 'transfer')),
+    amount FLOAT NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS payments_order_id_idx ON payments (order_id);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS paid_at TIMESTAMP;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS receipt_sequences CASCADE;
+
+DROP INDEX IF EXISTS orders_store_receipt_number_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS receipt_number;
+ALTER TABLE orders DROP COLUMN IF EXISTS store_id;
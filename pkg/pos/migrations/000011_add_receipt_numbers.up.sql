@@ -0,0 +1,11 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS store_id TEXT NOT NULL DEFAULT 'main';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS receipt_number BIGINT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS orders_store_receipt_number_idx ON orders (store_id, receipt_number);
+
+-- One row per store. The row is locked and bumped inside the transaction that marks
+-- an order paid, so a rolled back payment never burns a number.
+CREATE TABLE IF NOT EXISTS receipt_sequences (
+    store_id TEXT PRIMARY KEY,
+    last_number BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES orders(id),
+    employee_id INT REFERENCES employee(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL CHECK (method IN ('cash', 'card', 'transfer')),
+    restock BOOLEAN NOT NULL DEFAULT false,
+    amount FLOAT NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS refunds_order_id_idx ON refunds (order_id);
+
+CREATE TABLE IF NOT EXISTS refund_lines (
+    id SERIAL PRIMARY KEY,
+    refund_id INT NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+    order_product_id INT NOT NULL REFERENCES order_product(id),
+    product_id INT NOT NULL REFERENCES products(id),
+    qty INT NOT NULL CHECK (qty > 0),
+    amount FLOAT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refund_lines_order_product_id_idx ON refund_lines (order_product_id);
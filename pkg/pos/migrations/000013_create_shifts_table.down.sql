@@ -0,0 +1,7 @@
+ALTER TABLE refunds DROP COLUMN IF EXISTS shift_id;
+ALTER TABLE payments DROP COLUMN IF EXISTS change;
+ALTER TABLE payments DROP COLUMN IF EXISTS shift_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS shift_id;
+
+DROP TABLE IF EXISTS cash_movements CASCADE;
+DROP TABLE IF EXISTS shifts CASCADE;
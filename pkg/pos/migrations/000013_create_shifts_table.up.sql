@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS shifts (
+    id SERIAL PRIMARY KEY,
+    employee_id INT NOT NULL REFERENCES employee(id),
+    store_id TEXT NOT NULL DEFAULT 'main',
+    opening_float FLOAT NOT NULL DEFAULT 0,
+    expected_cash FLOAT,
+    counted_cash FLOAT,
+    opened_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    closed_at TIMESTAMP
+);
+
+-- An employee can only have one open shift at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS shifts_open_employee_idx ON shifts (employee_id) WHERE closed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS cash_movements (
+    id SERIAL PRIMARY KEY,
+    shift_id INT NOT NULL REFERENCES shifts(id),
+    employee_id INT REFERENCES employee(id) ON DELETE SET NULL,
+    type TEXT NOT NULL CHECK (type IN ('pay_in', 'pay_out')),
+    amount FLOAT NOT NULL CHECK (amount > 0),
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS cash_movements_shift_id_idx ON cash_movements (shift_id);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shift_id INT REFERENCES shifts(id);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS shift_id INT REFERENCES shifts(id);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS change FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS shift_id INT REFERENCES shifts(id);
+
+CREATE INDEX IF NOT EXISTS orders_shift_id_idx ON orders (shift_id);
+CREATE INDEX IF NOT EXISTS payments_shift_id_idx ON payments (shift_id);
+CREATE INDEX IF NOT EXISTS refunds_shift_id_idx ON refunds (shift_id);
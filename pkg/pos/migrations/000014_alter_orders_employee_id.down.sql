@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS refunds_created_at_idx;
+DROP INDEX IF EXISTS orders_employee_id_idx;
+DROP INDEX IF EXISTS orders_paid_at_idx;
+
+ALTER TABLE orders
+    ALTER COLUMN employee_id TYPE VARCHAR(255) USING employee_id::VARCHAR;
@@ -0,0 +1,10 @@
+-- Legacy employee ids that are not whole numbers cannot name an employee and are
+-- dropped rather than failing the migration.
+ALTER TABLE orders
+    ALTER COLUMN employee_id TYPE INT USING CASE
+        WHEN btrim(employee_id) ~ '^\d{1,9}$' THEN btrim(employee_id)::INT
+    END;
+
+CREATE INDEX IF NOT EXISTS orders_paid_at_idx ON orders (paid_at);
+CREATE INDEX IF NOT EXISTS orders_employee_id_idx ON orders (employee_id);
+CREATE INDEX IF NOT EXISTS refunds_created_at_idx ON refunds (created_at);
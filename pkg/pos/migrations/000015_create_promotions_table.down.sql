@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE code IN ('promotions:write', 'discounts:manual');
+
+ALTER TABLE orders DROP COLUMN IF EXISTS promotion_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS discount;
+
+ALTER TABLE order_product DROP COLUMN IF EXISTS promotion_id;
+ALTER TABLE order_product DROP COLUMN IF EXISTS manual_discount;
+ALTER TABLE order_product DROP COLUMN IF EXISTS discount;
+
+DROP TABLE IF EXISTS promotions CASCADE;
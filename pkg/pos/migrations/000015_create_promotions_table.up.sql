@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS promotions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    type TEXT NOT NULL CHECK (type IN ('line_percent', 'line_fixed', 'category_percent', 'order_percent', 'order_fixed', 'buy_x_get_y')),
+    value FLOAT NOT NULL DEFAULT 0,
+    product_id INT REFERENCES products(id) ON DELETE CASCADE,
+    category_id INT REFERENCES categories(id) ON DELETE CASCADE,
+    buy_qty INT NOT NULL DEFAULT 0,
+    get_qty INT NOT NULL DEFAULT 0,
+    get_product_id INT REFERENCES products(id) ON DELETE CASCADE,
+    min_subtotal FLOAT NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT true,
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE order_product ADD COLUMN IF NOT EXISTS discount FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE order_product ADD COLUMN IF NOT EXISTS manual_discount FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE order_product ADD COLUMN IF NOT EXISTS promotion_id INT REFERENCES promotions(id) ON DELETE SET NULL;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS promotion_id INT REFERENCES promotions(id) ON DELETE SET NULL;
+
+INSERT INTO permissions (code)
+VALUES ('promotions:write'),
+       ('discounts:manual');
@@ -0,0 +1,11 @@
+ALTER TABLE refund_lines DROP COLUMN IF EXISTS tax;
+ALTER TABLE refund_lines DROP COLUMN IF EXISTS tax_rate;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_inclusive;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax;
+
+ALTER TABLE order_product DROP COLUMN IF EXISTS tax;
+ALTER TABLE order_product DROP COLUMN IF EXISTS tax_rate;
+
+ALTER TABLE products DROP COLUMN IF EXISTS tax_rate;
+ALTER TABLE categories DROP COLUMN IF EXISTS tax_rate;
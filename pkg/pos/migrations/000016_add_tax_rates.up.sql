@@ -0,0 +1,11 @@
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS tax_rate FLOAT NOT NULL DEFAULT 0 CHECK (tax_rate >= 0);
+ALTER TABLE products ADD COLUMN IF NOT EXISTS tax_rate FLOAT CHECK (tax_rate >= 0);
+
+ALTER TABLE order_product ADD COLUMN IF NOT EXISTS tax_rate FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE order_product ADD COLUMN IF NOT EXISTS tax FLOAT NOT NULL DEFAULT 0;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_inclusive BOOLEAN NOT NULL DEFAULT true;
+
+ALTER TABLE refund_lines ADD COLUMN IF NOT EXISTS tax_rate FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE refund_lines ADD COLUMN IF NOT EXISTS tax FLOAT NOT NULL DEFAULT 0;
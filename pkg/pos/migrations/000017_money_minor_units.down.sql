@@ -0,0 +1,42 @@
+ALTER TABLE products
+    ALTER COLUMN price TYPE INT USING (price / 100)::INT;
+
+ALTER TABLE order_product
+    ALTER COLUMN price TYPE INT USING (price / 100)::INT,
+    ALTER COLUMN total_normal_price TYPE INT USING (total_normal_price / 100)::INT,
+    ALTER COLUMN manual_discount TYPE FLOAT USING manual_discount / 100.0,
+    ALTER COLUMN discount TYPE FLOAT USING discount / 100.0,
+    ALTER COLUMN tax TYPE FLOAT USING tax / 100.0;
+
+ALTER TABLE orders
+    ALTER COLUMN total_price TYPE FLOAT USING total_price / 100.0,
+    ALTER COLUMN discount TYPE FLOAT USING discount / 100.0,
+    ALTER COLUMN tax TYPE FLOAT USING tax / 100.0,
+    ALTER COLUMN total_paid TYPE FLOAT USING total_paid / 100.0,
+    ALTER COLUMN total_return TYPE FLOAT USING total_return / 100.0;
+
+ALTER TABLE payments
+    ALTER COLUMN amount TYPE FLOAT USING amount / 100.0,
+    ALTER COLUMN change TYPE FLOAT USING change / 100.0;
+
+ALTER TABLE refunds
+    ALTER COLUMN amount TYPE FLOAT USING amount / 100.0;
+
+ALTER TABLE refund_lines
+    ALTER COLUMN amount TYPE FLOAT USING amount / 100.0,
+    ALTER COLUMN tax TYPE FLOAT USING tax / 100.0;
+
+ALTER TABLE shifts
+    ALTER COLUMN opening_float TYPE FLOAT USING opening_float / 100.0,
+    ALTER COLUMN expected_cash TYPE FLOAT USING expected_cash / 100.0,
+    ALTER COLUMN counted_cash TYPE FLOAT USING counted_cash / 100.0;
+
+ALTER TABLE cash_movements
+    ALTER COLUMN amount TYPE FLOAT USING amount / 100.0;
+
+UPDATE promotions SET value = amount / 100.0
+WHERE type IN ('line_fixed', 'order_fixed');
+ALTER TABLE promotions DROP COLUMN IF EXISTS amount;
+
+ALTER TABLE promotions
+    ALTER COLUMN min_subtotal TYPE FLOAT USING min_subtotal / 100.0;
@@ -0,0 +1,46 @@
+-- Money is stored in integer minor units (1/100 of the major unit).
+
+ALTER TABLE products
+    ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100)::BIGINT;
+
+ALTER TABLE order_product
+    ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100)::BIGINT,
+    ALTER COLUMN total_normal_price TYPE BIGINT USING ROUND(total_normal_price * 100)::BIGINT,
+    ALTER COLUMN manual_discount TYPE BIGINT USING ROUND(manual_discount * 100)::BIGINT,
+    ALTER COLUMN discount TYPE BIGINT USING ROUND(discount * 100)::BIGINT,
+    ALTER COLUMN tax TYPE BIGINT USING ROUND(tax * 100)::BIGINT;
+
+ALTER TABLE orders
+    ALTER COLUMN total_price TYPE BIGINT USING ROUND(total_price * 100)::BIGINT,
+    ALTER COLUMN discount TYPE BIGINT USING ROUND(discount * 100)::BIGINT,
+    ALTER COLUMN tax TYPE BIGINT USING ROUND(tax * 100)::BIGINT,
+    ALTER COLUMN total_paid TYPE BIGINT USING ROUND(total_paid * 100)::BIGINT,
+    ALTER COLUMN total_return TYPE BIGINT USING ROUND(total_return * 100)::BIGINT;
+
+ALTER TABLE payments
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT,
+    ALTER COLUMN change TYPE BIGINT USING ROUND(change * 100)::BIGINT;
+
+ALTER TABLE refunds
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT;
+
+ALTER TABLE refund_lines
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT,
+    ALTER COLUMN tax TYPE BIGINT USING ROUND(tax * 100)::BIGINT;
+
+ALTER TABLE shifts
+    ALTER COLUMN opening_float TYPE BIGINT USING ROUND(opening_float * 100)::BIGINT,
+    ALTER COLUMN expected_cash TYPE BIGINT USING ROUND(expected_cash * 100)::BIGINT,
+    ALTER COLUMN counted_cash TYPE BIGINT USING ROUND(counted_cash * 100)::BIGINT;
+
+ALTER TABLE cash_movements
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT;
+
+ALTER TABLE promotions
+    ALTER COLUMN min_subtotal TYPE BIGINT USING ROUND(min_subtotal * 100)::BIGINT;
+
+-- value stays the percentage of the *_percent types; the *_fixed types move their
+-- amount to a column of minor units.
+ALTER TABLE promotions ADD COLUMN IF NOT EXISTS amount BIGINT NOT NULL DEFAULT 0;
+UPDATE promotions SET amount = ROUND(value * 100)::BIGINT, value = 0
+WHERE type IN ('line_fixed', 'order_fixed');
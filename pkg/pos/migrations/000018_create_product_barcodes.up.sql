@@ -0,0 +1,10 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sku TEXT;
+ALTER TABLE products ADD CONSTRAINT products_sku_key UNIQUE (sku);
+
+CREATE TABLE IF NOT EXISTS product_barcodes (
+    barcode TEXT PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS product_barcodes_product_id_idx ON product_barcodes (product_id);
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'inventory:write';
+
+DROP TABLE IF EXISTS stock_movements;
+DROP FUNCTION IF EXISTS reject_stock_movement_change();
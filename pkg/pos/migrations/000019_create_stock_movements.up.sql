@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id BIGSERIAL PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    type TEXT NOT NULL CHECK (type IN ('sale', 'refund', 'receipt', 'adjustment', 'write_off', 'transfer')),
+    qty INT NOT NULL CHECK (qty <> 0),
+    balance INT NOT NULL CHECK (balance >= 0),
+    employee_id INT REFERENCES employee(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    reference TEXT NOT NULL DEFAULT '',
+    from_store TEXT NOT NULL DEFAULT '',
+    to_store TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (type <> 'transfer' OR (from_store <> '' AND to_store <> '' AND from_store <> to_store))
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_product_id_idx ON stock_movements (product_id, created_at);
+
+-- The current amounts become the opening balances of the ledger.
+INSERT INTO stock_movements (product_id, type, qty, balance, reason)
+SELECT id, 'adjustment', amount, amount, 'opening balance'
+FROM products
+WHERE amount > 0;
+
+UPDATE products SET amount = 0 WHERE amount < 0;
+
+-- The ledger is append-only: a wrong movement is corrected by another one.
+CREATE OR REPLACE FUNCTION reject_stock_movement_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'stock_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS stock_movements_append_only ON stock_movements;
+CREATE TRIGGER stock_movements_append_only BEFORE UPDATE OR DELETE ON stock_movements
+    FOR EACH ROW EXECUTE PROCEDURE reject_stock_movement_change();
+
+INSERT INTO permissions (code)
+VALUES ('inventory:write');
@@ -0,0 +1,2 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS min_stock INT NOT NULL DEFAULT 0 CHECK (min_stock >= 0);
+ALTER TABLE products ADD COLUMN IF NOT EXISTS reorder_qty INT NOT NULL DEFAULT 0 CHECK (reorder_qty >= 0);
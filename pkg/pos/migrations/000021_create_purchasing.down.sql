@@ -0,0 +1,9 @@
+DELETE FROM permissions WHERE code = 'purchasing:write';
+
+ALTER TABLE products DROP COLUMN IF EXISTS cost_price;
+
+DROP TABLE IF EXISTS goods_receipt_lines;
+DROP TABLE IF EXISTS goods_receipts;
+DROP TABLE IF EXISTS purchase_order_lines;
+DROP TABLE IF EXISTS purchase_orders;
+DROP TABLE IF EXISTS suppliers;
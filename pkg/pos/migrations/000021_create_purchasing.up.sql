@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS suppliers (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    contact TEXT NOT NULL DEFAULT '',
+    phone TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    address TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS purchase_orders (
+    id SERIAL PRIMARY KEY,
+    supplier_id INT NOT NULL REFERENCES suppliers(id) ON DELETE RESTRICT,
+    employee_id INT REFERENCES employee(id) ON DELETE SET NULL,
+    status TEXT NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'sent', 'partially_received', 'received', 'cancelled')),
+    note TEXT NOT NULL DEFAULT '',
+    sent_at TIMESTAMP,
+    received_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS purchase_orders_supplier_id_idx ON purchase_orders (supplier_id);
+
+CREATE TABLE IF NOT EXISTS purchase_order_lines (
+    id SERIAL PRIMARY KEY,
+    purchase_order_id INT NOT NULL REFERENCES purchase_orders(id) ON DELETE CASCADE,
+    product_id INT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    qty INT NOT NULL CHECK (qty > 0),
+    received_qty INT NOT NULL DEFAULT 0 CHECK (received_qty >= 0 AND received_qty <= qty),
+    unit_cost BIGINT NOT NULL DEFAULT 0 CHECK (unit_cost >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS goods_receipts (
+    id SERIAL PRIMARY KEY,
+    purchase_order_id INT NOT NULL REFERENCES purchase_orders(id) ON DELETE CASCADE,
+    employee_id INT REFERENCES employee(id) ON DELETE SET NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS goods_receipt_lines (
+    id SERIAL PRIMARY KEY,
+    receipt_id INT NOT NULL REFERENCES goods_receipts(id) ON DELETE CASCADE,
+    line_id INT NOT NULL REFERENCES purchase_order_lines(id) ON DELETE CASCADE,
+    product_id INT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    qty INT NOT NULL CHECK (qty > 0),
+    unit_cost BIGINT NOT NULL CHECK (unit_cost >= 0)
+);
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS cost_price BIGINT NOT NULL DEFAULT 0 CHECK (cost_price >= 0);
+
+INSERT INTO permissions (code)
+VALUES ('purchasing:write');
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS order_product_modifiers;
+DROP TABLE IF EXISTS product_modifier_groups;
+DROP TABLE IF EXISTS modifiers;
+DROP TABLE IF EXISTS modifier_groups;
+
+DROP INDEX IF EXISTS products_parent_id_idx;
+ALTER TABLE products DROP COLUMN IF EXISTS attributes;
+ALTER TABLE products DROP COLUMN IF EXISTS parent_id;
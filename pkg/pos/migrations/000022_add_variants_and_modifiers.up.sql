@@ -0,0 +1,37 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES products(id) ON DELETE RESTRICT;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS products_parent_id_idx ON products (parent_id);
+
+CREATE TABLE IF NOT EXISTS modifier_groups (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    min_select INT NOT NULL DEFAULT 0 CHECK (min_select >= 0),
+    max_select INT NOT NULL DEFAULT 1 CHECK (max_select >= 1 AND max_select >= min_select),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS modifiers (
+    id SERIAL PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES modifier_groups(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    price_delta BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS product_modifier_groups (
+    product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    group_id INT NOT NULL REFERENCES modifier_groups(id) ON DELETE CASCADE,
+    PRIMARY KEY (product_id, group_id)
+);
+
+CREATE TABLE IF NOT EXISTS order_product_modifiers (
+    id SERIAL PRIMARY KEY,
+    order_product_id INT NOT NULL REFERENCES order_product(id) ON DELETE CASCADE,
+    modifier_id INT REFERENCES modifiers(id) ON DELETE SET NULL,
+    group_id INT REFERENCES modifier_groups(id) ON DELETE SET NULL,
+    name TEXT NOT NULL,
+    price_delta BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS order_product_modifiers_order_product_id_idx ON order_product_modifiers (order_product_id);
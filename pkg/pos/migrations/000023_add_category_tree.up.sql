@@ -0,0 +1,10 @@
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES categories(id) ON DELETE RESTRICT;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS position INT NOT NULL DEFAULT 0 CHECK (position >= 0);
+
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id, position);
+
+-- Existing categories become roots in the order they were created.
+UPDATE categories c
+SET position = o.position
+FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY id) - 1 AS position FROM categories) o
+WHERE c.id = o.id;
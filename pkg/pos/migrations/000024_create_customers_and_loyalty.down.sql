@@ -0,0 +1,15 @@
+DELETE FROM permissions WHERE code = 'loyalty:write';
+
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_method_check;
+ALTER TABLE refunds ADD CONSTRAINT refunds_method_check CHECK (method IN ('cash', 'card', 'transfer')) NOT VALID;
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_method_check;
+ALTER TABLE payments ADD CONSTRAINT payments_method_check CHECK (method IN ('cash', 'card', 'transfer')) NOT VALID;
+
+DROP TABLE IF EXISTS loyalty_transactions;
+DROP TABLE IF EXISTS loyalty_rules;
+
+DROP INDEX IF EXISTS orders_customer_id_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS customer_id;
+
+DROP TABLE IF EXISTS customers;
@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS customers (
+    id SERIAL PRIMARY KEY,
+    phone TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    marketing_consent BOOLEAN NOT NULL DEFAULT false,
+    sms_consent BOOLEAN NOT NULL DEFAULT false,
+    consent_updated_at TIMESTAMP,
+    points INT NOT NULL DEFAULT 0 CHECK (points >= 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS customer_id INT REFERENCES customers(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS orders_customer_id_idx ON orders (customer_id);
+
+CREATE TABLE IF NOT EXISTS loyalty_rules (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL CHECK (type IN ('spend', 'product', 'category')),
+    points INT NOT NULL CHECK (points > 0),
+    spend_step BIGINT NOT NULL DEFAULT 0 CHECK (spend_step >= 0),
+    product_id INT REFERENCES products(id) ON DELETE CASCADE,
+    category_id INT REFERENCES categories(id) ON DELETE CASCADE,
+    active BOOLEAN NOT NULL DEFAULT true,
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS loyalty_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id INT NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    order_id INT REFERENCES orders(id) ON DELETE SET NULL,
+    type TEXT NOT NULL CHECK (type IN ('earn', 'redeem', 'refund', 'reversal', 'adjustment')),
+    points INT NOT NULL,
+    balance INT NOT NULL CHECK (balance >= 0),
+    employee_id INT REFERENCES employee(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS loyalty_transactions_customer_id_idx ON loyalty_transactions (customer_id, id);
+CREATE INDEX IF NOT EXISTS loyalty_transactions_order_id_idx ON loyalty_transactions (order_id);
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_method_check;
+ALTER TABLE payments ADD CONSTRAINT payments_method_check CHECK (method IN ('cash', 'card', 'transfer', 'points'));
+
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_method_check;
+ALTER TABLE refunds ADD CONSTRAINT refunds_method_check CHECK (method IN ('cash', 'card', 'transfer', 'points'));
+
+INSERT INTO permissions (code)
+VALUES ('loyalty:write');
@@ -0,0 +1,14 @@
+DELETE FROM permissions WHERE code = 'giftcards:write';
+
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_method_check;
+ALTER TABLE refunds ADD CONSTRAINT refunds_method_check CHECK (method IN ('cash', 'card', 'transfer', 'points')) NOT VALID;
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_method_check;
+ALTER TABLE payments ADD CONSTRAINT payments_method_check CHECK (method IN ('cash', 'card', 'transfer', 'points')) NOT VALID;
+
+ALTER TABLE refunds DROP COLUMN IF EXISTS gift_card_id;
+ALTER TABLE payments DROP COLUMN IF EXISTS gift_card_id;
+ALTER TABLE order_product DROP COLUMN IF EXISTS gift_card_id;
+
+DROP TABLE IF EXISTS gift_card_transactions;
+DROP TABLE IF EXISTS gift_cards;
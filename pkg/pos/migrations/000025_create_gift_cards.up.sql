@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS gift_cards (
+    id SERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE,
+    type TEXT NOT NULL DEFAULT 'gift_card' CHECK (type IN ('gift_card', 'store_credit')),
+    status TEXT NOT NULL DEFAULT 'inactive' CHECK (status IN ('inactive', 'active', 'void')),
+    amount BIGINT NOT NULL CHECK (amount >= 0),
+    balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    customer_id INT REFERENCES customers(id) ON DELETE SET NULL,
+    activated_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS gift_card_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    gift_card_id INT NOT NULL REFERENCES gift_cards(id) ON DELETE RESTRICT,
+    type TEXT NOT NULL CHECK (type IN ('activation', 'redeem', 'credit', 'void')),
+    amount BIGINT NOT NULL,
+    balance BIGINT NOT NULL CHECK (balance >= 0),
+    order_id INT REFERENCES orders(id) ON DELETE SET NULL,
+    employee_id INT REFERENCES employee(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS gift_card_transactions_gift_card_id_idx ON gift_card_transactions (gift_card_id, id);
+
+ALTER TABLE order_product ADD COLUMN IF NOT EXISTS gift_card_id INT UNIQUE REFERENCES gift_cards(id) ON DELETE RESTRICT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS gift_card_id INT REFERENCES gift_cards(id) ON DELETE RESTRICT;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS gift_card_id INT REFERENCES gift_cards(id) ON DELETE RESTRICT;
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_method_check;
+ALTER TABLE payments ADD CONSTRAINT payments_method_check CHECK (method IN ('cash', 'card', 'transfer', 'points', 'gift_card'));
+
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_method_check;
+ALTER TABLE refunds ADD CONSTRAINT refunds_method_check CHECK (method IN ('cash', 'card', 'transfer', 'points', 'store_credit'));
+
+INSERT INTO permissions (code)
+VALUES ('giftcards:write');
@@ -0,0 +1,8 @@
+DELETE FROM permissions WHERE code = 'stations:write';
+
+ALTER TABLE shifts DROP COLUMN IF EXISTS station_id;
+
+DROP INDEX IF EXISTS orders_station_id_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS station_id;
+
+DROP TABLE IF EXISTS stations;
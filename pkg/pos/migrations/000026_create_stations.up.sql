@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS stations (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    store_id TEXT NOT NULL DEFAULT 'main',
+    printer_width INT NOT NULL DEFAULT 80 CHECK (printer_width IN (58, 80)),
+    default_tax_rate FLOAT CHECK (default_tax_rate >= 0),
+    tenders TEXT[] NOT NULL DEFAULT '{cash,card,transfer,points,gift_card}',
+    credential_hash BYTEA NOT NULL UNIQUE,
+    disabled_at TIMESTAMP,
+    disabled_reason TEXT NOT NULL DEFAULT '',
+    last_seen_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS station_id INT REFERENCES stations(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS orders_station_id_idx ON orders (station_id);
+
+ALTER TABLE shifts ADD COLUMN IF NOT EXISTS station_id INT REFERENCES stations(id) ON DELETE SET NULL;
+
+INSERT INTO permissions (code)
+VALUES ('stations:write');
@@ -0,0 +1,10 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS client_id;
+
+DROP TRIGGER IF EXISTS product_barcodes_catalog_change ON product_barcodes;
+DROP TRIGGER IF EXISTS categories_catalog_change ON categories;
+DROP TRIGGER IF EXISTS products_catalog_change ON products;
+
+DROP FUNCTION IF EXISTS record_barcode_change();
+DROP FUNCTION IF EXISTS record_catalog_change();
+
+DROP TABLE IF EXISTS catalog_changes;
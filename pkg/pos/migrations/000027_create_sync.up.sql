@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS catalog_changes (
+    id BIGSERIAL PRIMARY KEY,
+    xid BIGINT NOT NULL DEFAULT txid_current(),
+    entity TEXT NOT NULL CHECK (entity IN ('product', 'category')),
+    entity_id INT NOT NULL,
+    op TEXT NOT NULL CHECK (op IN ('upsert', 'delete')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS catalog_changes_cursor_idx ON catalog_changes (xid, id);
+
+CREATE OR REPLACE FUNCTION record_catalog_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO catalog_changes (entity, entity_id, op) VALUES (TG_ARGV[0], OLD.id, 'delete');
+    ELSE
+        INSERT INTO catalog_changes (entity, entity_id, op) VALUES (TG_ARGV[0], NEW.id, 'upsert');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION record_barcode_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO catalog_changes (entity, entity_id, op) VALUES ('product', OLD.product_id, 'upsert');
+    ELSE
+        INSERT INTO catalog_changes (entity, entity_id, op) VALUES ('product', NEW.product_id, 'upsert');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS products_catalog_change ON products;
+CREATE TRIGGER products_catalog_change AFTER INSERT OR UPDATE OR DELETE ON products
+    FOR EACH ROW EXECUTE PROCEDURE record_catalog_change('product');
+
+DROP TRIGGER IF EXISTS categories_catalog_change ON categories;
+CREATE TRIGGER categories_catalog_change AFTER INSERT OR UPDATE OR DELETE ON categories
+    FOR EACH ROW EXECUTE PROCEDURE record_catalog_change('category');
+
+DROP TRIGGER IF EXISTS product_barcodes_catalog_change ON product_barcodes;
+CREATE TRIGGER product_barcodes_catalog_change AFTER INSERT OR UPDATE OR DELETE ON product_barcodes
+    FOR EACH ROW EXECUTE PROCEDURE record_barcode_change();
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS client_id UUID UNIQUE;
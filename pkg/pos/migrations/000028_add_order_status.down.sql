@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code = 'orders:void';
+
+DROP INDEX IF EXISTS orders_status_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS void_reason;
+ALTER TABLE orders DROP COLUMN IF EXISTS voided_by;
+ALTER TABLE orders DROP COLUMN IF EXISTS voided_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS status;
@@ -0,0 +1,22 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'open'
+    CHECK (status IN ('open', 'held', 'paid', 'refunded', 'voided'));
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS voided_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS voided_by INT REFERENCES employee(id) ON DELETE SET NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS void_reason TEXT NOT NULL DEFAULT '';
+
+UPDATE orders SET status = 'paid' WHERE paid_at IS NOT NULL;
+
+UPDATE orders o SET status = 'refunded'
+WHERE o.paid_at IS NOT NULL
+AND EXISTS (SELECT 1 FROM refunds r WHERE r.order_id = o.id)
+AND NOT EXISTS (
+    SELECT 1
+    FROM order_product op
+    WHERE op.order_id = o.id
+    AND op.qty > (SELECT COALESCE(SUM(l.qty), 0) FROM refund_lines l WHERE l.order_product_id = op.id)
+);
+
+CREATE INDEX IF NOT EXISTS orders_status_idx ON orders (status);
+
+INSERT INTO permissions (code)
+VALUES ('orders:void');
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key TEXT NOT NULL,
+    employee_id INT NOT NULL DEFAULT 0,
+    station_id INT NOT NULL DEFAULT 0,
+    request_hash BYTEA NOT NULL,
+    status INT,
+    content_type TEXT NOT NULL DEFAULT '',
+    body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP,
+    PRIMARY KEY (key, employee_id, station_id)
+);
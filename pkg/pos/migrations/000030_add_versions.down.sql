@@ -0,0 +1,4 @@
+ALTER TABLE employee DROP COLUMN IF EXISTS version;
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
+ALTER TABLE categories DROP COLUMN IF EXISTS version;
+ALTER TABLE products DROP COLUMN IF EXISTS version;
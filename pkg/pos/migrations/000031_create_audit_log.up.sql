@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    employee_id INT,
+    station_id INT,
+    action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete', 'import')),
+    entity TEXT NOT NULL CHECK (entity IN ('employee', 'product', 'category', 'order', 'permissions')),
+    entity_id INT,
+    changes JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id);
+CREATE INDEX IF NOT EXISTS audit_log_employee_idx ON audit_log (employee_id);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+CREATE OR REPLACE FUNCTION reject_audit_log_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE PROCEDURE reject_audit_log_change();
+
+INSERT INTO permissions (code) VALUES ('audit:read');
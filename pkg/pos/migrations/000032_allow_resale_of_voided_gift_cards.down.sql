@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS order_product_gift_card_id_idx;
+ALTER TABLE order_product ADD CONSTRAINT order_product_gift_card_id_key UNIQUE (gift_card_id);
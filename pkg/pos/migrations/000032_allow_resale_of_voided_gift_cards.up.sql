@@ -0,0 +1,4 @@
+-- A gift card sold on an order that was voided can be sold again, so the card may
+-- appear on several lines. lockGiftCardLine allows one line outside voided orders.
+ALTER TABLE order_product DROP CONSTRAINT IF EXISTS order_product_gift_card_id_key;
+CREATE INDEX IF NOT EXISTS order_product_gift_card_id_idx ON order_product (gift_card_id) WHERE gift_card_id IS NOT NULL;
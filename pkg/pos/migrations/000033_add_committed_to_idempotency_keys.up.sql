@@ -0,0 +1,3 @@
+-- A key is marked committed in the transaction of the change its request made, so it
+-- is never given back or reclaimed once that change is saved.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS committed BOOLEAN NOT NULL DEFAULT false;
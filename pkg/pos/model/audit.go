@@ -0,0 +1,233 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Actions recorded in the audit log. AuditImport covers a whole catalog import.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditImport = "import"
+)
+
+// Entities recorded in the audit log.
+const (
+	AuditEmployee    = "employee"
+	AuditProduct     = "product"
+	AuditCategory    = "category"
+	AuditOrder       = "order"
+	AuditPermissions = "permissions"
+)
+
+var (
+	AuditActions  = []string{AuditCreate, AuditUpdate, AuditDelete, AuditImport}
+	AuditEntities = []string{AuditEmployee, AuditProduct, AuditCategory, AuditOrder, AuditPermissions}
+)
+
+// auditRedacted lists fields whose values are never written to the audit log; a
+// change to them is recorded without the values.
+var auditRedacted = map[string]bool{"password": true}
+
+// auditIgnored lists fields that change with every write and say nothing by themselves.
+var auditIgnored = map[string]bool{"updated_at": true, "UpdatedAt": true}
+
+// AuditChange is the value of a field before and after a change. Before is null for a
+// created entity and after for a deleted one.
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// AuditEntry records who changed which entity, from which station and how. A nil
+// employee or station means the request carried none.
+type AuditEntry struct {
+	Id         int64                  `json:"id"`
+	EmployeeId *int                   `json:"employee_id"`
+	StationId  *int                   `json:"station_id"`
+	Action     string                 `json:"action"`
+	Entity     string                 `json:"entity"`
+	EntityId   *int                   `json:"entity_id"`
+	Changes    map[string]AuditChange `json:"changes"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// AuditActor is the employee and station a change is made by. A nil field means the
+// request carried none.
+type AuditActor struct {
+	EmployeeId *int
+	StationId  *int
+}
+
+// AuditQuery narrows the audit log down. Zero values match every entry; To is
+// exclusive.
+type AuditQuery struct {
+	EmployeeId int
+	StationId  int
+	Action     string
+	Entity     string
+	EntityId   int
+	From       time.Time
+	To         time.Time
+}
+
+type AuditModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// insertAudit appends an entry with the fields that differ between before and after,
+// which are the entity as the API returns it, read under the lock of the change. It
+// runs in the transaction of the change, so the change is only saved together with
+// its entry; a zero entityId records none. The log is append-only; there is no way to
+// change or remove an entry.
+func insertAudit(ctx context.Context, q queryer, by AuditActor, action, entity string, entityId int, before, after interface{}) error {
+	changes, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	var id *int
+	if entityId != 0 {
+		id = &entityId
+	}
+
+	query := `
+		INSERT INTO audit_log (employee_id, station_id, action, entity, entity_id, changes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = q.ExecContext(ctx, query, by.EmployeeId, by.StationId, action, entity, id, data)
+	return err
+}
+
+// auditSnapshot returns v as the audit log records it, for the before state of an
+// entity the change goes on to modify in place.
+func auditSnapshot(v interface{}) (json.RawMessage, error) {
+	return json.Marshal(v)
+}
+
+// GetAll returns a page of the entries matching q.
+func (m AuditModel) GetAll(q AuditQuery, filters Filters) ([]AuditEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, employee_id, station_id, action, entity, entity_id, changes, created_at
+		FROM audit_log
+		WHERE (employee_id = $1 OR $1 = 0)
+		AND (station_id = $2 OR $2 = 0)
+		AND (action = $3 OR $3 = '')
+		AND (entity = $4 OR $4 = '')
+		AND (entity_id = $5 OR $5 = 0)
+		AND created_at >= $6 AND created_at < $7
+		ORDER BY %s %s, id ASC
+		LIMIT $8 OFFSET $9
+	`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{q.EmployeeId, q.StationId, q.Action, q.Entity, q.EntityId, q.From, q.To, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var changes []byte
+		err := rows.Scan(&totalRecords, &e.Id, &e.EmployeeId, &e.StationId, &e.Action, &e.Entity, &e.EntityId, &changes, &e.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
+
+// auditDiff compares the JSON forms of before and after field by field. Either may be
+// nil, in which case every field of the other is a change.
+func auditDiff(before, after interface{}) (map[string]AuditChange, error) {
+	old, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]AuditChange)
+	for name, value := range old {
+		if !bytes.Equal(value, cur[name]) {
+			changes[name] = AuditChange{Before: value, After: cur[name]}
+		}
+	}
+	for name, value := range cur {
+		if _, ok := old[name]; !ok {
+			changes[name] = AuditChange{After: value}
+		}
+	}
+
+	for name, change := range changes {
+		switch {
+		case auditIgnored[name]:
+			delete(changes, name)
+		case auditRedacted[name]:
+			changes[name] = AuditChange{Before: redactAudit(change.Before), After: redactAudit(change.After)}
+		}
+	}
+
+	return changes, nil
+}
+
+// auditFields splits the JSON object v marshals to into its fields. A nil v, or one
+// that marshals to anything but an object, is recorded as a single "value" field.
+func auditFields(v interface{}) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return map[string]json.RawMessage{"value": data}, nil
+	}
+	return fields, nil
+}
+
+func redactAudit(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	return json.RawMessage(`"[redacted]"`)
+}
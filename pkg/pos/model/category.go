@@ -3,15 +3,41 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"pos-rs/pkg/pos/validator"
 	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrCategoryCycle is returned when a category would be moved under itself or one
+	// of its descendants.
+	ErrCategoryCycle = errors.New("category cannot be moved into its own subtree")
+
+	// ErrCategoryInUse is returned when a category with subcategories or products is
+	// deleted.
+	ErrCategoryInUse = errors.New("category has subcategories or products")
+
+	// ErrInvalidOrder is returned when a reorder does not list exactly the children of
+	// the parent.
+	ErrInvalidOrder = errors.New("order must list every child of the parent exactly once")
 )
 
+// Category is a node of the category tree. Root categories have no ParentId; Position
+// orders a category among its siblings, starting at 0.
 type Category struct {
-	Id        int       `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	TaxRate   float64    `json:"tax_rate"`
+	ParentId  *int       `json:"parent_id"`
+	Position  int        `json:"position"`
+	Children  []Category `json:"children,omitempty"`
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
 }
 
 type CategoryModule struct {
@@ -20,21 +46,47 @@ type CategoryModule struct {
 	ErrorLog *log.Logger
 }
 
-func (c CategoryModule) Create(category *Category) error {
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(category.TaxRate >= 0, "tax_rate", "must not be negative")
+	v.Check(category.ParentId == nil || *category.ParentId > 0, "parent_id", "must be a positive integer")
+}
+
+// Create adds the category as the last child of its parent.
+func (c CategoryModule) Create(category *Category, by AuditActor) error {
 	query := `
-			INSERT INTO categories (name)
-			VALUES ($1)
-			RETURNING id
+			INSERT INTO categories (name, tax_rate, parent_id, position)
+			VALUES ($1, $2, $3, (SELECT COUNT(*) FROM categories WHERE parent_id IS NOT DISTINCT FROM $3))
+			RETURNING id, position, version, created_at, updated_at
 			`
-	args := []interface{}{category.Name}
+	args := []interface{}{category.Name, category.TaxRate, category.ParentId}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return c.DB.QueryRowContext(ctx, query, args...).Scan(&category.Id)
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&category.Id, &category.Position, &category.Version, &category.CreatedAt, &category.UpdatedAt)
+	if err != nil {
+		return categoryError(err)
+	}
+	if err := insertAudit(ctx, tx, by, AuditCreate, AuditCategory, category.Id, nil, category); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
+// GetAll returns every category as a flat list, siblings in their order.
 func (c CategoryModule) GetAll() (*[]Category, error) {
-	query := `SELECT * from categories`
+	query := `
+			SELECT id, name, tax_rate, parent_id, position, version, created_at, updated_at
+			FROM categories
+			ORDER BY parent_id NULLS FIRST, position, id
+			`
 
 	var categories []Category
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -48,7 +100,7 @@ func (c CategoryModule) GetAll() (*[]Category, error) {
 
 	for rows.Next() {
 		var ctg Category
-		err := rows.Scan(&ctg.Id, &ctg.Name, &ctg.CreatedAt, &ctg.UpdatedAt)
+		err := rows.Scan(&ctg.Id, &ctg.Name, &ctg.TaxRate, &ctg.ParentId, &ctg.Position, &ctg.Version, &ctg.CreatedAt, &ctg.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -62,48 +114,348 @@ func (c CategoryModule) GetAll() (*[]Category, error) {
 	return &categories, nil
 }
 
+// Tree returns the root categories with their descendants nested in Children.
+func (c CategoryModule) Tree() ([]Category, error) {
+	all, err := c.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int][]Category)
+	roots := []Category{}
+	for _, ctg := range *all {
+		if ctg.ParentId == nil {
+			roots = append(roots, ctg)
+			continue
+		}
+		children[*ctg.ParentId] = append(children[*ctg.ParentId], ctg)
+	}
+
+	var attach func(nodes []Category)
+	attach = func(nodes []Category) {
+		for i := range nodes {
+			nodes[i].Children = children[nodes[i].Id]
+			attach(nodes[i].Children)
+		}
+	}
+	attach(roots)
+
+	return roots, nil
+}
+
 func (c CategoryModule) Get(id int) (*Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getCategory(ctx, c.DB, id, false)
+}
+
+// Update renames the category and changes its tax rate. Its place in the tree only
+// changes through Move and Reorder. category.Version must be the version the change
+// was made against; if the category changed since, ErrEditConflict is returned with
+// category.Version set to the current version.
+func (c CategoryModule) Update(id int, category *Category, by AuditActor) error {
 	query := `
-			SELECT * FROM categories
-			WHERE id = $1
+			UPDATE categories
+			SET name = $1, tax_rate = $2, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3 AND version = $4
+			RETURNING parent_id, position, version, created_at, updated_at
 			`
+	args := []interface{}{category.Name, category.TaxRate, id, category.Version}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	var category Category
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getCategory(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&category.ParentId, &category.Position, &category.Version, &category.CreatedAt, &category.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return editConflict(ctx, tx, "categories", id, &category.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	category.Id = id
+	if err := insertAudit(ctx, tx, by, AuditUpdate, AuditCategory, id, before, category); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Move puts the category under parentId (nil for the root) at the given position
+// among its new siblings, shifting the siblings to make room. Positions past the end
+// append the category.
+func (c CategoryModule) Move(id int, parentId *int, position int, by AuditActor) (*Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := c.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&category.Id, &category.Name, &category.CreatedAt, &category.UpdatedAt)
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Moves are serialized so sibling positions stay dense.
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return nil, err
+	}
 
+	before, err := getCategory(ctx, tx, id, false)
 	if err != nil {
 		return nil, err
 	}
 
-	return &category, nil
-}
+	if parentId != nil {
+		query := `
+			WITH RECURSIVE subtree AS (
+				SELECT id FROM categories WHERE id = $1
+				UNION ALL
+				SELECT c.id FROM categories c INNER JOIN subtree s ON c.parent_id = s.id
+			)
+			SELECT EXISTS (SELECT 1 FROM categories WHERE id = $2),
+				EXISTS (SELECT 1 FROM subtree WHERE id = $2)
+		`
+		var exists, cycle bool
+		if err := tx.QueryRowContext(ctx, query, id, *parentId).Scan(&exists, &cycle); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: parent category %d", ErrRecordNotFound, *parentId)
+		}
+		if cycle {
+			return nil, ErrCategoryCycle
+		}
+	}
 
-func (c CategoryModule) Update(id int, category *Category) error {
 	query := `
-			UPDATE categories 
-			SET name = $1, updated_at = CURRENT_TIMESTAMP 
-			WHERE id = $2
-			`
-	args := []interface{}{category.Name, id}
+		UPDATE categories
+		SET position = position - 1
+		WHERE parent_id IS NOT DISTINCT FROM $1 AND position > $2
+	`
+	if _, err := tx.ExecContext(ctx, query, before.ParentId, before.Position); err != nil {
+		return nil, err
+	}
+
+	var siblings int
+	query = `SELECT COUNT(*) FROM categories WHERE parent_id IS NOT DISTINCT FROM $1 AND id <> $2`
+	if err := tx.QueryRowContext(ctx, query, parentId, id).Scan(&siblings); err != nil {
+		return nil, err
+	}
+	if position < 0 || position > siblings {
+		position = siblings
+	}
+
+	query = `
+		UPDATE categories
+		SET position = position + 1
+		WHERE parent_id IS NOT DISTINCT FROM $1 AND position >= $2 AND id <> $3
+	`
+	if _, err := tx.ExecContext(ctx, query, parentId, position, id); err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE categories
+		SET parent_id = $1, position = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING id, name, tax_rate, parent_id, position, version, created_at, updated_at
+	`
+	var category Category
+	err = tx.QueryRowContext(ctx, query, parentId, position, id).Scan(&category.Id, &category.Name, &category.TaxRate,
+		&category.ParentId, &category.Position, &category.Version, &category.CreatedAt, &category.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertAudit(ctx, tx, by, AuditUpdate, AuditCategory, id, before, category); err != nil {
+		return nil, err
+	}
+
+	return &category, tx.Commit()
+}
+
+// Reorder sets the order of the children of parentId (nil for the root categories)
+// to ids, which must list each of them exactly once. Every category whose position
+// changes gets its own audit entry.
+func (c CategoryModule) Reorder(parentId *int, ids []int, by AuditActor) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := c.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	children, err := getChildCategories(ctx, tx, parentId)
+	if err != nil {
+		return err
+	}
+
+	before := make(map[int]Category, len(children))
+	for _, ctg := range children {
+		before[ctg.Id] = ctg
+	}
+	listed := make(map[int]bool)
+	for _, id := range ids {
+		if _, ok := before[id]; ok {
+			listed[id] = true
+		}
+	}
+	if len(children) != len(ids) || len(listed) != len(ids) {
+		return ErrInvalidOrder
+	}
+
+	query := `
+		UPDATE categories c
+		SET position = o.position - 1, updated_at = CURRENT_TIMESTAMP
+		FROM unnest($1::INT[]) WITH ORDINALITY AS o(id, position)
+		WHERE c.id = o.id
+	`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(ids)); err != nil {
+		return err
+	}
+
+	after, err := getChildCategories(ctx, tx, parentId)
+	if err != nil {
+		return err
+	}
+	for _, ctg := range after {
+		if prev := before[ctg.Id]; prev.Position != ctg.Position {
+			if err := insertAudit(ctx, tx, by, AuditUpdate, AuditCategory, ctg.Id, prev, ctg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (c CategoryModule) Delete(id int) error {
+// Delete removes a category without subcategories or products and closes the gap
+// among its siblings.
+func (c CategoryModule) Delete(id int, by AuditActor) error {
 	query := `
 			DELETE FROM categories
 			WHERE id = $1
+			RETURNING parent_id, position
 			`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := c.DB.ExecContext(ctx, query, id)
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getCategory(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+
+	var parentId *int
+	var position int
+	err = tx.QueryRowContext(ctx, query, id).Scan(&parentId, &position)
+	if err != nil {
+		return categoryError(err)
+	}
+
+	query = `
+		UPDATE categories
+		SET position = position - 1
+		WHERE parent_id IS NOT DISTINCT FROM $1 AND position > $2
+	`
+	if _, err := tx.ExecContext(ctx, query, parentId, position); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, by, AuditDelete, AuditCategory, id, before, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// categoryColumns is the column list every category query selects.
+const categoryColumns = `id, name, tax_rate, parent_id, position, version, created_at, updated_at`
+
+// getCategory loads a category. With forUpdate set its row is locked until the
+// surrounding transaction ends.
+func getCategory(ctx context.Context, q queryer, id int, forUpdate bool) (*Category, error) {
+	query := `
+		SELECT ` + categoryColumns + `
+		FROM categories
+		WHERE id = $1
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var category Category
+	err := q.QueryRowContext(ctx, query, id).Scan(categoryFields(&category)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// getChildCategories returns the children of parentId (nil for the root categories)
+// in their order.
+func getChildCategories(ctx context.Context, q queryer, parentId *int) ([]Category, error) {
+	query := `
+		SELECT ` + categoryColumns + `
+		FROM categories
+		WHERE parent_id IS NOT DISTINCT FROM $1
+		ORDER BY position, id
+	`
+	rows, err := q.QueryContext(ctx, query, parentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var ctg Category
+		if err := rows.Scan(categoryFields(&ctg)...); err != nil {
+			return nil, err
+		}
+		categories = append(categories, ctg)
+	}
+
+	return categories, rows.Err()
+}
+
+// categoryFields returns the scan destinations of categoryColumns.
+func categoryFields(ctg *Category) []interface{} {
+	return []interface{}{&ctg.Id, &ctg.Name, &ctg.TaxRate, &ctg.ParentId, &ctg.Position, &ctg.Version, &ctg.CreatedAt, &ctg.UpdatedAt}
+}
+
+// categoryError maps foreign key violations on categories to model errors: a missing
+// parent on insert, or subcategories and products on delete.
+func categoryError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		if pqErr.Table == "categories" && pqErr.Constraint == "categories_parent_id_fkey" {
+			return fmt.Errorf("%w: parent category", ErrRecordNotFound)
+		}
+		return ErrCategoryInUse
+	}
 	return err
 }
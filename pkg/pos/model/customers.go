@@ -0,0 +1,265 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrDuplicatePhone is returned when a phone number already belongs to another customer.
+	ErrDuplicatePhone = errors.New("phone number already belongs to a customer")
+
+	// ErrUnknownCustomer is returned when an order refers to a customer that does not exist.
+	ErrUnknownCustomer = errors.New("unknown customer")
+)
+
+// Customer is a shopper known by phone number, which is how the till looks them up.
+// Points is the loyalty balance; it only changes through the loyalty ledger.
+// ConsentUpdatedAt records when either consent flag last changed.
+type Customer struct {
+	Id               int        `json:"id"`
+	Phone            string     `json:"phone"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	MarketingConsent bool       `json:"marketing_consent"`
+	SmsConsent       bool       `json:"sms_consent"`
+	ConsentUpdatedAt *time.Time `json:"consent_updated_at"`
+	Points           int        `json:"points"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+type CustomerModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// NormalizePhone strips the spaces, dashes, dots and parentheses people type into
+// phone numbers, keeping the digits and a leading "+".
+func NormalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	var b strings.Builder
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// ValidateCustomer checks a customer whose phone is already normalized.
+func ValidateCustomer(v *validator.Validator, c *Customer) {
+	digits := len(strings.TrimPrefix(c.Phone, "+"))
+	v.Check(c.Phone != "", "phone", "must be provided")
+	v.Check(c.Phone == "" || (digits >= 7 && digits <= 15), "phone", "must have between 7 and 15 digits")
+	v.Check(c.Email == "" || validator.Matches(c.Email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+func (m CustomerModel) Create(c *Customer) error {
+	query := `
+		INSERT INTO customers (phone, name, email, marketing_consent, sms_consent, consent_updated_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $4 OR $5 THEN CURRENT_TIMESTAMP END)
+		RETURNING id, consent_updated_at, points, created_at, updated_at
+	`
+	args := []interface{}{c.Phone, c.Name, c.Email, c.MarketingConsent, c.SmsConsent}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&c.Id, &c.ConsentUpdatedAt, &c.Points, &c.CreatedAt, &c.UpdatedAt)
+	return customerError(err)
+}
+
+func (m CustomerModel) Get(id int) (*Customer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getCustomer(ctx, m.DB, `id = $1`, id)
+}
+
+// GetByPhone looks a customer up by phone number in any formatting.
+func (m CustomerModel) GetByPhone(phone string) (*Customer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getCustomer(ctx, m.DB, `phone = $1`, NormalizePhone(phone))
+}
+
+// GetAll returns a page of customers whose name, phone or email contains search.
+func (m CustomerModel) GetAll(search string, filters Filters) ([]Customer, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, phone, name, email, marketing_consent, sms_consent, consent_updated_at, points, created_at, updated_at
+		FROM customers
+		WHERE ($1 = '' OR name ILIKE '%%' || $1 || '%%' OR phone LIKE '%%' || $1 || '%%' OR email ILIKE '%%' || $1 || '%%')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	customers := []Customer{}
+	for rows.Next() {
+		var c Customer
+		err := rows.Scan(&totalRecords, &c.Id, &c.Phone, &c.Name, &c.Email, &c.MarketingConsent, &c.SmsConsent,
+			&c.ConsentUpdatedAt, &c.Points, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		customers = append(customers, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return customers, metadata, nil
+}
+
+// Update changes the contact details and consent flags of a customer. The points
+// balance is left alone.
+func (m CustomerModel) Update(id int, c *Customer) error {
+	query := `
+		UPDATE customers
+		SET phone = $1, name = $2, email = $3, marketing_consent = $4, sms_consent = $5,
+			consent_updated_at = CASE WHEN marketing_consent <> $4 OR sms_consent <> $5 THEN CURRENT_TIMESTAMP ELSE consent_updated_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6
+		RETURNING consent_updated_at, points, created_at, updated_at
+	`
+	args := []interface{}{c.Phone, c.Name, c.Email, c.MarketingConsent, c.SmsConsent, id}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&c.ConsentUpdatedAt, &c.Points, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	c.Id = id
+	return customerError(err)
+}
+
+// Delete removes a customer and their loyalty ledger. Their orders stay, without a
+// customer.
+func (m CustomerModel) Delete(id int) error {
+	query := `
+		DELETE FROM customers
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Orders returns a page of the customer's purchase history, newest first by default,
+// with the lines of every order.
+func (m CustomerModel) Orders(customerId int, filters Filters) ([]Order, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), `+orderColumns+`
+		FROM orders
+		WHERE customer_id = $1
+		ORDER BY %s %s, id DESC
+		LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, customerId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []Order{}
+	ids := []int{}
+	for rows.Next() {
+		var ord Order
+		if err := rows.Scan(append([]interface{}{&totalRecords}, orderFields(&ord)...)...); err != nil {
+			return nil, Metadata{}, err
+		}
+		orders = append(orders, ord)
+		ids = append(ids, ord.Id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	lines, err := getOrderProducts(ctx, m.DB, ids...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	for i := range orders {
+		orders[i].Products = linesOrEmpty(lines[orders[i].Id])
+		orders[i].Taxes = summarizeTaxes(&orders[i])
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return orders, metadata, nil
+}
+
+func getCustomer(ctx context.Context, q queryer, where string, arg interface{}) (*Customer, error) {
+	query := `
+		SELECT id, phone, name, email, marketing_consent, sms_consent, consent_updated_at, points, created_at, updated_at
+		FROM customers
+		WHERE ` + where
+
+	var c Customer
+	err := q.QueryRowContext(ctx, query, arg).Scan(&c.Id, &c.Phone, &c.Name, &c.Email, &c.MarketingConsent,
+		&c.SmsConsent, &c.ConsentUpdatedAt, &c.Points, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// checkCustomer makes sure an order's customer exists; a nil id means no customer.
+func checkCustomer(ctx context.Context, q queryer, id *int) error {
+	if id == nil {
+		return nil
+	}
+
+	var exists bool
+	if err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM customers WHERE id = $1)`, *id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %d", ErrUnknownCustomer, *id)
+	}
+	return nil
+}
+
+func customerError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "customers_phone_key" {
+		return ErrDuplicatePhone
+	}
+	return err
+}
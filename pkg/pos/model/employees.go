@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"log"
 	"time"
 	//"google.golang.org/protobuf/types/known/emptypb"
@@ -20,6 +21,7 @@ type Employee struct {
 	Activated   bool      `json:"activated"`
 	PhoneNumber string    `json:"phoneNumber"`
 	Enrolled    time.Time `json:"enrolled"`
+	Version     int       `json:"version"`
 }
 
 type EmployeeModel struct {
@@ -32,24 +34,38 @@ func (e *Employee) IsAnonymous() bool {
 	return e == AnonymousEmployee
 	}
 
-func (e EmployeeModel) Register(emp *Employee) error {
+func (e EmployeeModel) Register(emp *Employee, by AuditActor) error {
 	query := `
 			INSERT INTO employee (name, surname, password, is_admin, activated, phone_number, enrolled) 
 			VALUES ($1, $2, $3, $4, $5, $6, $7)
-			RETURNING id, password
+			RETURNING id, password, version
 			`
 	args := []interface{}{emp.Name, emp.Surname, emp.Password, emp.IsAdmin, emp.Activated, emp.PhoneNumber, emp.Enrolled}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return e.DB.QueryRowContext(ctx, query, args...).Scan(&emp.Id, &emp.Password)
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&emp.Id, &emp.Password, &emp.Version); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, by, AuditCreate, AuditEmployee, emp.Id, nil, emp); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m EmployeeModel) GetForToken(tokenScope, tokenPlaintext string) (*Employee, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-	SELECT employee.id, employee.name, employee.surname, employee.password, employee.activated, employee.is_admin
+	SELECT employee.id, employee.name, employee.surname, employee.password, employee.activated, employee.is_admin,
+		employee.phone_number, employee.enrolled, employee.version
 	FROM employee
 	INNER JOIN tokens
 	ON employee.id = tokens.user_id
@@ -69,6 +85,9 @@ func (m EmployeeModel) GetForToken(tokenScope, tokenPlaintext string) (*Employee
 		&emp.Password,
 		&emp.Activated,
 		&emp.IsAdmin,
+		&emp.PhoneNumber,
+		&emp.Enrolled,
+		&emp.Version,
 	)
 	if err != nil {
 		return nil, err
@@ -78,24 +97,17 @@ func (m EmployeeModel) GetForToken(tokenScope, tokenPlaintext string) (*Employee
 }
 
 func (e EmployeeModel) Get(id int) (*Employee, error) {
-	query := `SELECT * FROM employee where id = $1`
-
-	var emp Employee
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := e.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&emp.Id, &emp.Name, &emp.Surname, &emp.Password, &emp.IsAdmin, &emp.Activated,  &emp.PhoneNumber, &emp.Enrolled)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &emp, nil
+	return getEmployee(ctx, e.DB, id, false)
 }
 
 func (e EmployeeModel) GetAll() (*[]Employee, error) {
-	query := `SELECT * from employee`
+	query := `
+	SELECT id, name, surname, password, is_admin, activated, phone_number, enrolled, version
+	FROM employee
+	ORDER BY id`
 
 	var emp []Employee
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -109,7 +121,7 @@ func (e EmployeeModel) GetAll() (*[]Employee, error) {
 
 	for rows.Next() {
 		var employee Employee
-		err := rows.Scan(&employee.Id, &employee.Name, &employee.Surname, &employee.Password, &employee.IsAdmin, &employee.PhoneNumber, &employee.Enrolled)
+		err := rows.Scan(&employee.Id, &employee.Name, &employee.Surname, &employee.Password, &employee.IsAdmin, &employee.Activated, &employee.PhoneNumber, &employee.Enrolled, &employee.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -123,26 +135,96 @@ func (e EmployeeModel) GetAll() (*[]Employee, error) {
 	return &emp, nil
 }
 
-func (e EmployeeModel) Update(id int, emp *Employee) error {
+// Update overwrites the employee if it is still at emp.Version. Otherwise it returns
+// ErrEditConflict with emp.Version set to the current version.
+func (e EmployeeModel) Update(id int, emp *Employee, by AuditActor) error {
 	query := `
 			UPDATE employee 
-			SET name = $1, surname = $2, password = $3, is_admin = $4, activated = $5, phone_number = $6
-			WHERE id = $7
-			RETURNING id, password
+			SET name = $1, surname = $2, password = $3, is_admin = $4, activated = $5, phone_number = $6, version = version + 1
+			WHERE id = $7 AND version = $8
+			RETURNING id, password, version
 	`
-	args := []interface{}{emp.Name, emp.Surname, emp.Password, emp.IsAdmin, emp.Activated,emp.PhoneNumber,id}
+	args := []interface{}{emp.Name, emp.Surname, emp.Password, emp.IsAdmin, emp.Activated, emp.PhoneNumber, id, emp.Version}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return e.DB.QueryRowContext(ctx, query, args...).Scan(&emp.Id, &emp.Password)
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getEmployee(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&emp.Id, &emp.Password, &emp.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return editConflict(ctx, tx, "employee", id, &emp.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	after, err := getEmployee(ctx, tx, id, false)
+	if err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, by, AuditUpdate, AuditEmployee, id, before, after); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (e EmployeeModel) Delete(id int) error {
+func (e EmployeeModel) Delete(id int, by AuditActor) error {
 	query := `DELETE FROM employee WHERE id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := e.DB.ExecContext(ctx, query, id)
-	return err
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getEmployee(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, by, AuditDelete, AuditEmployee, id, before, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getEmployee loads an employee. With forUpdate set the row is locked until the
+// surrounding transaction ends.
+func getEmployee(ctx context.Context, q queryer, id int, forUpdate bool) (*Employee, error) {
+	query := `
+	SELECT id, name, surname, password, is_admin, activated, phone_number, enrolled, version
+	FROM employee
+	WHERE id = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var emp Employee
+	err := q.QueryRowContext(ctx, query, id).Scan(&emp.Id, &emp.Name, &emp.Surname, &emp.Password, &emp.IsAdmin, &emp.Activated,
+		&emp.PhoneNumber, &emp.Enrolled, &emp.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &emp, nil
 }
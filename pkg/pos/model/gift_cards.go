@@ -0,0 +1,544 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	GiftCardTypeGiftCard    = "gift_card"
+	GiftCardTypeStoreCredit = "store_credit"
+)
+
+const (
+	GiftCardInactive = "inactive"
+	GiftCardActive   = "active"
+	GiftCardVoid     = "void"
+)
+
+var GiftCardStatuses = []string{GiftCardInactive, GiftCardActive, GiftCardVoid}
+
+const (
+	GiftCardActivation = "activation"
+	GiftCardRedeem     = "redeem"
+	GiftCardCredit     = "credit"
+	GiftCardVoided     = "void"
+)
+
+// giftCardAlphabet leaves out letters and digits that are easily confused when a code
+// is read out or typed in.
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// giftCardCodeLength is the length of generated codes.
+const giftCardCodeLength = 16
+
+var (
+	// ErrUnknownGiftCard is returned when no gift card has the given code.
+	ErrUnknownGiftCard = errors.New("unknown gift card")
+
+	// ErrDuplicateGiftCard is returned when a gift card is issued with a code already in use.
+	ErrDuplicateGiftCard = errors.New("gift card code already exists")
+
+	// ErrGiftCardSold is returned when a gift card that is already sold or void is sold again.
+	ErrGiftCardSold = errors.New("gift card is already sold")
+
+	// ErrGiftCardInactive is returned when a gift card that is not active is redeemed or credited.
+	ErrGiftCardInactive = errors.New("gift card is not active")
+
+	// ErrInsufficientBalance is returned when more is redeemed than is left on a gift card.
+	ErrInsufficientBalance = errors.New("insufficient gift card balance")
+
+	// ErrGiftCardUsed is returned when a sold gift card is refunded after part of it was spent.
+	ErrGiftCardUsed = errors.New("gift card balance is partly spent")
+)
+
+// GiftCard is a prepaid balance identified by its code. Gift cards are issued
+// inactive with the Amount they are sold for and loaded with it once the order that
+// sells them is paid; store credit cards are issued active by refunds. The balance
+// only changes through the gift card ledger.
+type GiftCard struct {
+	Id          int        `json:"id"`
+	Code        string     `json:"code"`
+	Type        string     `json:"type"`
+	Status      string     `json:"status"`
+	Amount      Money      `json:"amount"`
+	Balance     Money      `json:"balance"`
+	CustomerId  *int       `json:"customer_id"`
+	ActivatedAt *time.Time `json:"activated_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// GiftCardTransaction is an entry of the append-only gift card ledger. Amount is the
+// signed change of the balance and Balance the balance right after it.
+type GiftCardTransaction struct {
+	Id         int64     `json:"id"`
+	GiftCardId int       `json:"gift_card_id"`
+	Type       string    `json:"type"`
+	Amount     Money     `json:"amount"`
+	Balance    Money     `json:"balance"`
+	OrderId    *int      `json:"order_id"`
+	EmployeeId int       `json:"employee_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type GiftCardModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// NormalizeGiftCardCode uppercases a code and drops the spaces and dashes it may be
+// printed or typed with.
+func NormalizeGiftCardCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// MaskGiftCardCode hides all but the last four characters of a code, for receipts.
+func MaskGiftCardCode(code string) string {
+	if len(code) <= 4 {
+		return code
+	}
+	return strings.Repeat("*", 4) + code[len(code)-4:]
+}
+
+// ValidateGiftCard checks a gift card to issue whose code is already normalized. An
+// empty code is generated.
+func ValidateGiftCard(v *validator.Validator, g *GiftCard) {
+	v.Check(g.Amount.IsPositive(), "amount", "must be greater than zero")
+	v.Check(len(g.Code) <= 64, "code", "must not be more than 64 characters long")
+	v.Check(g.Code == "" || len(g.Code) >= 8, "code", "must be at least 8 characters long")
+}
+
+// Issue creates an inactive gift card for Amount, generating its code unless one is
+// given.
+func (m GiftCardModel) Issue(g *GiftCard) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	g.Type = GiftCardTypeGiftCard
+	g.Status = GiftCardInactive
+	g.Balance = NewMoney(0)
+	return insertGiftCard(ctx, m.DB, g)
+}
+
+func (m GiftCardModel) Get(id int) (*GiftCard, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getGiftCard(ctx, m.DB, `id = $1`, id, false)
+}
+
+// GetByCode looks a gift card up by its code in any formatting.
+func (m GiftCardModel) GetByCode(code string) (*GiftCard, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getGiftCard(ctx, m.DB, `code = $1`, NormalizeGiftCardCode(code), false)
+}
+
+// GetAll returns a page of gift cards, optionally of one status or customer.
+func (m GiftCardModel) GetAll(status string, customerId int, filters Filters) ([]GiftCard, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, code, type, status, amount, balance, customer_id, activated_at, created_at, updated_at
+		FROM gift_cards
+		WHERE (status = $1 OR $1 = '')
+		AND (customer_id = $2 OR $2 = 0)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, customerId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	cards := []GiftCard{}
+	for rows.Next() {
+		var g GiftCard
+		err := rows.Scan(&totalRecords, &g.Id, &g.Code, &g.Type, &g.Status, &g.Amount, &g.Balance, &g.CustomerId,
+			&g.ActivatedAt, &g.CreatedAt, &g.UpdatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		cards = append(cards, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return cards, metadata, nil
+}
+
+// Transactions returns the whole ledger of a gift card, oldest first.
+func (m GiftCardModel) Transactions(id int) ([]GiftCardTransaction, error) {
+	query := `
+		SELECT id, gift_card_id, type, amount, balance, order_id, COALESCE(employee_id, 0), reason, created_at
+		FROM gift_card_transactions
+		WHERE gift_card_id = $1
+		ORDER BY id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []GiftCardTransaction{}
+	for rows.Next() {
+		var t GiftCardTransaction
+		err := rows.Scan(&t.Id, &t.GiftCardId, &t.Type, &t.Amount, &t.Balance, &t.OrderId, &t.EmployeeId, &t.Reason, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+
+	return transactions, rows.Err()
+}
+
+// Void takes whatever is left on a gift card off it and voids the card.
+func (m GiftCardModel) Void(id int, employeeId int, reason string) (*GiftCard, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	card, err := getGiftCard(ctx, tx, `id = $1`, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := voidGiftCard(ctx, tx, card, nil, employeeId, reason); err != nil {
+		return nil, err
+	}
+
+	return card, tx.Commit()
+}
+
+func insertGiftCard(ctx context.Context, q queryer, g *GiftCard) error {
+	if g.Code == "" {
+		code, err := generateGiftCardCode()
+		if err != nil {
+			return err
+		}
+		g.Code = code
+	}
+
+	query := `
+		INSERT INTO gift_cards (code, type, status, amount, balance, customer_id, activated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at
+	`
+	args := []interface{}{g.Code, g.Type, g.Status, g.Amount, g.Balance, g.CustomerId, g.ActivatedAt}
+	err := q.QueryRowContext(ctx, query, args...).Scan(&g.Id, &g.CreatedAt, &g.UpdatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "gift_cards_code_key" {
+		return ErrDuplicateGiftCard
+	}
+	return err
+}
+
+// getGiftCard loads a gift card. With forUpdate set the card row is locked until the
+// surrounding transaction ends.
+func getGiftCard(ctx context.Context, q queryer, where string, arg interface{}, forUpdate bool) (*GiftCard, error) {
+	query := `
+		SELECT id, code, type, status, amount, balance, customer_id, activated_at, created_at, updated_at
+		FROM gift_cards
+		WHERE ` + where
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var g GiftCard
+	err := q.QueryRowContext(ctx, query, arg).Scan(&g.Id, &g.Code, &g.Type, &g.Status, &g.Amount, &g.Balance,
+		&g.CustomerId, &g.ActivatedAt, &g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// lockGiftCard locks the gift card with the given code for a sale, payment or refund.
+func lockGiftCard(ctx context.Context, q queryer, code string) (*GiftCard, error) {
+	card, err := getGiftCard(ctx, q, `code = $1`, NormalizeGiftCardCode(code), true)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownGiftCard, code)
+	}
+	return card, err
+}
+
+// moveGiftCard is the only way the balance of a gift card changes: it applies the
+// transaction to gift_cards.balance and appends it to the ledger with the new
+// balance. A transaction that would take the balance below zero fails with
+// ErrInsufficientBalance.
+func moveGiftCard(ctx context.Context, q queryer, t *GiftCardTransaction) error {
+	query := `
+		UPDATE gift_cards
+		SET balance = balance + $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND balance + $1 >= 0
+		RETURNING balance
+	`
+	err := q.QueryRowContext(ctx, query, t.Amount, t.GiftCardId).Scan(&t.Balance)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var balance Money
+		err := q.QueryRowContext(ctx, `SELECT balance FROM gift_cards WHERE id = $1`, t.GiftCardId).Scan(&balance)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s left, %s requested", ErrInsufficientBalance, balance, t.Amount.Neg())
+	}
+
+	query = `
+		INSERT INTO gift_card_transactions (gift_card_id, type, amount, balance, order_id, employee_id, reason)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7)
+		RETURNING id, created_at
+	`
+	args := []interface{}{t.GiftCardId, t.Type, t.Amount, t.Balance, t.OrderId, t.EmployeeId, t.Reason}
+	return q.QueryRowContext(ctx, query, args...).Scan(&t.Id, &t.CreatedAt)
+}
+
+// lockGiftCardLine prices an order line that sells the inactive gift card with the
+// line's code. The line is a single card at its amount, without tax or stock.
+func lockGiftCardLine(ctx context.Context, q queryer, line *OrderProduct) error {
+	card, err := lockGiftCard(ctx, q, line.GiftCardCode)
+	if err != nil {
+		return err
+	}
+
+	if card.Status != GiftCardInactive || card.Type != GiftCardTypeGiftCard {
+		return fmt.Errorf("%w: %s", ErrGiftCardSold, MaskGiftCardCode(card.Code))
+	}
+
+	var onOrder bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM order_product op
+			INNER JOIN orders o ON o.id = op.order_id
+			WHERE op.gift_card_id = $1 AND o.status <> 'voided'
+		)
+	`
+	if err := q.QueryRowContext(ctx, query, card.Id).Scan(&onOrder); err != nil {
+		return err
+	}
+	if onOrder {
+		return fmt.Errorf("%w: %s is on another order", ErrGiftCardSold, MaskGiftCardCode(card.Code))
+	}
+
+	line.GiftCardId = &card.Id
+	line.GiftCardCode = card.Code
+	line.Qty = 1
+	line.Price = card.Amount
+	line.TotalNormalPrice = card.Amount
+	line.ManualDiscount = Money{}
+	line.TaxRate = 0
+	return nil
+}
+
+// activateGiftCards loads the gift cards sold on a just paid order with their amounts.
+func activateGiftCards(ctx context.Context, q queryer, order *Order, employeeId int) error {
+	for _, line := range order.Products {
+		if line.GiftCardId == nil {
+			continue
+		}
+
+		query := `
+			UPDATE gift_cards
+			SET status = $1, activated_at = CURRENT_TIMESTAMP, customer_id = COALESCE(customer_id, $2)
+			WHERE id = $3 AND status = $4
+			RETURNING amount
+		`
+		var amount Money
+		err := q.QueryRowContext(ctx, query, GiftCardActive, order.CustomerId, *line.GiftCardId, GiftCardInactive).Scan(&amount)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrGiftCardSold, MaskGiftCardCode(line.GiftCardCode))
+		}
+		if err != nil {
+			return err
+		}
+
+		t := GiftCardTransaction{
+			GiftCardId: *line.GiftCardId,
+			Type:       GiftCardActivation,
+			Amount:     amount,
+			OrderId:    &order.Id,
+			EmployeeId: employeeId,
+		}
+		if err := moveGiftCard(ctx, q, &t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveGiftCardTender locks the card of a gift card tender. A tender without an
+// amount takes as much of the balance as covers due.
+func resolveGiftCardTender(ctx context.Context, q queryer, tender *Payment, due Money) error {
+	card, err := lockGiftCard(ctx, q, tender.GiftCardCode)
+	if err != nil {
+		return err
+	}
+
+	if card.Status != GiftCardActive {
+		return fmt.Errorf("%w: %s", ErrGiftCardInactive, MaskGiftCardCode(card.Code))
+	}
+
+	tender.GiftCardId = &card.Id
+	tender.GiftCardCode = card.Code
+	if tender.Amount.IsZero() {
+		tender.Amount = card.Balance.Min(due)
+	}
+	if !tender.Amount.IsPositive() {
+		return fmt.Errorf("%w: %s has %s left", ErrInsufficientBalance, MaskGiftCardCode(card.Code), card.Balance)
+	}
+	return nil
+}
+
+// redeemGiftCard takes a gift card tender off the balance of its card.
+func redeemGiftCard(ctx context.Context, q queryer, order *Order, tender *Payment) error {
+	t := GiftCardTransaction{
+		GiftCardId: *tender.GiftCardId,
+		Type:       GiftCardRedeem,
+		Amount:     tender.Amount.Neg(),
+		OrderId:    &order.Id,
+		EmployeeId: tender.EmployeeId,
+	}
+	return moveGiftCard(ctx, q, &t)
+}
+
+// creditStoreCredit puts a refund on the store credit card the refund names, or on a
+// new store credit card for the order's customer.
+func creditStoreCredit(ctx context.Context, q queryer, order *Order, refund *Refund) error {
+	var card *GiftCard
+	var err error
+	if refund.GiftCardCode != "" {
+		card, err = lockGiftCard(ctx, q, refund.GiftCardCode)
+		if err != nil {
+			return err
+		}
+		if card.Status != GiftCardActive {
+			return fmt.Errorf("%w: %s", ErrGiftCardInactive, MaskGiftCardCode(card.Code))
+		}
+	} else {
+		now := time.Now()
+		card = &GiftCard{
+			Type:        GiftCardTypeStoreCredit,
+			Status:      GiftCardActive,
+			Amount:      refund.Amount,
+			Balance:     NewMoney(0),
+			CustomerId:  order.CustomerId,
+			ActivatedAt: &now,
+		}
+		if err := insertGiftCard(ctx, q, card); err != nil {
+			return err
+		}
+	}
+
+	refund.GiftCardId = &card.Id
+	refund.GiftCardCode = card.Code
+
+	t := GiftCardTransaction{
+		GiftCardId: card.Id,
+		Type:       GiftCardCredit,
+		Amount:     refund.Amount,
+		OrderId:    &order.Id,
+		EmployeeId: refund.EmployeeId,
+		Reason:     refund.Reason,
+	}
+	return moveGiftCard(ctx, q, &t)
+}
+
+// voidGiftCard takes the balance off a card and marks it void.
+func voidGiftCard(ctx context.Context, q queryer, card *GiftCard, orderId *int, employeeId int, reason string) error {
+	if card.Status == GiftCardVoid {
+		return nil
+	}
+
+	if card.Balance.IsPositive() {
+		t := GiftCardTransaction{
+			GiftCardId: card.Id,
+			Type:       GiftCardVoided,
+			Amount:     card.Balance.Neg(),
+			OrderId:    orderId,
+			EmployeeId: employeeId,
+			Reason:     reason,
+		}
+		if err := moveGiftCard(ctx, q, &t); err != nil {
+			return err
+		}
+		card.Balance = t.Balance
+	}
+
+	query := `
+		UPDATE gift_cards
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING updated_at
+	`
+	card.Status = GiftCardVoid
+	return q.QueryRowContext(ctx, query, GiftCardVoid, card.Id).Scan(&card.UpdatedAt)
+}
+
+// refundGiftCardLine voids a sold gift card that is refunded. Only cards whose whole
+// amount is still on them can be taken back.
+func refundGiftCardLine(ctx context.Context, q queryer, order *Order, line OrderProduct, refund *Refund) error {
+	card, err := getGiftCard(ctx, q, `id = $1`, *line.GiftCardId, true)
+	if err != nil {
+		return err
+	}
+
+	if card.Status == GiftCardActive && card.Balance.Cmp(card.Amount) < 0 {
+		return fmt.Errorf("%w: %s has %s of %s left", ErrGiftCardUsed, MaskGiftCardCode(card.Code), card.Balance, card.Amount)
+	}
+
+	return voidGiftCard(ctx, q, card, &order.Id, refund.EmployeeId, refund.Reason)
+}
+
+func generateGiftCardCode() (string, error) {
+	b := make([]byte, giftCardCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = giftCardAlphabet[int(b[i])%len(giftCardAlphabet)]
+	}
+	return string(b), nil
+}
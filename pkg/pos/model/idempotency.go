@@ -0,0 +1,184 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+)
+
+const (
+	// idempotencyKeyTTL is how long a response is replayed for its key; after that the
+	// key may be used for a new request.
+	idempotencyKeyTTL = 24 * time.Hour
+
+	// idempotencyLockTimeout is how long a key stays claimed by a request that never
+	// finished, e.g. because the server stopped while handling it.
+	idempotencyLockTimeout = 30 * time.Second
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a different request.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+
+	// ErrIdempotencyKeyInUse is returned while the first request with a key is still running.
+	ErrIdempotencyKeyInUse = errors.New("a request with this idempotency key is in progress")
+
+	// ErrIdempotencyResponseLost is returned when the first request with a key saved its
+	// change but its response was never stored.
+	ErrIdempotencyResponseLost = errors.New("the request with this idempotency key already ran but its response was not saved")
+)
+
+// IdempotencyKey identifies a request a client may retry. Keys are scoped to the
+// employee and station sending them; a zero id means none.
+type IdempotencyKey struct {
+	Key         string
+	EmployeeId  int
+	StationId   int
+	RequestHash []byte
+}
+
+// SavedResponse is the response stored for a key and replayed on retries.
+type SavedResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type IdempotencyModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(len(key) <= 255, "Idempotency-Key", "must not be more than 255 characters long")
+	for _, c := range key {
+		if c < 0x21 || c > 0x7e {
+			v.AddError("Idempotency-Key", "must only contain printable ASCII characters")
+			break
+		}
+	}
+}
+
+// Begin claims a key for a request. It returns nil when the request should run, and
+// the saved response when the same request already ran. A key sent with a different
+// request fails with ErrIdempotencyKeyReused, and one whose first request is still
+// running with ErrIdempotencyKeyInUse. Expired keys and keys abandoned by a request
+// that never finished are claimed anew, unless that request committed its change, in
+// which case it fails with ErrIdempotencyResponseLost.
+func (m IdempotencyModel) Begin(k *IdempotencyKey) (*SavedResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	query := `
+		INSERT INTO idempotency_keys (key, employee_id, station_id, request_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key, employee_id, station_id) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = NULL, content_type = '', body = NULL,
+			committed = false, created_at = EXCLUDED.created_at, completed_at = NULL
+		WHERE idempotency_keys.created_at < $6
+		OR (idempotency_keys.status IS NULL AND NOT idempotency_keys.committed AND idempotency_keys.created_at < $7)
+		RETURNING key
+	`
+	args := []interface{}{k.Key, k.EmployeeId, k.StationId, k.RequestHash, now, now.Add(-idempotencyKeyTTL), now.Add(-idempotencyLockTimeout)}
+
+	var claimed string
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `
+		SELECT request_hash, status, content_type, body, committed, created_at
+		FROM idempotency_keys
+		WHERE key = $1 AND employee_id = $2 AND station_id = $3
+	`
+	var hash []byte
+	var status sql.NullInt64
+	var saved SavedResponse
+	var committed bool
+	var createdAt time.Time
+	err = m.DB.QueryRowContext(ctx, query, k.Key, k.EmployeeId, k.StationId).Scan(&hash, &status, &saved.ContentType, &saved.Body, &committed, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// The first request failed and gave the key back in the meantime.
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return nil, err
+	}
+
+	if !bytes.Equal(hash, k.RequestHash) {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !status.Valid {
+		if committed && createdAt.Before(now.Add(-idempotencyLockTimeout)) {
+			return nil, ErrIdempotencyResponseLost
+		}
+		return nil, ErrIdempotencyKeyInUse
+	}
+
+	saved.Status = int(status.Int64)
+	return &saved, nil
+}
+
+// Complete stores the response of the request that claimed the key.
+func (m IdempotencyModel) Complete(k *IdempotencyKey, saved *SavedResponse) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status = $1, content_type = $2, body = $3, completed_at = CURRENT_TIMESTAMP
+		WHERE key = $4 AND employee_id = $5 AND station_id = $6 AND request_hash = $7
+	`
+	args := []interface{}{saved.Status, saved.ContentType, saved.Body, k.Key, k.EmployeeId, k.StationId, k.RequestHash}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Release gives back a key whose request failed, so a retry runs it again. A key
+// whose request committed its change is kept.
+func (m IdempotencyModel) Release(k *IdempotencyKey) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND employee_id = $2 AND station_id = $3 AND request_hash = $4
+		AND status IS NULL AND NOT committed
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, k.Key, k.EmployeeId, k.StationId, k.RequestHash)
+	return err
+}
+
+// commitIdempotencyKey marks the key k as committed inside the transaction of the
+// change its request makes, so the key is kept exactly when the change is saved. It
+// fails with ErrIdempotencyKeyInUse when the request no longer holds the key. A nil k
+// means the request carried no key.
+func commitIdempotencyKey(ctx context.Context, q queryer, k *IdempotencyKey) error {
+	if k == nil {
+		return nil
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET committed = true
+		WHERE key = $1 AND employee_id = $2 AND station_id = $3 AND request_hash = $4
+		AND status IS NULL AND NOT committed
+		RETURNING key
+	`
+	var committed string
+	err := q.QueryRowContext(ctx, query, k.Key, k.EmployeeId, k.StationId, k.RequestHash).Scan(&committed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrIdempotencyKeyInUse
+	}
+	return err
+}
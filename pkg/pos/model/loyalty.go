@@ -0,0 +1,419 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	LoyaltyRuleSpend    = "spend"
+	LoyaltyRuleProduct  = "product"
+	LoyaltyRuleCategory = "category"
+)
+
+var LoyaltyRuleTypes = []string{
+	LoyaltyRuleSpend,
+	LoyaltyRuleProduct,
+	LoyaltyRuleCategory,
+}
+
+const (
+	PointsEarn       = "earn"
+	PointsRedeem     = "redeem"
+	PointsRefund     = "refund"
+	PointsReversal   = "reversal"
+	PointsAdjustment = "adjustment"
+)
+
+// LoyaltyPointValue is what one point is worth when it is redeemed as a tender.
+var LoyaltyPointValue = NewMoney(minorUnits)
+
+var (
+	// ErrInsufficientPoints is returned when a customer redeems more points than they have.
+	ErrInsufficientPoints = errors.New("insufficient loyalty points")
+
+	// ErrNoCustomer is returned when points are redeemed or refunded on an order without a customer.
+	ErrNoCustomer = errors.New("order has no customer")
+
+	// ErrPointsRedeemed is returned when the customer of an order with redeemed points is changed.
+	ErrPointsRedeemed = errors.New("points are already redeemed on the order")
+)
+
+// LoyaltyRule awards points for a paid order with a customer. A spend rule gives
+// Points for every full SpendStep of the order total not paid with points; product
+// and category rules give Points per unit sold of ProductId (or its variants) or of
+// any product in the subtree of CategoryId. The points of all active rules add up.
+type LoyaltyRule struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Points     int        `json:"points"`
+	SpendStep  Money      `json:"spend_step"`
+	ProductId  *int       `json:"product_id"`
+	CategoryId *int       `json:"category_id"`
+	Active     bool       `json:"active"`
+	StartsAt   *time.Time `json:"starts_at"`
+	EndsAt     *time.Time `json:"ends_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// LoyaltyTransaction is an entry of the append-only points ledger. Points is the
+// signed change of the customer's balance and Balance the balance right after it.
+type LoyaltyTransaction struct {
+	Id         int64     `json:"id"`
+	CustomerId int       `json:"customer_id"`
+	OrderId    *int      `json:"order_id"`
+	Type       string    `json:"type"`
+	Points     int       `json:"points"`
+	Balance    int       `json:"balance"`
+	EmployeeId int       `json:"employee_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type LoyaltyModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateLoyaltyRule(v *validator.Validator, r *LoyaltyRule) {
+	v.Check(r.Name != "", "name", "must be provided")
+	v.Check(validator.In(r.Type, LoyaltyRuleTypes...), "type", "must be spend, product or category")
+	v.Check(r.Points > 0, "points", "must be greater than zero")
+	v.Check(r.StartsAt == nil || r.EndsAt == nil || r.EndsAt.After(*r.StartsAt), "ends_at", "must be after starts_at")
+
+	switch r.Type {
+	case LoyaltyRuleSpend:
+		v.Check(r.SpendStep.IsPositive(), "spend_step", "must be greater than zero")
+	case LoyaltyRuleProduct:
+		v.Check(r.ProductId != nil, "product_id", "must be provided")
+	case LoyaltyRuleCategory:
+		v.Check(r.CategoryId != nil, "category_id", "must be provided")
+	}
+}
+
+func ValidatePointsAdjustment(v *validator.Validator, t *LoyaltyTransaction) {
+	v.Check(t.Points != 0, "points", "must not be zero")
+	v.Check(t.Reason != "", "reason", "must be provided")
+}
+
+// PointsFor returns how many points pay for amount, and whether amount is a whole
+// number of points.
+func PointsFor(amount Money) (int, bool) {
+	if !LoyaltyPointValue.IsPositive() {
+		return 0, false
+	}
+	return int(amount.Amount / LoyaltyPointValue.Amount), amount.Amount%LoyaltyPointValue.Amount == 0
+}
+
+func (m LoyaltyModel) CreateRule(r *LoyaltyRule) error {
+	query := `
+		INSERT INTO loyalty_rules (name, type, points, spend_step, product_id, category_id, active, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at, updated_at
+	`
+	args := []interface{}{r.Name, r.Type, r.Points, r.SpendStep, r.ProductId, r.CategoryId, r.Active, r.StartsAt, r.EndsAt}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&r.Id, &r.CreatedAt, &r.UpdatedAt)
+}
+
+func (m LoyaltyModel) GetRule(id int) (*LoyaltyRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rules, err := getLoyaltyRules(ctx, m.DB, `WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return &rules[0], nil
+}
+
+func (m LoyaltyModel) GetRules() ([]LoyaltyRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getLoyaltyRules(ctx, m.DB, `ORDER BY id`)
+}
+
+func (m LoyaltyModel) UpdateRule(id int, r *LoyaltyRule) error {
+	query := `
+		UPDATE loyalty_rules
+		SET name = $1, type = $2, points = $3, spend_step = $4, product_id = $5, category_id = $6, active = $7,
+			starts_at = $8, ends_at = $9, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $10
+		RETURNING created_at, updated_at
+	`
+	args := []interface{}{r.Name, r.Type, r.Points, r.SpendStep, r.ProductId, r.CategoryId, r.Active, r.StartsAt, r.EndsAt, id}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&r.CreatedAt, &r.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	r.Id = id
+	return err
+}
+
+func (m LoyaltyModel) DeleteRule(id int) error {
+	query := `
+		DELETE FROM loyalty_rules
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Transactions returns a page of a customer's points ledger, newest first.
+func (m LoyaltyModel) Transactions(customerId int, filters Filters) ([]LoyaltyTransaction, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, customer_id, order_id, type, points, balance, COALESCE(employee_id, 0), reason, created_at
+		FROM loyalty_transactions
+		WHERE customer_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, customerId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	transactions := []LoyaltyTransaction{}
+	for rows.Next() {
+		var t LoyaltyTransaction
+		err := rows.Scan(&totalRecords, &t.Id, &t.CustomerId, &t.OrderId, &t.Type, &t.Points, &t.Balance,
+			&t.EmployeeId, &t.Reason, &t.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		transactions = append(transactions, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return transactions, metadata, nil
+}
+
+// Adjust books a manual correction of a customer's points.
+func (m LoyaltyModel) Adjust(customerId int, t *LoyaltyTransaction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	t.CustomerId = customerId
+	t.Type = PointsAdjustment
+	t.OrderId = nil
+	return movePoints(ctx, m.DB, t)
+}
+
+func getLoyaltyRules(ctx context.Context, q queryer, where string, args ...interface{}) ([]LoyaltyRule, error) {
+	query := `
+		SELECT id, name, type, points, spend_step, product_id, category_id, active, starts_at, ends_at, created_at, updated_at
+		FROM loyalty_rules
+	` + where
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []LoyaltyRule{}
+	for rows.Next() {
+		var r LoyaltyRule
+		err := rows.Scan(&r.Id, &r.Name, &r.Type, &r.Points, &r.SpendStep, &r.ProductId, &r.CategoryId, &r.Active,
+			&r.StartsAt, &r.EndsAt, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// movePoints is the only way a customer's points balance changes: it applies the
+// transaction to customers.points and appends it to the ledger with the new balance.
+// A transaction that would take the balance below zero fails with
+// ErrInsufficientPoints.
+func movePoints(ctx context.Context, q queryer, t *LoyaltyTransaction) error {
+	query := `
+		UPDATE customers
+		SET points = points + $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND points + $1 >= 0
+		RETURNING points
+	`
+	err := q.QueryRowContext(ctx, query, t.Points, t.CustomerId).Scan(&t.Balance)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var points int
+		err := q.QueryRowContext(ctx, `SELECT points FROM customers WHERE id = $1`, t.CustomerId).Scan(&points)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: customer %d has %d points, %d requested", ErrInsufficientPoints, t.CustomerId, points, -t.Points)
+	}
+
+	query = `
+		INSERT INTO loyalty_transactions (customer_id, order_id, type, points, balance, employee_id, reason)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7)
+		RETURNING id, created_at
+	`
+	args := []interface{}{t.CustomerId, t.OrderId, t.Type, t.Points, t.Balance, t.EmployeeId, t.Reason}
+	return q.QueryRowContext(ctx, query, args...).Scan(&t.Id, &t.CreatedAt)
+}
+
+// earnPoints credits the customer of a just paid order with the points of the rules
+// active right now. pointsPaid is the part of the total paid with points, which earns
+// nothing under spend rules. It returns the points earned.
+func earnPoints(ctx context.Context, q queryer, order *Order, pointsPaid Money, employeeId int) (int, error) {
+	rules, err := getLoyaltyRules(ctx, q, `
+		WHERE active
+		AND (starts_at IS NULL OR starts_at <= CURRENT_TIMESTAMP)
+		AND (ends_at IS NULL OR ends_at > CURRENT_TIMESTAMP)
+		ORDER BY id
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	points := 0
+	base := order.TotalPrice.Sub(pointsPaid)
+	lineRules := []int{}
+	for _, r := range rules {
+		switch r.Type {
+		case LoyaltyRuleSpend:
+			if r.SpendStep.IsPositive() && base.IsPositive() {
+				points += int(base.Amount/r.SpendStep.Amount) * r.Points
+			}
+		default:
+			lineRules = append(lineRules, r.Id)
+		}
+	}
+
+	if len(lineRules) > 0 {
+		query := `
+			WITH RECURSIVE rule_categories AS (
+				SELECT id AS rule_id, category_id FROM loyalty_rules WHERE id = ANY($2) AND type = 'category'
+				UNION ALL
+				SELECT rc.rule_id, c.id FROM categories c INNER JOIN rule_categories rc ON c.parent_id = rc.category_id
+			)
+			SELECT COALESCE(SUM(op.qty * r.points), 0)
+			FROM order_product op
+			INNER JOIN products p ON p.id = op.product_id
+			INNER JOIN loyalty_rules r ON r.id = ANY($2)
+			WHERE op.order_id = $1
+			AND (
+				(r.type = 'product' AND r.product_id IN (p.id, p.parent_id))
+				OR (r.type = 'category' AND EXISTS (
+					SELECT 1 FROM rule_categories rc WHERE rc.rule_id = r.id AND rc.category_id = p.category_id
+				))
+			)
+		`
+		var linePoints int
+		if err := q.QueryRowContext(ctx, query, order.Id, pq.Array(lineRules)).Scan(&linePoints); err != nil {
+			return 0, err
+		}
+		points += linePoints
+	}
+
+	if points <= 0 {
+		return 0, nil
+	}
+
+	t := LoyaltyTransaction{
+		CustomerId: *order.CustomerId,
+		OrderId:    &order.Id,
+		Type:       PointsEarn,
+		Points:     points,
+		EmployeeId: employeeId,
+	}
+	return points, movePoints(ctx, q, &t)
+}
+
+// reverseEarnedPoints takes back the share of the points an order earned that
+// corresponds to what has been refunded so far, refunded out of collected. Points the
+// customer has already spent are not taken below zero.
+func reverseEarnedPoints(ctx context.Context, q queryer, order *Order, refunded, collected Money, employeeId int) error {
+	if order.CustomerId == nil || !collected.IsPositive() {
+		return nil
+	}
+
+	query := `
+		SELECT COALESCE(SUM(points) FILTER (WHERE type = 'earn'), 0), COALESCE(-SUM(points) FILTER (WHERE type = 'reversal'), 0)
+		FROM loyalty_transactions
+		WHERE order_id = $1 AND customer_id = $2
+	`
+	var earned, reversed int
+	if err := q.QueryRowContext(ctx, query, order.Id, *order.CustomerId).Scan(&earned, &reversed); err != nil {
+		return err
+	}
+
+	if refunded.Cmp(collected) > 0 {
+		refunded = collected
+	}
+	due := int(int64(earned)*refunded.Amount/collected.Amount) - reversed
+	if due <= 0 {
+		return nil
+	}
+
+	var balance int
+	err := q.QueryRowContext(ctx, `SELECT points FROM customers WHERE id = $1 FOR UPDATE`, *order.CustomerId).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if due > balance {
+		due = balance
+	}
+	if due == 0 {
+		return nil
+	}
+
+	t := LoyaltyTransaction{
+		CustomerId: *order.CustomerId,
+		OrderId:    &order.Id,
+		Type:       PointsReversal,
+		Points:     -due,
+		EmployeeId: employeeId,
+		Reason:     "order refunded",
+	}
+	return movePoints(ctx, q, &t)
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log"
@@ -13,6 +14,9 @@ var (
 
 	// ErrEditConflict is returned when a there is a data race, and we have an edit conflict.
 	ErrEditConflict = errors.New("edit conflict")
+
+	// ErrOutOfStock is returned when a sale would take a product's amount below zero.
+	ErrOutOfStock = errors.New("out of stock")
 )
 
 type Models struct {
@@ -22,6 +26,22 @@ type Models struct {
 	Order       OrderModule
 	Tokens      TokenModel
 	Permissions PermissionModel
+	Payments    PaymentModel
+	Refunds     RefundModel
+	Shifts      ShiftModel
+	Reports     ReportModel
+	Promotions  PromotionModel
+	Stock       StockModel
+	Suppliers   SupplierModel
+	Purchasing  PurchaseOrderModel
+	Modifiers   ModifierModel
+	Customers   CustomerModel
+	Loyalty     LoyaltyModel
+	GiftCards   GiftCardModel
+	Stations    StationModel
+	Sync        SyncModel
+	Idempotency IdempotencyModel
+	Audit       AuditModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -58,5 +78,99 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		Payments: PaymentModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Refunds: RefundModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Shifts: ShiftModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Reports: ReportModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Promotions: PromotionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Stock: StockModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Suppliers: SupplierModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Purchasing: PurchaseOrderModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Modifiers: ModifierModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Customers: CustomerModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Loyalty: LoyaltyModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		GiftCards: GiftCardModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Stations: StationModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Sync: SyncModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Idempotency: IdempotencyModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Audit: AuditModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+	}
+}
+
+// editConflict tells a failed versioned update of a row of table apart: it returns
+// ErrRecordNotFound when the row is gone, and otherwise ErrEditConflict with version
+// set to the row's current version.
+func editConflict(ctx context.Context, q queryer, table string, id int, version *int) error {
+	err := q.QueryRowContext(ctx, `SELECT version FROM `+table+` WHERE id = $1`, id).Scan(version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
 	}
+	return ErrEditConflict
 }
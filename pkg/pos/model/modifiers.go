@@ -0,0 +1,400 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrInvalidModifiers is returned when the modifiers chosen for an order line do not
+// belong to the product or break the min/max selections of a group.
+var ErrInvalidModifiers = errors.New("invalid modifiers")
+
+// ModifierGroup is a set of add-ons such as "Milk" or "Syrup" that can be attached to
+// products. An order line of such a product picks between MinSelect and MaxSelect
+// modifiers of the group.
+type ModifierGroup struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	MinSelect int        `json:"min_select"`
+	MaxSelect int        `json:"max_select"`
+	Modifiers []Modifier `json:"modifiers"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Modifier is a single add-on whose PriceDelta is added to the unit price of the line
+// it is chosen for. The delta may be negative, e.g. for "no cheese".
+type Modifier struct {
+	Id         int    `json:"id"`
+	GroupId    int    `json:"group_id"`
+	Name       string `json:"name"`
+	PriceDelta Money  `json:"price_delta"`
+}
+
+// OrderProductModifier is a modifier chosen for an order line. The client only sends
+// ModifierId; the group, name and price delta are copied from the modifier so the
+// line keeps them if the modifier later changes.
+type OrderProductModifier struct {
+	Id             int    `json:"id"`
+	OrderProductId int    `json:"order_product_id"`
+	ModifierId     int    `json:"modifier_id"`
+	GroupId        int    `json:"group_id"`
+	Name           string `json:"name"`
+	PriceDelta     Money  `json:"price_delta"`
+}
+
+type ModifierModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateModifierGroup(v *validator.Validator, g *ModifierGroup) {
+	v.Check(g.Name != "", "name", "must be provided")
+	v.Check(len(g.Modifiers) > 0, "modifiers", "must contain at least one modifier")
+	v.Check(g.MinSelect >= 0, "min_select", "must not be negative")
+	v.Check(g.MaxSelect >= 1, "max_select", "must be at least 1")
+	v.Check(g.MaxSelect >= g.MinSelect, "max_select", "must not be less than min_select")
+	v.Check(g.MinSelect <= len(g.Modifiers), "min_select", "must not exceed the number of modifiers")
+	for i, m := range g.Modifiers {
+		v.Check(m.Name != "", fmt.Sprintf("modifiers[%d].name", i), "must be provided")
+	}
+}
+
+func (m ModifierModel) Create(g *ModifierGroup) error {
+	query := `
+		INSERT INTO modifier_groups (name, min_select, max_select)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, g.Name, g.MinSelect, g.MaxSelect).Scan(&g.Id, &g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range g.Modifiers {
+		g.Modifiers[i].Id = 0
+		if err := saveModifier(ctx, tx, g.Id, &g.Modifiers[i]); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m ModifierModel) Get(id int) (*ModifierGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	groups, err := getModifierGroups(ctx, m.DB, `WHERE g.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return &groups[0], nil
+}
+
+func (m ModifierModel) GetAll() ([]ModifierGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getModifierGroups(ctx, m.DB, ``)
+}
+
+// Update replaces the group and its modifiers. Modifiers sent with an id are updated,
+// those without one are added and the ones left out are removed; order lines keep
+// their copies of removed modifiers.
+func (m ModifierModel) Update(id int, g *ModifierGroup) error {
+	query := `
+		UPDATE modifier_groups
+		SET name = $1, min_select = $2, max_select = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+		RETURNING created_at, updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, g.Name, g.MinSelect, g.MaxSelect, id).Scan(&g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	g.Id = id
+
+	keep := []int{}
+	for _, mod := range g.Modifiers {
+		if mod.Id > 0 {
+			keep = append(keep, mod.Id)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM modifiers WHERE group_id = $1 AND NOT (id = ANY($2))`, id, pq.Array(keep))
+	if err != nil {
+		return err
+	}
+
+	for i := range g.Modifiers {
+		if err := saveModifier(ctx, tx, id, &g.Modifiers[i]); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m ModifierModel) Delete(id int) error {
+	query := `
+		DELETE FROM modifier_groups
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// GetForProduct returns the modifier groups attached to a product.
+func (m ModifierModel) GetForProduct(productId int) ([]ModifierGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return productModifierGroups(ctx, m.DB, productId)
+}
+
+// SetForProduct replaces the modifier groups attached to a product.
+func (m ModifierModel) SetForProduct(productId int, groupIds []int) ([]ModifierGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`, productId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_modifier_groups WHERE product_id = $1`, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO product_modifier_groups (product_id, group_id)
+		SELECT $1, unnest($2::INT[])
+		ON CONFLICT DO NOTHING
+	`
+	_, err = tx.ExecContext(ctx, query, productId, pq.Array(groupIds))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Constraint == "product_modifier_groups_group_id_fkey" {
+			return nil, fmt.Errorf("%w: unknown modifier group", ErrRecordNotFound)
+		}
+		return nil, err
+	}
+
+	groups, err := productModifierGroups(ctx, tx, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, tx.Commit()
+}
+
+func saveModifier(ctx context.Context, q queryer, groupId int, mod *Modifier) error {
+	mod.GroupId = groupId
+	if mod.Id == 0 {
+		query := `
+			INSERT INTO modifiers (group_id, name, price_delta)
+			VALUES ($1, $2, $3)
+			RETURNING id
+		`
+		return q.QueryRowContext(ctx, query, groupId, mod.Name, mod.PriceDelta).Scan(&mod.Id)
+	}
+
+	query := `
+		UPDATE modifiers
+		SET name = $1, price_delta = $2
+		WHERE id = $3 AND group_id = $4
+		RETURNING id
+	`
+	err := q.QueryRowContext(ctx, query, mod.Name, mod.PriceDelta, mod.Id, groupId).Scan(&mod.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: modifier %d is not part of group %d", ErrRecordNotFound, mod.Id, groupId)
+	}
+	return err
+}
+
+// getModifierGroups loads the groups matching where together with their modifiers.
+func getModifierGroups(ctx context.Context, q queryer, where string, args ...interface{}) ([]ModifierGroup, error) {
+	query := `
+		SELECT g.id, g.name, g.min_select, g.max_select, g.created_at, g.updated_at,
+			m.id, m.name, m.price_delta
+		FROM modifier_groups g
+		LEFT JOIN modifiers m ON m.group_id = g.id
+	` + where + `
+		ORDER BY g.name, g.id, m.id
+	`
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []ModifierGroup{}
+	for rows.Next() {
+		var g ModifierGroup
+		var modId sql.NullInt64
+		var modName sql.NullString
+		var delta Money
+		err := rows.Scan(&g.Id, &g.Name, &g.MinSelect, &g.MaxSelect, &g.CreatedAt, &g.UpdatedAt,
+			&modId, &modName, &delta)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(groups); n == 0 || groups[n-1].Id != g.Id {
+			g.Modifiers = []Modifier{}
+			groups = append(groups, g)
+		}
+		if modId.Valid {
+			last := &groups[len(groups)-1]
+			last.Modifiers = append(last.Modifiers, Modifier{
+				Id:         int(modId.Int64),
+				GroupId:    g.Id,
+				Name:       modName.String,
+				PriceDelta: delta,
+			})
+		}
+	}
+
+	return groups, rows.Err()
+}
+
+func productModifierGroups(ctx context.Context, q queryer, productId int) ([]ModifierGroup, error) {
+	return getModifierGroups(ctx, q, `WHERE g.id IN (SELECT group_id FROM product_modifier_groups WHERE product_id = $1)`, productId)
+}
+
+// resolveLineModifiers checks the modifiers chosen for a line against the groups
+// attached to its product, fills in their names and price deltas and returns the sum
+// of the deltas.
+func resolveLineModifiers(ctx context.Context, q queryer, line *OrderProduct) (Money, error) {
+	groups, err := productModifierGroups(ctx, q, line.ProductId)
+	if err != nil {
+		return Money{}, err
+	}
+
+	available := make(map[int]Modifier)
+	for _, g := range groups {
+		for _, mod := range g.Modifiers {
+			available[mod.Id] = mod
+		}
+	}
+
+	var delta Money
+	chosen := make(map[int]int)
+	seen := make(map[int]bool)
+	for i := range line.Modifiers {
+		lm := &line.Modifiers[i]
+		mod, ok := available[lm.ModifierId]
+		if !ok {
+			return Money{}, fmt.Errorf("%w: modifier %d is not available for product %d", ErrInvalidModifiers, lm.ModifierId, line.ProductId)
+		}
+		if seen[mod.Id] {
+			return Money{}, fmt.Errorf("%w: modifier %d chosen twice", ErrInvalidModifiers, mod.Id)
+		}
+		seen[mod.Id] = true
+
+		lm.GroupId = mod.GroupId
+		lm.Name = mod.Name
+		lm.PriceDelta = mod.PriceDelta
+		chosen[mod.GroupId]++
+		delta = delta.Add(mod.PriceDelta)
+	}
+
+	for _, g := range groups {
+		if n := chosen[g.Id]; n < g.MinSelect || n > g.MaxSelect {
+			return Money{}, fmt.Errorf("%w: %q takes %d to %d, %d chosen", ErrInvalidModifiers, g.Name, g.MinSelect, g.MaxSelect, n)
+		}
+	}
+
+	return delta, nil
+}
+
+func insertOrderProductModifiers(ctx context.Context, q queryer, line *OrderProduct) error {
+	query := `
+		INSERT INTO order_product_modifiers (order_product_id, modifier_id, group_id, name, price_delta)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5)
+		RETURNING id
+	`
+	for i := range line.Modifiers {
+		lm := &line.Modifiers[i]
+		lm.OrderProductId = line.Id
+
+		err := q.QueryRowContext(ctx, query, lm.OrderProductId, lm.ModifierId, lm.GroupId, lm.Name, lm.PriceDelta).Scan(&lm.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getOrderProductModifiers loads the modifiers of the given lines grouped by line id.
+func getOrderProductModifiers(ctx context.Context, q queryer, lineIds []int) (map[int][]OrderProductModifier, error) {
+	query := `
+		SELECT id, order_product_id, COALESCE(modifier_id, 0), COALESCE(group_id, 0), name, price_delta
+		FROM order_product_modifiers
+		WHERE order_product_id = ANY($1)
+		ORDER BY order_product_id, id
+	`
+	rows, err := q.QueryContext(ctx, query, pq.Array(lineIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	modifiers := make(map[int][]OrderProductModifier)
+	for rows.Next() {
+		var lm OrderProductModifier
+		if err := rows.Scan(&lm.Id, &lm.OrderProductId, &lm.ModifierId, &lm.GroupId, &lm.Name, &lm.PriceDelta); err != nil {
+			return nil, err
+		}
+		modifiers[lm.OrderProductId] = append(modifiers[lm.OrderProductId], lm)
+	}
+
+	return modifiers, rows.Err()
+}
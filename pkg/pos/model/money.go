@@ -0,0 +1,248 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the ISO 4217 code of the currency the store works in. Amounts
+// are stored in minor units without a currency, which is stamped on them as they are
+// created or read.
+const DefaultCurrency = "KZT"
+
+// minorUnits is the number of minor units in a major unit.
+const minorUnits = 100
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// Money is an amount in integer minor units (cents, tiyn) of a currency. Arithmetic
+// stays in integers; only Scale rounds, to the nearest minor unit with halves away
+// from zero. In JSON it is a decimal number in major units, in SQL a BIGINT of minor
+// units.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney returns minor units of the default currency.
+func NewMoney(minor int64) Money {
+	return Money{Amount: minor, Currency: DefaultCurrency}
+}
+
+// MoneyFromMajor converts a major-unit amount such as 12.5 to Money, rounding to the
+// nearest minor unit. Amounts whose minor units do not fit in an int64 fail with
+// ErrInvalidMoney.
+func MoneyFromMajor(v float64) (Money, error) {
+	minor, err := roundMinor(v * minorUnits)
+	if err != nil {
+		return Money{}, err
+	}
+	return NewMoney(minor), nil
+}
+
+// roundMinor rounds f to the nearest whole minor unit, halves away from zero. NaN,
+// infinities and values outside the int64 range fail with ErrInvalidMoney.
+func roundMinor(f float64) (int64, error) {
+	f = math.Round(f)
+	// -2^63 is exactly representable and in range; 2^63 is the first value above it.
+	if math.IsNaN(f) || f < math.MinInt64 || f >= -math.MinInt64 {
+		return 0, fmt.Errorf("%w: %g minor units is out of range", ErrInvalidMoney, f)
+	}
+	return int64(f), nil
+}
+
+// ParseMoney parses a decimal amount in major units ("12", "-3.5", "0.125") without
+// going through float64. Digits past the minor unit are rounded half away from zero.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+		}
+		return MoneyFromMajor(f)
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if whole == "" && frac == "" {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+
+	roundUp := len(frac) > 2 && frac[2] >= '5'
+	frac = (frac + "00")[:2]
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	if roundUp {
+		minor++
+	}
+	if negative {
+		minor = -minor
+	}
+
+	return NewMoney(minor), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) Add(o Money) Money {
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currencyWith(o)}
+}
+
+func (m Money) Sub(o Money) Money {
+	return Money{Amount: m.Amount - o.Amount, Currency: m.currencyWith(o)}
+}
+
+// Times multiplies the amount by a quantity.
+func (m Money) Times(n int) Money {
+	return Money{Amount: m.Amount * int64(n), Currency: m.Currency}
+}
+
+// Scale multiplies the amount by a factor such as a rate or a proportion and rounds
+// the result to the nearest minor unit, halves away from zero.
+func (m Money) Scale(f float64) Money {
+	return Money{Amount: int64(math.Round(float64(m.Amount) * f)), Currency: m.Currency}
+}
+
+// Percent returns pct percent of the amount, rounded like Scale.
+func (m Money) Percent(pct float64) Money {
+	return m.Scale(pct / 100)
+}
+
+// Share returns the part of m that corresponds to part out of whole, rounded like
+// Scale. A zero whole yields zero.
+func (m Money) Share(part, whole Money) Money {
+	if whole.Amount == 0 {
+		return Money{Currency: m.Currency}
+	}
+	return m.Scale(float64(part.Amount) / float64(whole.Amount))
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Min returns the smaller of the two amounts.
+func (m Money) Min(o Money) Money {
+	if o.Amount < m.Amount {
+		return Money{Amount: o.Amount, Currency: m.currencyWith(o)}
+	}
+	return Money{Amount: m.Amount, Currency: m.currencyWith(o)}
+}
+
+// Cmp returns -1, 0 or +1 as m is less than, equal to or greater than o.
+func (m Money) Cmp(o Money) int {
+	m.currencyWith(o)
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// String formats the amount in major units with two decimals, without the currency.
+func (m Money) String() string {
+	sign, minor := "", m.Amount
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/minorUnits, minor%minorUnits)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a numeric string in major units.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	parsed, err := ParseMoney(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
+
+// Scan reads minor units. Aggregates come back from PostgreSQL as NUMERIC, so
+// fractional values are rounded to the nearest minor unit.
+func (m *Money) Scan(src interface{}) error {
+	var minor int64
+	switch v := src.(type) {
+	case nil:
+	case int64:
+		minor = v
+	case float64:
+		var err error
+		if minor, err = roundMinor(v); err != nil {
+			return err
+		}
+	case []byte:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidMoney, v)
+		}
+		if minor, err = roundMinor(f); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidMoney, src)
+	}
+
+	*m = NewMoney(minor)
+	return nil
+}
+
+// currencyWith returns the currency of an operation on m and o. A zero Money has no
+// currency yet and takes the other's; mixing two currencies is a programming error.
+func (m Money) currencyWith(o Money) string {
+	switch {
+	case m.Currency == "":
+		return o.Currency
+	case o.Currency == "" || o.Currency == m.Currency:
+		return m.Currency
+	}
+	panic("money: " + m.Currency + " mixed with " + o.Currency)
+}
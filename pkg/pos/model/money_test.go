@@ -0,0 +1,242 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"12", 1200, false},
+		{"12.5", 1250, false},
+		{"12.50", 1250, false},
+		{" 0.01 ", 1, false},
+		{".5", 50, false},
+		{"7.", 700, false},
+		{"-3.5", -350, false},
+		{"0.125", 13, false},
+		{"0.124", 12, false},
+		{"-0.125", -13, false},
+		{"0.999", 100, false},
+		{"1.5e2", 15000, false},
+		{"1E-2", 1, false},
+		{"", 0, true},
+		{"-", 0, true},
+		{".", 0, true},
+		{"1,50", 0, true},
+		{"12.5.0", 0, true},
+		{"+1", 0, true},
+		{"abc", 0, true},
+		{"1e", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("ParseMoney(%q) error = %v, want %v", tt.in, err, ErrInvalidMoney)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", tt.in, err)
+			continue
+		}
+		if got.Amount != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		minor int64
+		want  string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1250, "12.50"},
+		{-5, "-0.05"},
+		{-1250, "-12.50"},
+	}
+
+	for _, tt := range tests {
+		if got := NewMoney(tt.minor).String(); got != tt.want {
+			t.Errorf("NewMoney(%d).String() = %q, want %q", tt.minor, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	m := NewMoney(1000)
+
+	tests := []struct {
+		name string
+		got  Money
+		want int64
+	}{
+		{"Add", m.Add(NewMoney(255)), 1255},
+		{"Sub", m.Sub(NewMoney(1255)), -255},
+		{"Times", NewMoney(333).Times(3), 999},
+		{"Neg", m.Neg(), -1000},
+		{"Min", m.Min(NewMoney(999)), 999},
+		{"Min of smaller", m.Min(NewMoney(1001)), 1000},
+		{"Scale rounds half up", NewMoney(5).Scale(0.5), 3},
+		{"Scale rounds half away from zero", NewMoney(-5).Scale(0.5), -3},
+		{"Percent", NewMoney(999).Percent(10), 100},
+		{"Percent of 12%", NewMoney(1250).Percent(12), 150},
+		{"Share", NewMoney(100).Share(NewMoney(1), NewMoney(3)), 33},
+		{"Share of zero whole", NewMoney(100).Share(NewMoney(1), Money{}), 0},
+	}
+
+	for _, tt := range tests {
+		if tt.got.Amount != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got.Amount, tt.want)
+		}
+	}
+}
+
+func TestMoneyFromMajor(t *testing.T) {
+	tests := []struct {
+		in      float64
+		want    int64
+		wantErr bool
+	}{
+		{0.1 + 0.2, 30, false},
+		{-12.345, -1235, false},
+		{9e16, 9e18, false},
+		{-92233720368547758.08, math.MinInt64, false},
+		{92233720368547758.08, 0, true},
+		{1e300, 0, true},
+		{-1e300, 0, true},
+		{math.Inf(1), 0, true},
+		{math.NaN(), 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := MoneyFromMajor(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("MoneyFromMajor(%g) error = %v, want %v", tt.in, err, ErrInvalidMoney)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("MoneyFromMajor(%g): %v", tt.in, err)
+			continue
+		}
+		if got.Amount != tt.want || got.Currency != DefaultCurrency {
+			t.Errorf("MoneyFromMajor(%g) = %d %s, want %d %s", tt.in, got.Amount, got.Currency, tt.want, DefaultCurrency)
+		}
+	}
+}
+
+func TestMoneyCurrency(t *testing.T) {
+	if got := (Money{}).Add(NewMoney(1)).Currency; got != DefaultCurrency {
+		t.Errorf("zero Money plus %s = %q, want %q", DefaultCurrency, got, DefaultCurrency)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("adding USD to KZT did not panic")
+		}
+	}()
+	NewMoney(1).Add(Money{Amount: 1, Currency: "USD"})
+}
+
+func TestMoneyCmp(t *testing.T) {
+	tests := []struct {
+		a, b int64
+		want int
+	}{
+		{1, 2, -1},
+		{2, 2, 0},
+		{3, 2, 1},
+		{-1, 0, -1},
+	}
+
+	for _, tt := range tests {
+		if got := NewMoney(tt.a).Cmp(NewMoney(tt.b)); got != tt.want {
+			t.Errorf("Cmp(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	if !NewMoney(0).IsZero() || NewMoney(1).IsZero() {
+		t.Error("IsZero is wrong")
+	}
+	if !NewMoney(-1).IsNegative() || NewMoney(0).IsNegative() {
+		t.Error("IsNegative is wrong")
+	}
+	if !NewMoney(1).IsPositive() || NewMoney(0).IsPositive() {
+		t.Error("IsPositive is wrong")
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var v struct {
+		Price Money  `json:"price"`
+		Total Money  `json:"total"`
+		Null  Money  `json:"null"`
+		Note  string `json:"note"`
+	}
+	err := json.Unmarshal([]byte(`{"price": 12.5, "total": "-0.99", "null": null, "note": "x"}`), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Price.Amount != 1250 || v.Total.Amount != -99 || v.Null.Amount != 0 {
+		t.Errorf("unmarshalled %d, %d, %d, want 1250, -99, 0", v.Price.Amount, v.Total.Amount, v.Null.Amount)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"price":12.50,"total":-0.99,"null":0.00,"note":"x"}`; string(data) != want {
+		t.Errorf("marshalled %s, want %s", data, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"price": "twelve"}`), &v); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("unmarshalling a bad amount error = %v, want %v", err, ErrInvalidMoney)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src     interface{}
+		want    int64
+		wantErr bool
+	}{
+		{nil, 0, false},
+		{int64(1250), 1250, false},
+		{float64(12.5), 13, false},
+		{[]byte("1249.5"), 1250, false},
+		{[]byte("abc"), 0, true},
+		{[]byte("1e19"), 0, true},
+		{"1250", 0, true},
+	}
+
+	for _, tt := range tests {
+		var m Money
+		err := m.Scan(tt.src)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("Scan(%#v) error = %v, want %v", tt.src, err, ErrInvalidMoney)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Scan(%#v): %v", tt.src, err)
+			continue
+		}
+		if m.Amount != tt.want {
+			t.Errorf("Scan(%#v) = %d, want %d", tt.src, m.Amount, tt.want)
+		}
+	}
+}
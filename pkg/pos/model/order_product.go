@@ -1,17 +1,139 @@
 package model
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"pos-rs/pkg/pos/validator"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type OrderProduct struct {
-	Id                int    `json:"id"`
-	OrderId           string    `json:"order_id"`
-	ProductId         string    `json:"product_id"`
-	Qty               int       `json:"qty"`
-	Price             int       `json:"price"`
-	TotalNormalPrice  int       `json:"total_normal_price"`
-	Product           Product 	`json:"product"`
-	CreatedAt         time.Time `json:"created_at"`
-	UpdatedAt         time.Time `json:"updated_at"`
+	Id               int                    `json:"id"`
+	OrderId          int                    `json:"order_id"`
+	ProductId        int                    `json:"product_id"`
+	Qty              int                    `json:"qty"`
+	Price            Money                  `json:"price"`
+	TotalNormalPrice Money                  `json:"total_normal_price"`
+	ManualDiscount   Money                  `json:"manual_discount"`
+	Discount         Money                  `json:"discount"`
+	PromotionId      *int                   `json:"promotion_id"`
+	TaxRate          float64                `json:"tax_rate"`
+	Tax              Money                  `json:"tax"`
+	Modifiers        []OrderProductModifier `json:"modifiers"`
+	GiftCardId       *int                   `json:"gift_card_id"`
+	GiftCardCode     string                 `json:"gift_card_code,omitempty"`
+	Product          Product                `json:"product"`
+	CreatedAt        time.Time              `json:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at"`
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx, so the line helpers can run
+// inside or outside a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func ValidateOrderProducts(v *validator.Validator, products []OrderProduct) {
+	v.Check(len(products) > 0, "products", "must contain at least one product")
+	for i, p := range products {
+		v.Check(p.ProductId > 0, fmt.Sprintf("products[%d].product_id", i), "must be provided")
+		v.Check(p.Qty > 0, fmt.Sprintf("products[%d].qty", i), "must be greater than zero")
+		v.Check(!p.ManualDiscount.IsNegative(), fmt.Sprintf("products[%d].manual_discount", i), "must not be negative")
+		if p.GiftCardCode != "" {
+			v.Check(p.Qty == 1, fmt.Sprintf("products[%d].qty", i), "must be 1 for a gift card")
+			v.Check(p.ManualDiscount.IsZero(), fmt.Sprintf("products[%d].manual_discount", i), "must not be given for a gift card")
+			v.Check(len(p.Modifiers) == 0, fmt.Sprintf("products[%d].modifiers", i), "must not be given for a gift card")
+		}
+		for j, m := range p.Modifiers {
+			v.Check(m.ModifierId > 0, fmt.Sprintf("products[%d].modifiers[%d].modifier_id", i, j), "must be provided")
+		}
+	}
+}
+
+func insertOrderProduct(ctx context.Context, q queryer, line *OrderProduct) error {
+	query := `
+		INSERT INTO order_product (order_id, product_id, qty, price, total_normal_price, manual_discount, discount, promotion_id, tax_rate, tax, gift_card_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, updated_at
+	`
+	args := []interface{}{line.OrderId, line.ProductId, line.Qty, line.Price, line.TotalNormalPrice, line.ManualDiscount, line.Discount, line.PromotionId,
+		line.TaxRate, line.Tax, line.GiftCardId}
+
+	err := q.QueryRowContext(ctx, query, args...).Scan(&line.Id, &line.CreatedAt, &line.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return insertOrderProductModifiers(ctx, q, line)
+}
+
+// getOrderProducts loads the lines of the given orders together with their products
+// and groups them by order id.
+func getOrderProducts(ctx context.Context, q queryer, orderIds ...int) (map[int][]OrderProduct, error) {
+	query := `
+		SELECT op.id, op.order_id, op.product_id, op.qty, op.price, op.total_normal_price,
+			op.manual_discount, op.discount, op.promotion_id, op.tax_rate, op.tax, op.gift_card_id, COALESCE(g.code, ''), op.created_at, op.updated_at,
+			p.id, p.name, p.category_id, p.price, p.description, p.amount, p.tax_rate, p.created_at, p.updated_at
+		FROM order_product op
+		INNER JOIN products p ON p.id = op.product_id
+		LEFT JOIN gift_cards g ON g.id = op.gift_card_id
+		WHERE op.order_id = ANY($1)
+		ORDER BY op.order_id, op.id
+	`
+	rows, err := q.QueryContext(ctx, query, pq.Array(orderIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make(map[int][]OrderProduct)
+	lineIds := []int{}
+	for rows.Next() {
+		var line OrderProduct
+		err := rows.Scan(&line.Id, &line.OrderId, &line.ProductId, &line.Qty, &line.Price, &line.TotalNormalPrice,
+			&line.ManualDiscount, &line.Discount, &line.PromotionId, &line.TaxRate, &line.Tax, &line.GiftCardId, &line.GiftCardCode,
+			&line.CreatedAt, &line.UpdatedAt,
+			&line.Product.Id, &line.Product.Name, &line.Product.CategoryId, &line.Product.Price,
+			&line.Product.Description, &line.Product.Amount, &line.Product.TaxRate, &line.Product.CreatedAt, &line.Product.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		lines[line.OrderId] = append(lines[line.OrderId], line)
+		lineIds = append(lineIds, line.Id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	modifiers, err := getOrderProductModifiers(ctx, q, lineIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, orderLines := range lines {
+		for i := range orderLines {
+			orderLines[i].Modifiers = append([]OrderProductModifier{}, modifiers[orderLines[i].Id]...)
+		}
+	}
+
+	return lines, nil
+}
+
+// updateOrderProductPricing stores the discount and tax the pricing engine gave an
+// existing line.
+func updateOrderProductPricing(ctx context.Context, q queryer, line *OrderProduct) error {
+	query := `
+		UPDATE order_product
+		SET total_normal_price = $1, discount = $2, promotion_id = $3, tax = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+		RETURNING updated_at
+	`
+	args := []interface{}{line.TotalNormalPrice, line.Discount, line.PromotionId, line.Tax, line.Id}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&line.UpdatedAt)
 }
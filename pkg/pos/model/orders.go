@@ -3,23 +3,115 @@ package model
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Order struct {
-	Id          int            `json:"id"`
-	EmployeeID  int            `json:"employee_id"`
-	TotalPrice  float64        `json:"total_price"`
-	TotalPaid   float64        `json:"total_paid"`
-	TotalReturn float64        `json:"total_return"`
-	ReceiptID   string         `json:"receipt_id"`
-	Products    []OrderProduct `json:"products"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	Id            int            `json:"id"`
+	EmployeeID    int            `json:"employee_id"`
+	CustomerId    *int           `json:"customer_id"`
+	TotalPrice    Money          `json:"total_price"`
+	Discount      Money          `json:"discount"`
+	PromotionId   *int           `json:"promotion_id"`
+	Tax           Money          `json:"tax"`
+	TaxInclusive  bool           `json:"tax_inclusive"`
+	Taxes         []TaxLine      `json:"taxes"`
+	TotalPaid     Money          `json:"total_paid"`
+	TotalReturn   Money          `json:"total_return"`
+	StoreId       string         `json:"store_id"`
+	ShiftId       *int           `json:"shift_id"`
+	StationId     *int           `json:"station_id"`
+	ClientId      *string        `json:"client_id,omitempty"`
+	ReceiptNumber *int64         `json:"receipt_number"`
+	ReceiptID     string         `json:"receipt_id"`
+	Products      []OrderProduct `json:"products"`
+	Status        string         `json:"status"`
+	PaidAt        *time.Time     `json:"paid_at"`
+	VoidedAt      *time.Time     `json:"voided_at"`
+	VoidedBy      *int           `json:"voided_by"`
+	VoidReason    string         `json:"void_reason"`
+	Version       int            `json:"version"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+// Order statuses. An open order is a cart that is still being built; a held one is
+// parked and must be resumed before it changes or is paid. Paid, refunded and voided
+// orders no longer change their lines.
+const (
+	OrderOpen     = "open"
+	OrderHeld     = "held"
+	OrderPaid     = "paid"
+	OrderRefunded = "refunded"
+	OrderVoided   = "voided"
+)
+
+var (
+	// ErrOrderNotOpen is returned when an order that is not open is changed or paid.
+	ErrOrderNotOpen = errors.New("order is not open")
+
+	// ErrInvalidTransition is returned when an order cannot move to the requested status.
+	ErrInvalidTransition = errors.New("invalid order status transition")
+
+	// ErrOrderHasPayments is returned when an unpaid order with tenders is voided.
+	ErrOrderHasPayments = errors.New("order has payments")
+
+	// ErrVoidNeedsRefund is returned when a paid order is voided without a refund method.
+	ErrVoidNeedsRefund = errors.New("voiding a paid order needs a refund method")
+
+	// ErrOrderNotDeletable is returned when an order that took tenders or was voided is
+	// deleted. Such orders are voided instead, so their tenders are given back.
+	ErrOrderNotDeletable = errors.New("order cannot be deleted")
+)
+
+// orderTransitions lists the statuses every status may move to. Refunded and voided
+// are final.
+var orderTransitions = map[string][]string{
+	OrderOpen: {OrderHeld, OrderPaid, OrderVoided},
+	OrderHeld: {OrderOpen, OrderVoided},
+	OrderPaid: {OrderRefunded, OrderVoided},
+}
+
+// CanTransition reports whether the order may move to the given status.
+func (o *Order) CanTransition(to string) bool {
+	for _, s := range orderTransitions[o.Status] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition returns an error wrapping ErrInvalidTransition unless the order may
+// move to the given status.
+func checkTransition(order *Order, to string) error {
+	if !order.CanTransition(to) {
+		return fmt.Errorf("%w: order %d is %s and cannot become %s", ErrInvalidTransition, order.Id, order.Status, to)
+	}
+	return nil
+}
+
+// checkOrderOpen returns an error wrapping ErrOrderNotOpen unless the order is open.
+func checkOrderOpen(order *Order) error {
+	if order.Status != OrderOpen {
+		return fmt.Errorf("%w: order %d is %s", ErrOrderNotOpen, order.Id, order.Status)
+	}
+	return nil
 }
 
+// DefaultStoreId is used for orders created without an explicit store.
+const DefaultStoreId = "main"
+
+// orderColumns are the columns of an order header in the order orderFields scans them.
+const orderColumns = `id, employee_id, customer_id, store_id, shift_id, station_id, client_id, total_price, discount, promotion_id, tax,
+		tax_inclusive, total_paid, total_return, receipt_number, receipt_id, status, paid_at, voided_at, voided_by, void_reason, version, created_at, updated_at`
+
 type OrderModule struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
@@ -27,114 +119,772 @@ type OrderModule struct {
 }
 
 func (o OrderModule) Create(order *Order) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertOrder(ctx, tx, order); err != nil {
+		return err
+	}
+
+	for i := range order.Products {
+		order.Products[i].OrderId = order.Id
+		if err := insertOrderProduct(ctx, tx, &order.Products[i]); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (o OrderModule) Get(id int) (*Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getOrder(ctx, o.DB, id, false)
+}
+
+func (o OrderModule) GetAll() (*[]Order, error) {
 	query := `
-			INSERT INTO orders (employee_id, total_price, total_paid, total_return, receipt_id, created_at, updated_at, products)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-				RETURNING id
+		SELECT ` + orderColumns + `
+		FROM orders
+		ORDER BY id
+	`
+
+	var orders []Order
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := o.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var ord Order
+
+		err := rows.Scan(orderFields(&ord)...)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, ord)
+		ids = append(ids, ord.Id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lines, err := getOrderProducts(ctx, o.DB, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		orders[i].Products = linesOrEmpty(lines[orders[i].Id])
+		orders[i].Taxes = summarizeTaxes(&orders[i])
+	}
+
+	return &orders, nil
+}
+
+// Delete removes an order that took no tenders and puts the stock of its lines back.
+// Paid, voided and partly tendered orders fail with an error wrapping
+// ErrOrderNotDeletable.
+func (o OrderModule) Delete(id int, by AuditActor) error {
+	query := `
+			DELETE FROM orders
+			WHERE id = $1
 			`
-	// Serialize products slice to JSON
-	productsJSON, err := json.Marshal(order.Products)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order, err := getOrder(ctx, tx, id, true)
 	if err != nil {
 		return err
 	}
 
-	args := []interface{}{order.EmployeeID, order.TotalPrice, order.TotalPaid, order.TotalReturn, order.ReceiptID, order.CreatedAt, order.UpdatedAt,productsJSON}
+	switch {
+	case order.PaidAt != nil:
+		return fmt.Errorf("%w: order %d is paid, void it instead", ErrOrderNotDeletable, id)
+	case order.Status == OrderVoided:
+		return fmt.Errorf("%w: order %d is voided and kept for the record", ErrOrderNotDeletable, id)
+	case order.TotalPaid.IsPositive():
+		return fmt.Errorf("%w: %s was tendered on order %d, take the rest and void it instead", ErrOrderNotDeletable, order.TotalPaid, id)
+	}
+
+	if err := lockProducts(ctx, tx, productIds(order.Products)); err != nil {
+		return err
+	}
+	for _, line := range order.Products {
+		if err := returnStock(ctx, tx, order, line, "order deleted"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, by, AuditDelete, AuditOrder, id, order, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Checkout creates the order, writes its lines and records a sale movement for every
+// sold product inside a single transaction. Line prices are taken from the products
+// table, the promotions valid right now are applied and taxes computed. If any line
+// would take a product's amount below zero the whole sale is rolled back and an error
+// wrapping ErrOutOfStock is returned. The order is attached to the employee's open
+// shift, if any, and to the customer in CustomerId. The idempotency key of the
+// request, if any, is committed with the order.
+func (o OrderModule) Checkout(order *Order, by AuditActor, key *IdempotencyKey) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	return o.DB.QueryRowContext(ctx, query, args...).Scan(&order.Id)
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockProducts(ctx, tx, productIds(order.Products)); err != nil {
+		return err
+	}
+	for i := range order.Products {
+		if err := lockLineProduct(ctx, tx, &order.Products[i], order.StationId); err != nil {
+			return err
+		}
+		if err := checkStock(&order.Products[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := priceOrder(ctx, tx, order); err != nil {
+		return err
+	}
+
+	// Payments and receipt numbers are recorded through PaymentModel, never taken
+	// from the client.
+	order.TotalPaid = Money{}
+	order.TotalReturn = Money{}
+	order.ReceiptNumber = nil
+	order.ReceiptID = ""
+	order.ClientId = nil
+	order.CreatedAt = time.Time{}
+	order.VoidedAt = nil
+	order.VoidedBy = nil
+	order.VoidReason = ""
+
+	order.ShiftId, err = openShiftId(ctx, tx, order.EmployeeID)
+	if err != nil {
+		return err
+	}
+	if err := checkCustomer(ctx, tx, order.CustomerId); err != nil {
+		return err
+	}
+	if err := insertOrder(ctx, tx, order); err != nil {
+		return err
+	}
+
+	for i := range order.Products {
+		order.Products[i].OrderId = order.Id
+		if err := insertOrderProduct(ctx, tx, &order.Products[i]); err != nil {
+			return err
+		}
+		if err := sellStock(ctx, tx, order, &order.Products[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := auditOrder(ctx, tx, by, AuditCreate, order.Id, nil); err != nil {
+		return err
+	}
+	if err := commitIdempotencyKey(ctx, tx, key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (o OrderModule) Get(id int) (*Order, error) {
-    query := `
-        SELECT * FROM orders 
-        WHERE id = $1
-    `
-    var order Order
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-    defer cancel()
+// AddProduct appends a line to an open order and decrements the product's stock
+// in the same transaction. See checkOrderVersion for version.
+func (o OrderModule) AddProduct(id int, version int, line *OrderProduct, by AuditActor) (*Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-    row := o.DB.QueryRowContext(ctx, query, id)
-    var productsJSON []byte
-    err := row.Scan(&order.Id, &order.EmployeeID, &order.TotalPrice, &order.TotalPaid,
-        &order.TotalReturn, &order.ReceiptID, &order.CreatedAt, &order.UpdatedAt, &productsJSON)
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-    if err != nil {
-        return nil, err
-    }
+	order, err := getOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	before, err := auditSnapshot(order)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOrderVersion(order, version); err != nil {
+		return order, err
+	}
+	if err := checkOrderOpen(order); err != nil {
+		return nil, err
+	}
 
-    if err := json.Unmarshal(productsJSON, &order.Products); err != nil {
-        return nil, err
-    }
+	if err := lockLineProduct(ctx, tx, line, order.StationId); err != nil {
+		return nil, err
+	}
+	if err := checkStock(line); err != nil {
+		return nil, err
+	}
+
+	line.OrderId = order.Id
+	order.Products = append(order.Products, *line)
+	if err := priceOrder(ctx, tx, order); err != nil {
+		return nil, err
+	}
+
+	added := &order.Products[len(order.Products)-1]
+	if err := insertOrderProduct(ctx, tx, added); err != nil {
+		return nil, err
+	}
+	if err := sellStock(ctx, tx, order, added); err != nil {
+		return nil, err
+	}
+	*line = *added
+
+	if err := saveOrderPricing(ctx, tx, order); err != nil {
+		return nil, err
+	}
 
-    return &order, nil
+	if err := auditOrder(ctx, tx, by, AuditUpdate, order.Id, before); err != nil {
+		return nil, err
+	}
+
+	return order, tx.Commit()
 }
 
+// RemoveProduct drops every line of the given product from an open order and
+// puts the removed quantity back into stock in the same transaction. See
+// checkOrderVersion for version.
+func (o OrderModule) RemoveProduct(id int, version int, productId int, by AuditActor) (*Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-func (o OrderModule) GetAll() (*[]Order, error) {
-    query := `SELECT * FROM orders`
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-    var orders []Order
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-    defer cancel()
+	order, err := getOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	before, err := auditSnapshot(order)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOrderVersion(order, version); err != nil {
+		return order, err
+	}
+	if err := checkOrderOpen(order); err != nil {
+		return nil, err
+	}
 
-    rows, err := o.DB.QueryContext(ctx, query)
-    if err != nil {
-        return nil, err
-    }
-    defer rows.Close()
+	query := `
+		DELETE FROM order_product
+		WHERE order_id = $1 AND product_id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, id, productId)
+	if err != nil {
+		return nil, err
+	}
 
-    for rows.Next() {
-        var ord Order
-        var productsJSON []byte
+	remaining := []OrderProduct{}
+	for _, p := range order.Products {
+		if p.ProductId != productId {
+			remaining = append(remaining, p)
+			continue
+		}
+		if err := returnStock(ctx, tx, order, p, "removed from order"); err != nil {
+			return nil, err
+		}
+	}
 
-        err := rows.Scan(&ord.Id, &ord.EmployeeID, &ord.TotalPrice, &ord.TotalPaid,
-            &ord.TotalReturn, &ord.ReceiptID, &ord.CreatedAt, &ord.UpdatedAt, &productsJSON)
-        if err != nil {
-            return nil, err
-        }
+	order.Products = remaining
+	if err := priceOrder(ctx, tx, order); err != nil {
+		return nil, err
+	}
 
-        if err := json.Unmarshal(productsJSON, &ord.Products); err != nil {
-            return nil, err
-        }
+	if err := saveOrderPricing(ctx, tx, order); err != nil {
+		return nil, err
+	}
+
+	if err := auditOrder(ctx, tx, by, AuditUpdate, order.Id, before); err != nil {
+		return nil, err
+	}
+
+	return order, tx.Commit()
+}
+
+// SetCustomer attaches the customer to an open order, or detaches the current one
+// when customerId is nil. The customer cannot change once points were redeemed
+// against the order. See checkOrderVersion for version.
+func (o OrderModule) SetCustomer(id int, version int, customerId *int, by AuditActor) (*Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := getOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	before, err := auditSnapshot(order)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOrderVersion(order, version); err != nil {
+		return order, err
+	}
+
+	if order.PaidAt != nil {
+		return nil, ErrOrderAlreadyPaid
+	}
+	if err := checkOrderOpen(order); err != nil {
+		return nil, err
+	}
+
+	var redeemed bool
+	query := `SELECT EXISTS (SELECT 1 FROM payments WHERE order_id = $1 AND method = $2)`
+	if err := tx.QueryRowContext(ctx, query, id, TenderPoints).Scan(&redeemed); err != nil {
+		return nil, err
+	}
+	if redeemed {
+		return nil, ErrPointsRedeemed
+	}
+
+	if err := checkCustomer(ctx, tx, customerId); err != nil {
+		return nil, err
+	}
 
-        orders = append(orders, ord)
-    }
+	query = `
+		UPDATE orders
+		SET customer_id = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING version, updated_at
+	`
+	if err := tx.QueryRowContext(ctx, query, customerId, id).Scan(&order.Version, &order.UpdatedAt); err != nil {
+		return nil, err
+	}
+	order.CustomerId = customerId
 
-    if err := rows.Err(); err != nil {
-        return nil, err
-    }
+	if err := auditOrder(ctx, tx, by, AuditUpdate, order.Id, before); err != nil {
+		return nil, err
+	}
 
-    return &orders, nil
+	return order, tx.Commit()
 }
 
-func (o OrderModule) Update(id int, order *Order) error {
-    query := `
-        UPDATE orders
-        SET employee_id = $1, total_price = $2, total_paid = $3, total_return = $4, receipt_id = $5, products = $6, updated_at = $7
-        WHERE id = $8
-        RETURNING updated_at
-    `
+// Hold parks an open order, e.g. while the customer fetches another item. A held
+// order cannot change or be paid until it is resumed.
+func (o OrderModule) Hold(id int, by AuditActor) (*Order, error) {
+	return o.transition(id, OrderHeld, by)
+}
 
-    productsJSON, err := json.Marshal(order.Products)
-    if err != nil {
-        return err
-    }
+// Resume reopens a held order.
+func (o OrderModule) Resume(id int, by AuditActor) (*Order, error) {
+	return o.transition(id, OrderOpen, by)
+}
 
-    args := []interface{}{order.EmployeeID, order.TotalPrice, order.TotalPaid, order.TotalReturn, order.ReceiptID, productsJSON, time.Now(), id}
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-    defer cancel()
+func (o OrderModule) transition(id int, to string, by AuditActor) (*Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-    return o.DB.QueryRowContext(ctx, query, args...).Scan(&order.UpdatedAt)
+	order, err := getOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	before, err := auditSnapshot(order)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setOrderStatus(ctx, tx, order, to); err != nil {
+		return nil, err
+	}
+
+	if err := auditOrder(ctx, tx, by, AuditUpdate, order.Id, before); err != nil {
+		return nil, err
+	}
+
+	return order, tx.Commit()
 }
 
-func (o OrderModule) Delete(id int) error {
-	query := `
-			DELETE FROM orders 
-			WHERE id = $1
-			`
+// Void closes an order for good and records who voided it and why. An unpaid order
+// puts the stock of its lines back; it may not have tenders yet. A paid order is
+// refunded in full with refund, whose method the caller picks, and its lines are
+// restocked. The lines and payments of a voided order are kept for the record.
+func (o OrderModule) Void(id int, employeeId int, reason string, refund *Refund, by AuditActor) (*Order, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := o.DB.ExecContext(ctx, query, id)
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := getOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	before, err := auditSnapshot(order)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransition(order, OrderVoided); err != nil {
+		return nil, err
+	}
+
+	if order.PaidAt != nil {
+		if refund == nil || refund.Method == "" {
+			return nil, ErrVoidNeedsRefund
+		}
+		refund.EmployeeId = employeeId
+		refund.Reason = RefundReasonOther
+		refund.Note = reason
+		refund.Restock = true
+		refund.Lines = nil
+		if _, err := refundOrder(ctx, tx, order, refund); err != nil {
+			return nil, err
+		}
+	} else {
+		if order.TotalPaid.IsPositive() {
+			return nil, fmt.Errorf("%w: %s was tendered on order %d", ErrOrderHasPayments, order.TotalPaid, id)
+		}
+		if err := lockProducts(ctx, tx, productIds(order.Products)); err != nil {
+			return nil, err
+		}
+		for _, line := range order.Products {
+			if err := returnStock(ctx, tx, order, line, "order voided"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	query := `
+		UPDATE orders
+		SET status = $1, voided_at = CURRENT_TIMESTAMP, voided_by = NULLIF($2, 0), void_reason = $3, version = version + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+		RETURNING voided_at, voided_by, version, updated_at
+	`
+	err = tx.QueryRowContext(ctx, query, OrderVoided, employeeId, reason, id).Scan(&order.VoidedAt, &order.VoidedBy, &order.Version, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	order.Status = OrderVoided
+	order.VoidReason = reason
+
+	if err := auditOrder(ctx, tx, by, AuditUpdate, order.Id, before); err != nil {
+		return nil, err
+	}
+
+	return order, tx.Commit()
+}
+
+// setOrderStatus moves a locked order to the given status.
+func setOrderStatus(ctx context.Context, q queryer, order *Order, to string) error {
+	if err := checkTransition(order, to); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE orders
+		SET status = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING version, updated_at
+	`
+	if err := q.QueryRowContext(ctx, query, to, order.Id).Scan(&order.Version, &order.UpdatedAt); err != nil {
+		return err
+	}
+
+	order.Status = to
+	return nil
+}
+
+// auditOrder records a change to the order inside the transaction of q, reading the
+// order again for its state after the change. before is the order as it was locked,
+// or nil for a new order.
+func auditOrder(ctx context.Context, q queryer, by AuditActor, action string, id int, before interface{}) error {
+	after, err := getOrder(ctx, q, id, false)
+	if err != nil {
+		return err
+	}
+	return insertAudit(ctx, q, by, action, AuditOrder, id, before, after)
+}
+
+func insertOrder(ctx context.Context, q queryer, order *Order) error {
+	query := `
+		INSERT INTO orders (employee_id, customer_id, store_id, shift_id, station_id, client_id, total_price, discount, promotion_id, tax, tax_inclusive, total_paid, total_return, receipt_id, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id
+	`
+	order.Status = OrderOpen
+	order.UpdatedAt = time.Now()
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = order.UpdatedAt
+	}
+	if order.StoreId == "" {
+		order.StoreId = DefaultStoreId
+	}
+
+	args := []interface{}{order.EmployeeID, order.CustomerId, order.StoreId, order.ShiftId, order.StationId, order.ClientId, order.TotalPrice, order.Discount, order.PromotionId, order.Tax, order.TaxInclusive, order.TotalPaid, order.TotalReturn, order.ReceiptID, order.Status, order.CreatedAt, order.UpdatedAt}
+	return q.QueryRowContext(ctx, query, args...).Scan(&order.Id)
+}
+
+// getOrder loads an order with its lines. With forUpdate set the order row is locked
+// until the surrounding transaction ends.
+func getOrder(ctx context.Context, q queryer, id int, forUpdate bool) (*Order, error) {
+	query := `
+		SELECT ` + orderColumns + `
+		FROM orders
+		WHERE id = $1
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var order Order
+	err := q.QueryRowContext(ctx, query, id).Scan(orderFields(&order)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	lines, err := getOrderProducts(ctx, q, order.Id)
+	if err != nil {
+		return nil, err
+	}
+	order.Products = linesOrEmpty(lines[order.Id])
+	order.Taxes = summarizeTaxes(&order)
+
+	return &order, nil
+}
+
+// orderFields returns the scan destinations of orderColumns.
+func orderFields(order *Order) []interface{} {
+	return []interface{}{&order.Id, &order.EmployeeID, &order.CustomerId, &order.StoreId, &order.ShiftId, &order.StationId,
+		&order.ClientId, &order.TotalPrice, &order.Discount, &order.PromotionId, &order.Tax, &order.TaxInclusive,
+		&order.TotalPaid, &order.TotalReturn, &order.ReceiptNumber, &order.ReceiptID, &order.Status, &order.PaidAt, &order.VoidedAt,
+		&order.VoidedBy, &order.VoidReason, &order.Version, &order.CreatedAt, &order.UpdatedAt}
+}
+
+// checkOrderVersion returns ErrEditConflict when the caller made its change against
+// another version of the order than the locked one. Callers return the locked order
+// along with the error so the client learns the current version.
+func checkOrderVersion(order *Order, version int) error {
+	if version != order.Version {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// saveOrderPricing stores the discounts, taxes and totals the pricing engine computed for
+// an order whose lines are already persisted.
+func saveOrderPricing(ctx context.Context, q queryer, order *Order) error {
+	for i := range order.Products {
+		if err := updateOrderProductPricing(ctx, q, &order.Products[i]); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE orders
+		SET total_price = $1, discount = $2, promotion_id = $3, tax = $4, version = version + 1, updated_at = $5
+		WHERE id = $6
+		RETURNING version, updated_at
+	`
+	args := []interface{}{order.TotalPrice, order.Discount, order.PromotionId, order.Tax, time.Now(), order.Id}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&order.Version, &order.UpdatedAt)
+}
+
+// lockProducts locks the product rows of ids in ascending id order, the order every
+// transaction that moves the stock of several products takes them in. Locking them
+// line by line in the order the till sent them could deadlock two sales of the same
+// products listed the other way around.
+func lockProducts(ctx context.Context, q queryer, ids []int) error {
+	query := `
+		SELECT id
+		FROM products
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE
+	`
+	_, err := q.ExecContext(ctx, query, pq.Array(ids))
 	return err
 }
+
+func productIds(lines []OrderProduct) []int {
+	ids := make([]int, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.ProductId)
+	}
+	return ids
+}
+
+// lockLineProduct locks the product row of the line. The line's price, tax rate and
+// product snapshot are filled from the row; the price includes the deltas of the
+// chosen modifiers and the tax rate is the product's own, else its category's, else
+// the default of the order's station. Products with variants are only sold through
+// them. A line with a gift card code sells that card instead, under the line's
+// product. Stock is checked by checkStock and taken by sellStock once the line is
+// saved.
+func lockLineProduct(ctx context.Context, q queryer, line *OrderProduct, stationId *int) error {
+	query := `
+		SELECT p.id, p.name, p.category_id, p.price, p.description, p.amount, p.tax_rate, p.created_at, p.updated_at,
+			COALESCE(p.tax_rate, c.tax_rate, s.default_tax_rate, 0),
+			EXISTS (SELECT 1 FROM products v WHERE v.parent_id = p.id)
+		FROM products p
+		LEFT JOIN categories c ON c.id = p.category_id
+		LEFT JOIN stations s ON s.id = $2
+		WHERE p.id = $1
+		FOR UPDATE OF p
+	`
+	var product Product
+	var hasVariants bool
+	err := q.QueryRowContext(ctx, query, line.ProductId, stationId).Scan(&product.Id, &product.Name, &product.CategoryId,
+		&product.Price, &product.Description, &product.Amount, &product.TaxRate, &product.CreatedAt, &product.UpdatedAt,
+		&line.TaxRate, &hasVariants)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	if hasVariants {
+		return fmt.Errorf("%w: %q is sold through its variants", ErrProductHasVariants, product.Name)
+	}
+	if line.GiftCardCode != "" {
+		line.Product = product
+		return lockGiftCardLine(ctx, q, line)
+	}
+	delta, err := resolveLineModifiers(ctx, q, line)
+	if err != nil {
+		return err
+	}
+
+	line.Price = product.Price.Add(delta)
+	if line.Price.IsNegative() {
+		return fmt.Errorf("%w: modifiers take the price of %q below zero", ErrInvalidModifiers, product.Name)
+	}
+	line.TotalNormalPrice = line.Price.Times(line.Qty)
+	line.Product = product
+	return nil
+}
+
+// checkStock makes sure the stock of a locked line's product covers its quantity.
+func checkStock(line *OrderProduct) error {
+	if line.GiftCardId == nil && line.Product.Amount < line.Qty {
+		return fmt.Errorf("%w: %q has %d left, %d requested", ErrOutOfStock, line.Product.Name, line.Product.Amount, line.Qty)
+	}
+	return nil
+}
+
+// sellStock takes the line's quantity out of stock as a sale of the order. Gift card
+// lines hold no stock.
+func sellStock(ctx context.Context, q queryer, order *Order, line *OrderProduct) error {
+	if line.GiftCardId != nil {
+		return nil
+	}
+
+	movement := StockMovement{
+		ProductId:  line.ProductId,
+		Type:       StockSale,
+		Qty:        -line.Qty,
+		EmployeeId: order.EmployeeID,
+		Reference:  orderReference(order.Id),
+	}
+	if err := moveStock(ctx, q, &movement); err != nil {
+		return err
+	}
+
+	line.Product.Amount = movement.Balance
+	return nil
+}
+
+// returnStock reverses the sale of a line that is taken off an unpaid order.
+func returnStock(ctx context.Context, q queryer, order *Order, line OrderProduct, reason string) error {
+	if line.GiftCardId != nil {
+		return nil
+	}
+
+	movement := StockMovement{
+		ProductId:  line.ProductId,
+		Type:       StockSale,
+		Qty:        line.Qty,
+		EmployeeId: order.EmployeeID,
+		Reason:     reason,
+		Reference:  orderReference(order.Id),
+	}
+	return moveStock(ctx, q, &movement)
+}
+
+func linesOrEmpty(lines []OrderProduct) []OrderProduct {
+	if lines == nil {
+		return []OrderProduct{}
+	}
+	return lines
+}
+
+// nextReceiptNumber takes the next number from the store's receipt sequence. The
+// sequence row stays locked until the surrounding transaction ends, which keeps the
+// numbering gap-free: a rolled back payment gives its number back.
+func nextReceiptNumber(ctx context.Context, q queryer, storeId string) (int64, error) {
+	query := `
+		INSERT INTO receipt_sequences (store_id, last_number)
+		VALUES ($1, 1)
+		ON CONFLICT (store_id) DO UPDATE SET last_number = receipt_sequences.last_number + 1
+		RETURNING last_number
+	`
+	var number int64
+	err := q.QueryRowContext(ctx, query, storeId).Scan(&number)
+	return number, err
+}
+
+func formatReceiptID(storeId string, number int64) string {
+	return fmt.Sprintf("%s-%08d", strings.ToUpper(storeId), number)
+}
@@ -0,0 +1,316 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+)
+
+const (
+	TenderCash     = "cash"
+	TenderCard     = "card"
+	TenderTransfer = "transfer"
+	TenderPoints   = "points"
+	TenderGiftCard = "gift_card"
+)
+
+// Tenders are all payment methods, in the order they are listed to the cashier.
+var Tenders = []string{TenderCash, TenderCard, TenderTransfer, TenderPoints, TenderGiftCard}
+
+var (
+	// ErrOrderAlreadyPaid is returned when a payment is recorded against an order that is already covered.
+	ErrOrderAlreadyPaid = errors.New("order is already paid")
+
+	// ErrTenderExceedsDue is returned when a non-cash tender is larger than the amount still due.
+	ErrTenderExceedsDue = errors.New("tender exceeds amount due")
+)
+
+type Payment struct {
+	Id         int       `json:"id"`
+	OrderId    int       `json:"order_id"`
+	EmployeeId int       `json:"employee_id"`
+	ShiftId    *int      `json:"shift_id"`
+	Method     string    `json:"method"`
+	Amount     Money     `json:"amount"`
+	Change     Money     `json:"change"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// GiftCardCode names the card of a gift card tender; GiftCardId is filled from it.
+	GiftCardId   *int   `json:"gift_card_id,omitempty"`
+	GiftCardCode string `json:"gift_card_code,omitempty"`
+}
+
+// PaymentResult is what the server computed after recording tenders against an order.
+type PaymentResult struct {
+	Order     *Order    `json:"order"`
+	Payments  []Payment `json:"payments"`
+	AmountDue Money     `json:"amount_due"`
+	ChangeDue Money     `json:"change_due"`
+
+	// PointsEarned is what the order's customer earned when the order became paid.
+	PointsEarned int `json:"points_earned"`
+}
+
+type PaymentModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidatePayments(v *validator.Validator, payments []Payment) {
+	v.Check(len(payments) > 0, "tenders", "must contain at least one tender")
+	for i, p := range payments {
+		v.Check(validator.In(p.Method, Tenders...), fmt.Sprintf("tenders[%d].method", i), "must be cash, card, transfer, points or gift_card")
+		if p.Method == TenderGiftCard {
+			v.Check(p.GiftCardCode != "", fmt.Sprintf("tenders[%d].gift_card_code", i), "must be provided")
+			v.Check(!p.Amount.IsNegative(), fmt.Sprintf("tenders[%d].amount", i), "must not be negative")
+			continue
+		}
+		v.Check(p.Amount.IsPositive(), fmt.Sprintf("tenders[%d].amount", i), "must be greater than zero")
+		if p.Method == TenderPoints {
+			_, whole := PointsFor(p.Amount)
+			v.Check(whole, fmt.Sprintf("tenders[%d].amount", i), fmt.Sprintf("must be a multiple of the point value %s", LoyaltyPointValue))
+		}
+	}
+}
+
+// Create records one or more tenders against an order in a single transaction. Card
+// and transfer tenders may not exceed the amount still due; only cash can be
+// overtendered, and the surplus is returned as change. The order is marked paid and
+// given the next receipt number of its store once the tenders cover its total. Only
+// open orders take payments.
+// Points tenders are taken from the balance of the order's customer, who earns the
+// points of the loyalty rules once the order is paid. Gift card tenders are taken off
+// the card's balance; without an amount they take as much of it as is due. Gift cards
+// sold on the order are activated when it is paid. The idempotency key of the request,
+// if any, is committed with the payment.
+func (m PaymentModel) Create(orderId int, employeeId int, tenders []Payment, by AuditActor, key *IdempotencyKey) (*PaymentResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := getOrder(ctx, tx, orderId, true)
+	if err != nil {
+		return nil, err
+	}
+	before, err := auditSnapshot(order)
+	if err != nil {
+		return nil, err
+	}
+
+	shiftId, err := takingsShiftId(ctx, tx, employeeId)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := payOrder(ctx, tx, order, employeeId, shiftId, tenders, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := auditOrder(ctx, tx, by, AuditUpdate, orderId, before); err != nil {
+		return nil, err
+	}
+	if err := commitIdempotencyKey(ctx, tx, key); err != nil {
+		return nil, err
+	}
+
+	return result, tx.Commit()
+}
+
+// payOrder is Create on a locked order inside a transaction; the tenders are booked to
+// the shift shiftId and paidAt is the time the order is marked paid at.
+func payOrder(ctx context.Context, q queryer, order *Order, employeeId int, shiftId *int, tenders []Payment, paidAt time.Time) (*PaymentResult, error) {
+	if order.PaidAt != nil {
+		return nil, ErrOrderAlreadyPaid
+	}
+	if err := checkOrderOpen(order); err != nil {
+		return nil, err
+	}
+
+	existing, err := getPaymentsForOrder(ctx, q, order.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var paid, pointsPaid Money
+	for _, p := range existing {
+		paid = paid.Add(p.Amount)
+		if p.Method == TenderPoints {
+			pointsPaid = pointsPaid.Add(p.Amount)
+		}
+	}
+
+	// Non-cash tenders are applied first so that cash is the tender that produces change.
+	due := order.TotalPrice.Sub(paid)
+	for i := range tenders {
+		t := &tenders[i]
+		if t.Method == TenderCash {
+			continue
+		}
+		if t.Method == TenderGiftCard {
+			if err := resolveGiftCardTender(ctx, q, t, due); err != nil {
+				return nil, err
+			}
+		}
+		if t.Amount.Cmp(due) > 0 {
+			return nil, fmt.Errorf("%w: %s %s, due %s", ErrTenderExceedsDue, t.Method, t.Amount, due)
+		}
+		due = due.Sub(t.Amount)
+	}
+
+	// Any surplus can only come from cash, and it is handed back out of the drawer
+	// of the shift that took the last cash tender.
+	for _, t := range tenders {
+		paid = paid.Add(t.Amount)
+	}
+	if change := paid.Sub(order.TotalPrice); change.IsPositive() {
+		for i := len(tenders) - 1; i >= 0; i-- {
+			if tenders[i].Method == TenderCash {
+				tenders[i].Change = change
+				break
+			}
+		}
+	}
+
+	query := `
+		INSERT INTO payments (order_id, employee_id, shift_id, method, amount, change, gift_card_id)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at
+	`
+	for i := range tenders {
+		tenders[i].OrderId = order.Id
+		tenders[i].EmployeeId = employeeId
+		tenders[i].ShiftId = shiftId
+
+		args := []interface{}{order.Id, employeeId, shiftId, tenders[i].Method, tenders[i].Amount, tenders[i].Change, tenders[i].GiftCardId}
+		err := q.QueryRowContext(ctx, query, args...).Scan(&tenders[i].Id, &tenders[i].CreatedAt, &tenders[i].UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tenders[i].Method {
+		case TenderPoints:
+			if err := redeemPoints(ctx, q, order, &tenders[i]); err != nil {
+				return nil, err
+			}
+			pointsPaid = pointsPaid.Add(tenders[i].Amount)
+		case TenderGiftCard:
+			if err := redeemGiftCard(ctx, q, order, &tenders[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		existing = append(existing, tenders[i])
+	}
+
+	result := &PaymentResult{Order: order, Payments: existing}
+	order.TotalPaid = paid
+	if paid.Cmp(order.TotalPrice) >= 0 {
+		number, err := nextReceiptNumber(ctx, q, order.StoreId)
+		if err != nil {
+			return nil, err
+		}
+
+		order.Status = OrderPaid
+		order.PaidAt = &paidAt
+		order.ReceiptNumber = &number
+		order.ReceiptID = formatReceiptID(order.StoreId, number)
+		order.TotalReturn = paid.Sub(order.TotalPrice)
+		result.ChangeDue = order.TotalReturn
+
+		if err := activateGiftCards(ctx, q, order, employeeId); err != nil {
+			return nil, err
+		}
+		if order.CustomerId != nil {
+			result.PointsEarned, err = earnPoints(ctx, q, order, pointsPaid, employeeId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		result.AmountDue = order.TotalPrice.Sub(paid)
+	}
+
+	query = `
+		UPDATE orders
+		SET total_paid = $1, total_return = $2, status = $3, paid_at = $4, receipt_number = $5, receipt_id = $6, version = version + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $7
+		RETURNING version, updated_at
+	`
+	args := []interface{}{order.TotalPaid, order.TotalReturn, order.Status, order.PaidAt, order.ReceiptNumber, order.ReceiptID, order.Id}
+	err = q.QueryRowContext(ctx, query, args...).Scan(&order.Version, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// redeemPoints takes the points that pay for a points tender from the balance of the
+// order's customer.
+func redeemPoints(ctx context.Context, q queryer, order *Order, tender *Payment) error {
+	if order.CustomerId == nil {
+		return ErrNoCustomer
+	}
+
+	points, _ := PointsFor(tender.Amount)
+	t := LoyaltyTransaction{
+		CustomerId: *order.CustomerId,
+		OrderId:    &order.Id,
+		Type:       PointsRedeem,
+		Points:     -points,
+		EmployeeId: tender.EmployeeId,
+	}
+	return movePoints(ctx, q, &t)
+}
+
+func (m PaymentModel) GetAllForOrder(orderId int) ([]Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getPaymentsForOrder(ctx, m.DB, orderId)
+}
+
+func getPaymentsForOrder(ctx context.Context, q queryer, orderId int) ([]Payment, error) {
+	query := `
+		SELECT p.id, p.order_id, COALESCE(p.employee_id, 0), p.shift_id, p.method, p.amount, p.change, p.created_at, p.updated_at,
+			p.gift_card_id, COALESCE(g.code, '')
+		FROM payments p
+		LEFT JOIN gift_cards g ON g.id = p.gift_card_id
+		WHERE p.order_id = $1
+		ORDER BY p.id
+	`
+	rows, err := q.QueryContext(ctx, query, orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		var p Payment
+		err := rows.Scan(&p.Id, &p.OrderId, &p.EmployeeId, &p.ShiftId, &p.Method, &p.Amount, &p.Change, &p.CreatedAt, &p.UpdatedAt,
+			&p.GiftCardId, &p.GiftCardCode)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
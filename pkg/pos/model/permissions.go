@@ -62,7 +62,9 @@ func (m PermissionModel) GetAllForUser(userID int) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int, codes ...string) error {
+// AddForUser grants the permissions to the employee and records the grant in the
+// audit log.
+func (m PermissionModel) AddForUser(userID int, by AuditActor, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
@@ -70,6 +72,18 @@ func (m PermissionModel) AddForUser(userID int, codes ...string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query, userID, pq.Array(codes)); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, by, AuditCreate, AuditPermissions, userID, nil, Permissions(codes)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
\ No newline at end of file
@@ -0,0 +1,386 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"pos-rs/pkg/pos/validator"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CategoryPathSeparator separates the levels of a category path in imports and
+// exports, e.g. "Drinks/Coffee".
+const CategoryPathSeparator = "/"
+
+// importTimeout bounds a whole import; catalogs run to thousands of rows.
+const importTimeout = 60 * time.Second
+
+// ProductImportRow is one product of a catalog import. Line is the row number in the
+// source file, used for error reporting. A nil Amount or empty Barcodes leave the
+// stock and barcodes of an existing product unchanged.
+type ProductImportRow struct {
+	Line        int
+	Sku         string
+	Name        string
+	Category    string
+	Price       Money
+	TaxRate     *float64
+	Description string
+	Amount      *int
+	MinStock    int
+	ReorderQty  int
+	Barcodes    []string
+	ParentSku   string
+	Attributes  VariantAttributes
+}
+
+// ImportError is a problem with one row of an import.
+type ImportError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportResult summarizes an import. Nothing is saved on a dry run or when any row
+// has errors.
+type ImportResult struct {
+	DryRun            bool          `json:"dry_run"`
+	Applied           bool          `json:"applied"`
+	Rows              int           `json:"rows"`
+	Created           int           `json:"created"`
+	Updated           int           `json:"updated"`
+	CategoriesCreated int           `json:"categories_created"`
+	Errors            []ImportError `json:"errors"`
+}
+
+// ProductExportRow is a product as the catalog export writes it.
+type ProductExportRow struct {
+	Product
+	CategoryPath string
+	ParentSku    string
+}
+
+func ValidateProductImportRow(v *validator.Validator, row *ProductImportRow) {
+	v.Check(row.Name != "", "name", "must be provided")
+	v.Check(row.Category != "", "category", "must be provided")
+	v.Check(row.Category == "" || validCategoryPath(row.Category), "category", "must not have empty levels")
+	v.Check(!row.Price.IsNegative(), "price", "must not be negative")
+	v.Check(row.TaxRate == nil || *row.TaxRate >= 0, "tax_rate", "must not be negative")
+	v.Check(row.Amount == nil || *row.Amount >= 0, "amount", "must not be negative")
+	v.Check(row.MinStock >= 0, "min_stock", "must not be negative")
+	v.Check(row.ReorderQty >= 0, "reorder_qty", "must not be negative")
+	v.Check(len(row.Sku) <= 64, "sku", "must not be more than 64 bytes long")
+	v.Check(row.ParentSku == "" || row.ParentSku != row.Sku, "parent_sku", "must not be the product's own sku")
+	v.Check(row.ParentSku == "" || len(row.Attributes) > 0, "attributes", "must be provided for a variant")
+	for _, code := range row.Barcodes {
+		v.Check(ValidBarcode(code), "barcodes", fmt.Sprintf("%q is not a valid EAN-8, UPC-A or EAN-13 code", code))
+	}
+}
+
+// Import upserts the rows in one transaction. A row updates the product with its SKU,
+// or else the product one of its barcodes belongs to, and creates a product when
+// neither matches. Missing categories along a row's category path are created, and
+// variants are imported after every other row so their parents exist. Every
+// row is tried so the result lists all errors, but the import is only committed when
+// it is not a dry run and no row failed. Every imported product gets its own audit
+// entry, and the import as a whole one more with its counts.
+func (p ProductModule) Import(rows []ProductImportRow, dryRun bool, by AuditActor) (*ImportResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), importTimeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{DryRun: dryRun, Rows: len(rows), Errors: []ImportError{}}
+	categories := make(map[string]int)
+
+	order := make([]int, 0, len(rows))
+	for i := range rows {
+		if rows[i].ParentSku == "" {
+			order = append(order, i)
+		}
+	}
+	for i := range rows {
+		if rows[i].ParentSku != "" {
+			order = append(order, i)
+		}
+	}
+
+	for _, i := range order {
+		row := &rows[i]
+
+		v := validator.New()
+		if ValidateProductImportRow(v, row); !v.Valid() {
+			result.Errors = append(result.Errors, validationImportErrors(row.Line, v.Errors)...)
+			continue
+		}
+
+		// Categories are created outside the row's savepoint so the cache stays valid
+		// when the row is rolled back.
+		categoryId, err := importCategory(ctx, tx, row.Category, categories, result)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT import_row`); err != nil {
+			return nil, err
+		}
+
+		created, err := importProduct(ctx, tx, row, categoryId, by)
+		if err != nil {
+			if !isImportRowError(err) {
+				return nil, err
+			}
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_row`); err != nil {
+				return nil, err
+			}
+			result.Errors = append(result.Errors, ImportError{Line: row.Line, Message: err.Error()})
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT import_row`); err != nil {
+			return nil, err
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+
+	if dryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	result.Applied = true
+	if err := insertAudit(ctx, tx, by, AuditImport, AuditProduct, 0, nil, result); err != nil {
+		return nil, err
+	}
+
+	return result, tx.Commit()
+}
+
+// Export calls fn for every product of the catalog, parents before their variants,
+// reading the products as they are written instead of loading them all.
+func (p ProductModule) Export(ctx context.Context, fn func(ProductExportRow) error) error {
+	query := `
+		WITH RECURSIVE paths AS (
+			SELECT id, name::TEXT AS path FROM categories WHERE parent_id IS NULL
+			UNION ALL
+			SELECT c.id, paths.path || '` + CategoryPathSeparator + `' || c.name
+			FROM categories c INNER JOIN paths ON c.parent_id = paths.id
+		)
+		SELECT ` + productColumns + `, COALESCE(paths.path, ''), COALESCE(parent.sku, '')
+		FROM products p
+		LEFT JOIN paths ON paths.id = p.category_id
+		LEFT JOIN products parent ON parent.id = p.parent_id
+		ORDER BY COALESCE(p.parent_id, p.id), p.parent_id NULLS FIRST, p.id
+	`
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r ProductExportRow
+		err := rows.Scan(&r.Id, &r.Name, &r.CategoryId, &r.Price, &r.CostPrice, &r.Description, &r.Amount,
+			&r.MinStock, &r.ReorderQty, &r.TaxRate, &r.Sku, &r.ParentId, &r.Attributes, pq.Array(&r.Barcodes),
+			&r.Version, &r.CreatedAt, &r.UpdatedAt, &r.CategoryPath, &r.ParentSku)
+		if err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// importCategory returns the id of the category at path, which validCategoryPath
+// accepts, creating the missing levels as the last children of their parents.
+func importCategory(ctx context.Context, q queryer, path string, cache map[string]int, result *ImportResult) (int, error) {
+	var parentId *int
+	key := ""
+	for _, name := range strings.Split(path, CategoryPathSeparator) {
+		name = strings.TrimSpace(name)
+		key += CategoryPathSeparator + name
+
+		id, ok := cache[key]
+		if !ok {
+			query := `SELECT id FROM categories WHERE name = $1 AND parent_id IS NOT DISTINCT FROM $2 ORDER BY position, id LIMIT 1`
+			err := q.QueryRowContext(ctx, query, name, parentId).Scan(&id)
+			if errors.Is(err, sql.ErrNoRows) {
+				query = `
+					INSERT INTO categories (name, parent_id, position)
+					VALUES ($1, $2, (SELECT COUNT(*) FROM categories WHERE parent_id IS NOT DISTINCT FROM $2))
+					RETURNING id
+				`
+				err = q.QueryRowContext(ctx, query, name, parentId).Scan(&id)
+				result.CategoriesCreated++
+			}
+			if err != nil {
+				return 0, err
+			}
+			cache[key] = id
+		}
+
+		parent := id
+		parentId = &parent
+	}
+
+	return *parentId, nil
+}
+
+// validCategoryPath reports whether every level of the path has a name.
+func validCategoryPath(path string) bool {
+	for _, name := range strings.Split(path, CategoryPathSeparator) {
+		if strings.TrimSpace(name) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// importProduct creates or updates the product of a row, records the change in the
+// audit log and reports whether the product was created.
+func importProduct(ctx context.Context, q queryer, row *ProductImportRow, categoryId int, by AuditActor) (bool, error) {
+	id, err := matchImportProduct(ctx, q, row)
+	if err != nil {
+		return false, err
+	}
+
+	product := Product{
+		Name:        row.Name,
+		CategoryId:  categoryId,
+		Price:       row.Price,
+		Description: row.Description,
+		MinStock:    row.MinStock,
+		ReorderQty:  row.ReorderQty,
+		TaxRate:     row.TaxRate,
+		Sku:         row.Sku,
+		Barcodes:    row.Barcodes,
+		Attributes:  row.Attributes,
+	}
+
+	if row.ParentSku != "" {
+		var parentId int
+		err := q.QueryRowContext(ctx, `SELECT id FROM products WHERE sku = $1`, row.ParentSku).Scan(&parentId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("%w: no product has sku %q", ErrInvalidParent, row.ParentSku)
+		}
+		if err != nil {
+			return false, err
+		}
+		product.ParentId = &parentId
+	}
+
+	if id == 0 {
+		if row.Amount != nil {
+			product.Amount = *row.Amount
+		}
+		if err := insertProduct(ctx, q, &product); err != nil {
+			return false, err
+		}
+		return true, insertAudit(ctx, q, by, AuditCreate, AuditProduct, product.Id, nil, product)
+	}
+
+	// The import is the source of truth and overwrites whatever version is current.
+	before, err := getProduct(ctx, q, id, true)
+	if err != nil {
+		return false, err
+	}
+	product.Version = before.Version
+
+	if len(product.Barcodes) == 0 {
+		query := `SELECT ARRAY(SELECT barcode FROM product_barcodes WHERE product_id = $1 ORDER BY barcode)`
+		if err := q.QueryRowContext(ctx, query, id).Scan(pq.Array(&product.Barcodes)); err != nil {
+			return false, err
+		}
+	}
+
+	if err := updateProduct(ctx, q, id, &product); err != nil {
+		return false, err
+	}
+
+	if row.Amount != nil && *row.Amount != product.Amount {
+		movement := StockMovement{ProductId: id, Type: StockAdjustment, Qty: *row.Amount - product.Amount, Reason: "catalog import"}
+		if err := moveStock(ctx, q, &movement); err != nil {
+			return false, err
+		}
+	}
+
+	after, err := getProduct(ctx, q, id, false)
+	if err != nil {
+		return false, err
+	}
+	return false, insertAudit(ctx, q, by, AuditUpdate, AuditProduct, id, before, after)
+}
+
+// matchImportProduct finds the product a row updates: the one with its SKU, or else
+// the one its barcodes belong to. It returns 0 when the row is a new product.
+func matchImportProduct(ctx context.Context, q queryer, row *ProductImportRow) (int, error) {
+	var id int
+	if row.Sku != "" {
+		err := q.QueryRowContext(ctx, `SELECT id FROM products WHERE sku = $1`, row.Sku).Scan(&id)
+		if err == nil || !errors.Is(err, sql.ErrNoRows) {
+			return id, err
+		}
+	}
+
+	if len(row.Barcodes) == 0 {
+		return 0, nil
+	}
+
+	codes := make([]string, len(row.Barcodes))
+	for i, code := range row.Barcodes {
+		codes[i] = normalizeBarcode(code)
+	}
+
+	var ids []int64
+	query := `SELECT ARRAY(SELECT DISTINCT product_id FROM product_barcodes WHERE barcode = ANY($1))`
+	if err := q.QueryRowContext(ctx, query, pq.Array(codes)).Scan(pq.Array(&ids)); err != nil {
+		return 0, err
+	}
+
+	switch len(ids) {
+	case 0:
+		return 0, nil
+	case 1:
+		return int(ids[0]), nil
+	}
+	return 0, fmt.Errorf("%w: the barcodes belong to %d different products", ErrDuplicateBarcode, len(ids))
+}
+
+// isImportRowError reports whether err is a problem with the row rather than with the
+// import as a whole.
+func isImportRowError(err error) bool {
+	var pqErr *pq.Error
+	return errors.Is(err, ErrDuplicateBarcode) ||
+		errors.Is(err, ErrDuplicateSku) ||
+		errors.Is(err, ErrInvalidParent) ||
+		errors.As(err, &pqErr)
+}
+
+func validationImportErrors(line int, errs map[string]string) []ImportError {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	out := make([]ImportError, 0, len(fields))
+	for _, field := range fields {
+		out = append(out, ImportError{Line: line, Field: field, Message: errs[field]})
+	}
+	return out
+}
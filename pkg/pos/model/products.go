@@ -3,20 +3,55 @@ package model
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"pos-rs/pkg/pos/validator"
 	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrDuplicateBarcode is returned when a barcode already belongs to another product.
+	ErrDuplicateBarcode = errors.New("barcode already assigned to a product")
+
+	// ErrDuplicateSku is returned when an SKU is already used by another product.
+	ErrDuplicateSku = errors.New("sku already assigned to a product")
+
+	// ErrInvalidParent is returned when a variant's parent does not exist, is itself a
+	// variant, or the product would become a variant while having variants of its own.
+	ErrInvalidParent = errors.New("invalid parent product")
+
+	// ErrProductHasVariants is returned when a product with variants is sold or deleted.
+	ErrProductHasVariants = errors.New("product has variants")
+
+	// ErrProductHasHistory is returned when a product that was stocked or sold is
+	// deleted; its ledger and orders keep referring to it.
+	ErrProductHasHistory = errors.New("product has stock or sales history")
 )
 
 type Product struct {
-	Id          int       `json:"id"`
-	Name        string    `json:"name"`
-	CategoryId  int       `json:"categoryId"`
-	Price       int       `json:"price"`
-	Description string    `json:"description"`
-	Amount      int       `json:"amount"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"UpdatedAt"`
+	Id          int               `json:"id"`
+	Name        string            `json:"name"`
+	CategoryId  int               `json:"categoryId"`
+	Price       Money             `json:"price"`
+	CostPrice   Money             `json:"costPrice"`
+	Description string            `json:"description"`
+	Amount      int               `json:"amount"`
+	MinStock    int               `json:"minStock"`
+	ReorderQty  int               `json:"reorderQty"`
+	TaxRate     *float64          `json:"taxRate"`
+	Sku         string            `json:"sku"`
+	Barcodes    []string          `json:"barcodes"`
+	ParentId    *int              `json:"parentId"`
+	Attributes  VariantAttributes `json:"attributes"`
+	Variants    []Product         `json:"variants,omitempty"`
+	Version     int               `json:"version"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"UpdatedAt"`
 }
 
 type ProductModule struct {
@@ -25,48 +60,130 @@ type ProductModule struct {
 	ErrorLog *log.Logger
 }
 
-func (p ProductModule) Create(product *Product) error {
-	fmt.Println("Hello From Product Module")
-	query := `
-			INSERT INTO products (name, category_id, price, description, amount)
-			VALUES ($1, $2, $3, $4, $5)
-			RETURNING id
-			`
-	args := []interface{}{product.Name, product.CategoryId, product.Price, product.Description, product.Amount}
-	fmt.Println(args...)
+// VariantAttributes are what sets a variant apart from its siblings, such as
+// {"size": "L", "color": "red"}. They are stored as JSONB.
+type VariantAttributes map[string]string
+
+func (a VariantAttributes) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *VariantAttributes) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*a = VariantAttributes{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into VariantAttributes", src)
+	}
+	return json.Unmarshal(data, a)
+}
+
+// productColumns is the column list every product query selects, barcodes included.
+const productColumns = `p.id, p.name, p.category_id, p.price, p.cost_price, p.description, p.amount, p.min_stock, p.reorder_qty,
+	p.tax_rate, COALESCE(p.sku, ''), p.parent_id, p.attributes,
+	ARRAY(SELECT b.barcode FROM product_barcodes b WHERE b.product_id = p.id ORDER BY b.barcode),
+	p.version, p.created_at, p.updated_at`
+
+func ValidateProduct(v *validator.Validator, product *Product) {
+	v.Check(product.Amount >= 0, "amount", "must not be negative")
+	v.Check(product.MinStock >= 0, "minStock", "must not be negative")
+	v.Check(product.ReorderQty >= 0, "reorderQty", "must not be negative")
+	v.Check(len(product.Sku) <= 64, "sku", "must not be more than 64 bytes long")
+	if product.ParentId != nil {
+		v.Check(*product.ParentId > 0, "parentId", "must be a positive integer")
+		v.Check(len(product.Attributes) > 0, "attributes", "must be provided for a variant")
+	}
+	for i, code := range product.Barcodes {
+		v.Check(ValidBarcode(code), fmt.Sprintf("barcodes[%d]", i), "must be a valid EAN-8, UPC-A or EAN-13 code")
+	}
+}
+
+// Create inserts the product with no stock; a non-zero Amount is booked as the opening
+// balance of its stock ledger.
+func (p ProductModule) Create(product *Product, by AuditActor) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	fmt.Println("Buy From Product Module")
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.Id)
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertProduct(ctx, tx, product); err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, by, AuditCreate, AuditProduct, product.Id, nil, product); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (p ProductModule) Get(id int) (*Product, error) {
-	query := `
-			SELECT * FROM products 
-			WHERE id = $1
-			`
-
-	var product Product
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := p.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&product.Id, &product.Name, &product.CategoryId,
-		&product.Price, &product.Description, &product.Amount, &product.CreatedAt, &product.UpdatedAt)
+	return getProduct(ctx, p.DB, id, false)
+}
+
+// GetVariants returns the variants of a product.
+func (p ProductModule) GetVariants(id int) ([]Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
+	var exists bool
+	err := p.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`, id).Scan(&exists)
 	if err != nil {
 		return nil, err
 	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
 
-	return &product, nil
+	return getVariants(ctx, p.DB, id)
+}
+
+// GetByBarcode finds the product a scanned code belongs to. UPC-A codes match their
+// EAN-13 form and vice versa.
+func (p ProductModule) GetByBarcode(code string) (*Product, error) {
+	query := `
+			SELECT ` + productColumns + `
+			FROM product_barcodes pb
+			INNER JOIN products p ON p.id = pb.product_id
+			WHERE pb.barcode = $1
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanProduct(p.DB.QueryRowContext(ctx, query, normalizeBarcode(code)))
 }
 
+// GetAll returns a page of products matching name. A non-zero category restricts the
+// page to that category and all of its subcategories.
 func (p ProductModule) GetAll(name string, category int, filters Filters) (*[]Product, Metadata, error) {
 	query := fmt.Sprintf(`
-			SELECT count(*) OVER(), * from products
-			WHERE (to_tsvector('simple', name ) @@ plainto_tsquery('simple', $1) OR $1 = '')
-			AND (category_id = $2 OR $2 = 1)
-			ORDER BY %s %s, id ASC
+			SELECT count(*) OVER(), ` + productColumns + `
+			FROM products p
+			WHERE (to_tsvector('simple', p.name ) @@ plainto_tsquery('simple', $1) OR $1 = '')
+			AND ($2 = 0 OR p.category_id IN (
+				WITH RECURSIVE subtree AS (
+					SELECT id FROM categories WHERE id = $2
+					UNION ALL
+					SELECT c.id FROM categories c INNER JOIN subtree s ON c.parent_id = s.id
+				)
+				SELECT id FROM subtree
+			))
+			ORDER BY p.%s %s, p.id ASC
 			LIMIT $3 OFFSET $4
 	`, filters.sortColumn(), filters.sortDirection())
 
@@ -83,7 +200,9 @@ func (p ProductModule) GetAll(name string, category int, filters Filters) (*[]Pr
 
 	for rows.Next() {
 		var prd Product
-		err := rows.Scan(&totalRecords, &prd.Id, &prd.Name, &prd.CategoryId, &prd.Price, &prd.Description, &prd.Amount, &prd.CreatedAt, &prd.UpdatedAt)
+		err := rows.Scan(&totalRecords, &prd.Id, &prd.Name, &prd.CategoryId, &prd.Price, &prd.CostPrice, &prd.Description, &prd.Amount,
+			&prd.MinStock, &prd.ReorderQty, &prd.TaxRate,
+			&prd.Sku, &prd.ParentId, &prd.Attributes, pq.Array(&prd.Barcodes), &prd.Version, &prd.CreatedAt, &prd.UpdatedAt)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -97,21 +216,43 @@ func (p ProductModule) GetAll(name string, category int, filters Filters) (*[]Pr
 	return &products, metadata, nil
 }
 
-func (p ProductModule) Update(id int, product *Product) error {
-	query := `
-			UPDATE products
-			SET name = $1, category_id = $2, price = $3, description = $4, amount = $5
-			WHERE id = $6
-			RETURNING updated_at
-			`
-	args := []interface{}{product.Name, product.CategoryId, product.Price, product.Description, product.Amount, id}
+// Update replaces the product, its SKU and its barcodes. The amount is left alone:
+// stock only changes through the stock ledger and the cost price through goods
+// receipts, and both are returned as they are. product.Version must be the version
+// the change was made against; if the product changed since, ErrEditConflict is
+// returned with product.Version set to the current version.
+func (p ProductModule) Update(id int, product *Product, by AuditActor) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.UpdatedAt)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getProduct(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+	if err := updateProduct(ctx, tx, id, product); err != nil {
+		return err
+	}
+
+	after, err := getProduct(ctx, tx, id, false)
+	if err != nil {
+		return err
+	}
+	if err := insertAudit(ctx, tx, by, AuditUpdate, AuditProduct, id, before, after); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (p ProductModule) Delete(id int) error {
+// Delete removes a product without variants that was never stocked or sold; others
+// fail with ErrProductHasVariants or ErrProductHasHistory.
+func (p ProductModule) Delete(id int, by AuditActor) error {
 	query := `
 			DELETE FROM products
 			WHERE id = $1
@@ -119,6 +260,275 @@ func (p ProductModule) Delete(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := p.DB.ExecContext(ctx, query, id)
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getProduct(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Constraint == "products_parent_id_fkey":
+			return ErrProductHasVariants
+		case pqErr.Code == "23503":
+			return ErrProductHasHistory
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := insertAudit(ctx, tx, by, AuditDelete, AuditProduct, id, before, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getProduct loads a product with its variants. With forUpdate set the product row
+// is locked until the surrounding transaction ends.
+func getProduct(ctx context.Context, q queryer, id int, forUpdate bool) (*Product, error) {
+	query := `
+			SELECT ` + productColumns + `
+			FROM products p
+			WHERE p.id = $1
+			`
+	if forUpdate {
+		query += " FOR UPDATE OF p"
+	}
+
+	product, err := scanProduct(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if product.ParentId == nil {
+		product.Variants, err = getVariants(ctx, q, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return product, nil
+}
+
+func scanProduct(row *sql.Row) (*Product, error) {
+	var product Product
+	err := row.Scan(&product.Id, &product.Name, &product.CategoryId, &product.Price, &product.CostPrice, &product.Description, &product.Amount,
+		&product.MinStock, &product.ReorderQty, &product.TaxRate, &product.Sku,
+		&product.ParentId, &product.Attributes, pq.Array(&product.Barcodes), &product.Version, &product.CreatedAt, &product.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+// insertProduct is Create on a transaction.
+func insertProduct(ctx context.Context, q queryer, product *Product) error {
+	query := `
+			INSERT INTO products (name, category_id, price, description, amount, min_stock, reorder_qty, tax_rate, sku, parent_id, attributes)
+			VALUES ($1, $2, $3, $4, 0, $5, $6, $7, NULLIF($8, ''), $9, $10)
+			RETURNING id, version, created_at, updated_at
+			`
+	args := []interface{}{product.Name, product.CategoryId, product.Price, product.Description, product.MinStock, product.ReorderQty,
+		product.TaxRate, product.Sku, product.ParentId, product.Attributes}
+
+	if err := checkParent(ctx, q, 0, product.ParentId); err != nil {
+		return err
+	}
+
+	err := q.QueryRowContext(ctx, query, args...).Scan(&product.Id, &product.Version, &product.CreatedAt, &product.UpdatedAt)
+	if err != nil {
+		return productError(err)
+	}
+
+	if err := setBarcodes(ctx, q, product); err != nil {
+		return err
+	}
+
+	if product.Amount != 0 {
+		movement := StockMovement{ProductId: product.Id, Type: StockAdjustment, Qty: product.Amount, Reason: "opening balance"}
+		if err := moveStock(ctx, q, &movement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateProduct is Update on a transaction.
+func updateProduct(ctx context.Context, q queryer, id int, product *Product) error {
+	query := `
+			UPDATE products
+			SET name = $1, category_id = $2, price = $3, description = $4, min_stock = $5, reorder_qty = $6, tax_rate = $7,
+				sku = NULLIF($8, ''), parent_id = $9, attributes = $10, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $11 AND version = $12
+			RETURNING amount, cost_price, version, created_at, updated_at
+			`
+	args := []interface{}{product.Name, product.CategoryId, product.Price, product.Description, product.MinStock, product.ReorderQty,
+		product.TaxRate, product.Sku, product.ParentId, product.Attributes, id, product.Version}
+
+	if err := checkParent(ctx, q, id, product.ParentId); err != nil {
+		return err
+	}
+
+	err := q.QueryRowContext(ctx, query, args...).Scan(&product.Amount, &product.CostPrice, &product.Version, &product.CreatedAt, &product.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return editConflict(ctx, q, "products", id, &product.Version)
+		}
+		return productError(err)
+	}
+
+	product.Id = id
+	return setBarcodes(ctx, q, product)
+}
+
+func getVariants(ctx context.Context, q queryer, parentId int) ([]Product, error) {
+	query := `
+		SELECT ` + productColumns + `
+		FROM products p
+		WHERE p.parent_id = $1
+		ORDER BY p.id
+	`
+	rows, err := q.QueryContext(ctx, query, parentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []Product{}
+	for rows.Next() {
+		var v Product
+		err := rows.Scan(&v.Id, &v.Name, &v.CategoryId, &v.Price, &v.CostPrice, &v.Description, &v.Amount,
+			&v.MinStock, &v.ReorderQty, &v.TaxRate, &v.Sku, &v.ParentId, &v.Attributes, pq.Array(&v.Barcodes),
+			&v.Version, &v.CreatedAt, &v.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+
+	return variants, rows.Err()
+}
+
+// checkParent makes sure a product with the given id (0 for a new one) may be a
+// variant of parentId: variants are one level deep, so the parent must exist and not
+// be a variant itself, and a product that has variants cannot become one.
+func checkParent(ctx context.Context, q queryer, id int, parentId *int) error {
+	if parentId == nil {
+		return nil
+	}
+	if *parentId == id {
+		return fmt.Errorf("%w: a product cannot be its own variant", ErrInvalidParent)
+	}
+
+	var grandparent *int
+	err := q.QueryRowContext(ctx, `SELECT parent_id FROM products WHERE id = $1`, *parentId).Scan(&grandparent)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: product %d does not exist", ErrInvalidParent, *parentId)
+		}
+		return err
+	}
+	if grandparent != nil {
+		return fmt.Errorf("%w: product %d is itself a variant", ErrInvalidParent, *parentId)
+	}
+
+	if id > 0 {
+		var hasVariants bool
+		err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE parent_id = $1)`, id).Scan(&hasVariants)
+		if err != nil {
+			return err
+		}
+		if hasVariants {
+			return fmt.Errorf("%w: product %d has variants of its own", ErrInvalidParent, id)
+		}
+	}
+
+	return nil
+}
+
+// setBarcodes replaces the barcodes of the product with its Barcodes, stored in their
+// normalized form.
+func setBarcodes(ctx context.Context, q queryer, product *Product) error {
+	_, err := q.ExecContext(ctx, `DELETE FROM product_barcodes WHERE product_id = $1`, product.Id)
+	if err != nil {
+		return err
+	}
+
+	codes := []string{}
+	for _, code := range product.Barcodes {
+		codes = append(codes, normalizeBarcode(code))
+	}
+
+	query := `
+		INSERT INTO product_barcodes (barcode, product_id)
+		SELECT DISTINCT unnest($1::TEXT[]), $2
+	`
+	if _, err := q.ExecContext(ctx, query, pq.Array(codes), product.Id); err != nil {
+		return productError(err)
+	}
+
+	product.Barcodes = codes
+	return nil
+}
+
+// productError maps unique violations on products and barcodes to model errors.
+func productError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Constraint {
+		case "product_barcodes_pkey":
+			return ErrDuplicateBarcode
+		case "products_sku_key":
+			return ErrDuplicateSku
+		}
+	}
 	return err
 }
+
+// ValidBarcode reports whether code is an EAN-8, UPC-A or EAN-13 code with a correct
+// GS1 check digit.
+func ValidBarcode(code string) bool {
+	if n := len(code); n != 8 && n != 12 && n != 13 {
+		return false
+	}
+
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		d := code[i]
+		if d < '0' || d > '9' {
+			return false
+		}
+		// Weights alternate 3, 1, 3, ... leftwards from the digit next to the check digit.
+		if (len(code)-i)%2 == 0 {
+			sum += 3 * int(d-'0')
+		} else {
+			sum += int(d - '0')
+		}
+	}
+
+	check := code[len(code)-1]
+	return check >= '0' && check <= '9' && (10-sum%10)%10 == int(check-'0')
+}
+
+// normalizeBarcode stores UPC-A codes in their EAN-13 form, so a scanner reporting
+// either matches the same product.
+func normalizeBarcode(code string) string {
+	if len(code) == 12 {
+		return "0" + code
+	}
+	return code
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"testing"
+
+	"pos-rs/pkg/pos/validator"
+)
+
+func TestValidBarcode(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"4006381333931", true},
+		{"5901234123457", true},
+		{"4006381333932", false},
+		{"036000291452", true},
+		{"036000291453", false},
+		{"96385074", true},
+		{"96385075", false},
+		{"00000000", true},
+		{"400638133393", false},
+		{"40063813339311", false},
+		{"", false},
+		{"400638133393A", false},
+		{"40063813339A1", false},
+		{" 006381333931", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidBarcode(tt.code); got != tt.want {
+			t.Errorf("ValidBarcode(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeBarcode(t *testing.T) {
+	tests := []struct {
+		code, want string
+	}{
+		{"036000291452", "0036000291452"},
+		{"4006381333931", "4006381333931"},
+		{"96385074", "96385074"},
+	}
+
+	for _, tt := range tests {
+		got := normalizeBarcode(tt.code)
+		if got != tt.want {
+			t.Errorf("normalizeBarcode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+		if !ValidBarcode(got) {
+			t.Errorf("normalizeBarcode(%q) = %q is not a valid barcode", tt.code, got)
+		}
+	}
+}
+
+func TestValidateProductBarcodes(t *testing.T) {
+	v := validator.New()
+	ValidateProduct(v, &Product{Barcodes: []string{"4006381333931", "036000291453", "96385074"}})
+
+	if len(v.Errors) != 1 {
+		t.Fatalf("errors = %v, want one for barcodes[1]", v.Errors)
+	}
+	if _, ok := v.Errors["barcodes[1]"]; !ok {
+		t.Errorf("errors = %v, want one for barcodes[1]", v.Errors)
+	}
+}
+
+func TestValidateProductAmount(t *testing.T) {
+	v := validator.New()
+	ValidateProduct(v, &Product{Amount: -1})
+
+	if _, ok := v.Errors["amount"]; !ok {
+		t.Errorf("errors = %v, want one for amount", v.Errors)
+	}
+}
@@ -0,0 +1,388 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	PromotionLinePercent     = "line_percent"
+	PromotionLineFixed       = "line_fixed"
+	PromotionCategoryPercent = "category_percent"
+	PromotionOrderPercent    = "order_percent"
+	PromotionOrderFixed      = "order_fixed"
+	PromotionBuyXGetY        = "buy_x_get_y"
+)
+
+var PromotionTypes = []string{
+	PromotionLinePercent,
+	PromotionLineFixed,
+	PromotionCategoryPercent,
+	PromotionOrderPercent,
+	PromotionOrderFixed,
+	PromotionBuyXGetY,
+}
+
+// PermissionManualDiscount is the permission code an employee needs to give manual
+// line discounts.
+const PermissionManualDiscount = "discounts:manual"
+
+// Promotion is a rule the pricing engine applies to orders. Value is the percentage
+// of the *_percent types and Amount the discount of the *_fixed types; line_fixed
+// takes it off every unit. category_percent covers CategoryId and its subcategories.
+// buy_x_get_y gives GetQty units of GetProductId (or of ProductId itself) free for
+// every BuyQty units of ProductId.
+type Promotion struct {
+	Id           int        `json:"id"`
+	Name         string     `json:"name"`
+	Type         string     `json:"type"`
+	Value        float64    `json:"value"`
+	Amount       Money      `json:"amount"`
+	ProductId    *int       `json:"product_id"`
+	CategoryId   *int       `json:"category_id"`
+	BuyQty       int        `json:"buy_qty"`
+	GetQty       int        `json:"get_qty"`
+	GetProductId *int       `json:"get_product_id"`
+	MinSubtotal  Money      `json:"min_subtotal"`
+	Active       bool       `json:"active"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// categoryIds is the subtree of CategoryId, loaded for pricing. Without it only
+	// CategoryId itself matches.
+	categoryIds map[int]bool
+}
+
+type PromotionModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidatePromotion(v *validator.Validator, p *Promotion) {
+	v.Check(p.Name != "", "name", "must be provided")
+	v.Check(validator.In(p.Type, PromotionTypes...), "type", "must be a known promotion type")
+	v.Check(!p.MinSubtotal.IsNegative(), "min_subtotal", "must not be negative")
+	v.Check(p.StartsAt == nil || p.EndsAt == nil || p.EndsAt.After(*p.StartsAt), "ends_at", "must be after starts_at")
+
+	switch p.Type {
+	case PromotionLinePercent, PromotionOrderPercent, PromotionCategoryPercent:
+		v.Check(p.Value > 0 && p.Value <= 100, "value", "must be between 0 and 100")
+		v.Check(p.Amount.IsZero(), "amount", "must not be set for a percentage promotion")
+	case PromotionLineFixed, PromotionOrderFixed:
+		v.Check(p.Amount.IsPositive(), "amount", "must be greater than zero")
+		v.Check(p.Value == 0, "value", "must not be set for a fixed promotion")
+	case PromotionBuyXGetY:
+		v.Check(p.BuyQty > 0, "buy_qty", "must be greater than zero")
+		v.Check(p.GetQty > 0, "get_qty", "must be greater than zero")
+	}
+
+	switch p.Type {
+	case PromotionLinePercent, PromotionLineFixed, PromotionBuyXGetY:
+		v.Check(p.ProductId != nil, "product_id", "must be provided")
+	case PromotionCategoryPercent:
+		v.Check(p.CategoryId != nil, "category_id", "must be provided")
+	}
+}
+
+func (m PromotionModel) Create(p *Promotion) error {
+	query := `
+		INSERT INTO promotions (name, type, value, amount, product_id, category_id, buy_qty, get_qty, get_product_id, min_subtotal, active, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at, updated_at
+	`
+	args := []interface{}{p.Name, p.Type, p.Value, p.Amount, p.ProductId, p.CategoryId, p.BuyQty, p.GetQty, p.GetProductId, p.MinSubtotal, p.Active, p.StartsAt, p.EndsAt}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&p.Id, &p.CreatedAt, &p.UpdatedAt)
+}
+
+func (m PromotionModel) Get(id int) (*Promotion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	promotions, err := getPromotions(ctx, m.DB, `WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(promotions) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return &promotions[0], nil
+}
+
+func (m PromotionModel) GetAll() ([]Promotion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getPromotions(ctx, m.DB, `ORDER BY id`)
+}
+
+func (m PromotionModel) Update(id int, p *Promotion) error {
+	query := `
+		UPDATE promotions
+		SET name = $1, type = $2, value = $3, amount = $4, product_id = $5, category_id = $6, buy_qty = $7, get_qty = $8,
+			get_product_id = $9, min_subtotal = $10, active = $11, starts_at = $12, ends_at = $13, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $14
+		RETURNING created_at, updated_at
+	`
+	args := []interface{}{p.Name, p.Type, p.Value, p.Amount, p.ProductId, p.CategoryId, p.BuyQty, p.GetQty, p.GetProductId, p.MinSubtotal, p.Active, p.StartsAt, p.EndsAt, id}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	p.Id = id
+	return err
+}
+
+func (m PromotionModel) Delete(id int) error {
+	query := `
+			DELETE FROM promotions
+			WHERE id = $1
+			`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+func getPromotions(ctx context.Context, q queryer, where string, args ...interface{}) ([]Promotion, error) {
+	query := `
+		SELECT id, name, type, value, amount, product_id, category_id, buy_qty, get_qty, get_product_id, min_subtotal, active, starts_at, ends_at, created_at, updated_at
+		FROM promotions
+	` + where
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := []Promotion{}
+	for rows.Next() {
+		var p Promotion
+		err := rows.Scan(&p.Id, &p.Name, &p.Type, &p.Value, &p.Amount, &p.ProductId, &p.CategoryId, &p.BuyQty, &p.GetQty, &p.GetProductId,
+			&p.MinSubtotal, &p.Active, &p.StartsAt, &p.EndsAt, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+// priceOrder loads the promotions valid right now and applies them to the order,
+// then computes its taxes.
+func priceOrder(ctx context.Context, q queryer, order *Order) error {
+	return priceOrderAt(ctx, q, order, time.Now())
+}
+
+// priceOrderAt is priceOrder with the promotions that were valid at the given time.
+func priceOrderAt(ctx context.Context, q queryer, order *Order, at time.Time) error {
+	promotions, err := getPromotions(ctx, q, `
+		WHERE active
+		AND (starts_at IS NULL OR starts_at <= $1)
+		AND (ends_at IS NULL OR ends_at > $1)
+		ORDER BY id
+	`, at)
+	if err != nil {
+		return err
+	}
+	if err := loadPromotionCategories(ctx, q, promotions); err != nil {
+		return err
+	}
+
+	applyPromotions(order, promotions)
+	applyTaxes(order)
+	return nil
+}
+
+// loadPromotionCategories loads the category subtrees of the category promotions.
+func loadPromotionCategories(ctx context.Context, q queryer, promotions []Promotion) error {
+	roots := []int{}
+	for _, p := range promotions {
+		if p.Type == PromotionCategoryPercent && p.CategoryId != nil {
+			roots = append(roots, *p.CategoryId)
+		}
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id AS root_id, id FROM categories WHERE id = ANY($1)
+			UNION ALL
+			SELECT s.root_id, c.id FROM categories c INNER JOIN subtree s ON c.parent_id = s.id
+		)
+		SELECT root_id, id FROM subtree
+	`
+	rows, err := q.QueryContext(ctx, query, pq.Array(roots))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	subtrees := make(map[int]map[int]bool)
+	for rows.Next() {
+		var root, id int
+		if err := rows.Scan(&root, &id); err != nil {
+			return err
+		}
+		if subtrees[root] == nil {
+			subtrees[root] = make(map[int]bool)
+		}
+		subtrees[root][id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range promotions {
+		if p := &promotions[i]; p.Type == PromotionCategoryPercent && p.CategoryId != nil {
+			p.categoryIds = subtrees[*p.CategoryId]
+		}
+	}
+	return nil
+}
+
+// coversCategory reports whether the category promotion applies to products of
+// category id.
+func (p *Promotion) coversCategory(id int) bool {
+	if p.categoryIds != nil {
+		return p.categoryIds[id]
+	}
+	return intEquals(p.CategoryId, id)
+}
+
+// applyPromotions prices the order. Every line gets the single best discount out of
+// its manual discount, the line, category and buy-x-get-y promotions; a manual
+// discount always wins. The best order-level promotion is then taken off the
+// discounted subtotal. Gift cards sell at face value and are never discounted.
+func applyPromotions(order *Order, promotions []Promotion) {
+	qtyByProduct := make(map[int]int)
+	for i := range order.Products {
+		line := &order.Products[i]
+		line.TotalNormalPrice = line.Price.Times(line.Qty)
+		line.Discount = Money{}
+		line.PromotionId = nil
+
+		if line.GiftCardId != nil {
+			continue
+		}
+		if line.ManualDiscount.IsPositive() {
+			line.Discount = line.ManualDiscount.Min(line.TotalNormalPrice)
+			continue
+		}
+		qtyByProduct[line.ProductId] += line.Qty
+
+		for j := range promotions {
+			p := &promotions[j]
+			base := line.TotalNormalPrice
+
+			var discount Money
+			switch {
+			case p.Type == PromotionLinePercent && intEquals(p.ProductId, line.ProductId):
+				discount = base.Percent(p.Value)
+			case p.Type == PromotionLineFixed && intEquals(p.ProductId, line.ProductId):
+				discount = p.Amount.Times(line.Qty).Min(base)
+			case p.Type == PromotionCategoryPercent && p.coversCategory(line.Product.CategoryId):
+				discount = base.Percent(p.Value)
+			}
+
+			if discount.Cmp(line.Discount) > 0 {
+				line.Discount = discount
+				line.PromotionId = &p.Id
+			}
+		}
+	}
+
+	for j := range promotions {
+		p := &promotions[j]
+		if p.Type != PromotionBuyXGetY || p.ProductId == nil {
+			continue
+		}
+
+		getProduct := *p.ProductId
+		var free int
+		if p.GetProductId == nil || *p.GetProductId == getProduct {
+			free = qtyByProduct[getProduct] / (p.BuyQty + p.GetQty) * p.GetQty
+		} else {
+			getProduct = *p.GetProductId
+			free = qtyByProduct[*p.ProductId] / p.BuyQty * p.GetQty
+		}
+
+		for i := range order.Products {
+			line := &order.Products[i]
+			if free == 0 {
+				break
+			}
+			if line.ProductId != getProduct || line.ManualDiscount.IsPositive() || line.GiftCardId != nil {
+				continue
+			}
+
+			units := line.Qty
+			if units > free {
+				units = free
+			}
+
+			// Units of a line that keeps a larger discount are not given away free, so
+			// they stay available for the next line.
+			if discount := line.Price.Times(units); discount.Cmp(line.Discount) > 0 {
+				line.Discount = discount
+				line.PromotionId = &p.Id
+				free -= units
+			}
+		}
+	}
+
+	subtotal := order.subtotal()
+	discountable := order.discountable()
+
+	order.Discount = Money{}
+	order.PromotionId = nil
+	for j := range promotions {
+		p := &promotions[j]
+		if discountable.Cmp(p.MinSubtotal) < 0 {
+			continue
+		}
+
+		var discount Money
+		switch p.Type {
+		case PromotionOrderPercent:
+			discount = discountable.Percent(p.Value)
+		case PromotionOrderFixed:
+			discount = p.Amount.Min(discountable)
+		}
+
+		if discount.Cmp(order.Discount) > 0 {
+			order.Discount = discount
+			order.PromotionId = &p.Id
+		}
+	}
+
+	order.TotalPrice = subtotal.Sub(order.Discount)
+}
+
+func intEquals(p *int, v int) bool {
+	return p != nil && *p == v
+}
@@ -0,0 +1,220 @@
+package model
+
+import "testing"
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// testLine is a line of qty units of product at price minor units, in category.
+func testLine(product, category int, price int64, qty int) OrderProduct {
+	return OrderProduct{
+		ProductId: product,
+		Qty:       qty,
+		Price:     NewMoney(price),
+		Product:   Product{Id: product, CategoryId: category},
+	}
+}
+
+func TestApplyPromotions(t *testing.T) {
+	giftCard := testLine(9, 0, 5000, 1)
+	giftCard.GiftCardId = intPtr(1)
+
+	subtree := Promotion{Id: 1, Type: PromotionCategoryPercent, Value: 25, CategoryId: intPtr(5), categoryIds: map[int]bool{5: true, 7: true}}
+
+	manual := testLine(1, 0, 1000, 1)
+	manual.ManualDiscount = NewMoney(50)
+
+	manualOverLine := testLine(1, 0, 1000, 1)
+	manualOverLine.ManualDiscount = NewMoney(5000)
+
+	tests := []struct {
+		name       string
+		lines      []OrderProduct
+		promotions []Promotion
+		// wantLines are the discount and promotion id (0 for none) of every line.
+		wantLines     [][2]int64
+		wantDiscount  int64
+		wantPromotion int
+		wantTotal     int64
+	}{
+		{
+			name:      "no promotions",
+			lines:     []OrderProduct{testLine(1, 0, 1000, 2)},
+			wantLines: [][2]int64{{0, 0}},
+			wantTotal: 2000,
+		},
+		{
+			name:       "line percent",
+			lines:      []OrderProduct{testLine(1, 0, 1000, 2), testLine(2, 0, 500, 1)},
+			promotions: []Promotion{{Id: 1, Type: PromotionLinePercent, Value: 10, ProductId: intPtr(1)}},
+			wantLines:  [][2]int64{{200, 1}, {0, 0}},
+			wantTotal:  2300,
+		},
+		{
+			name:  "best line promotion wins",
+			lines: []OrderProduct{testLine(1, 0, 1000, 2)},
+			promotions: []Promotion{
+				{Id: 1, Type: PromotionLinePercent, Value: 10, ProductId: intPtr(1)},
+				{Id: 2, Type: PromotionLineFixed, Amount: NewMoney(150), ProductId: intPtr(1)},
+			},
+			wantLines: [][2]int64{{300, 2}},
+			wantTotal: 1700,
+		},
+		{
+			name:       "line fixed is capped at the line",
+			lines:      []OrderProduct{testLine(1, 0, 1000, 1)},
+			promotions: []Promotion{{Id: 1, Type: PromotionLineFixed, Amount: NewMoney(1500), ProductId: intPtr(1)}},
+			wantLines:  [][2]int64{{1000, 1}},
+			wantTotal:  0,
+		},
+		{
+			name:       "category percent",
+			lines:      []OrderProduct{testLine(1, 5, 400, 1), testLine(2, 6, 400, 1)},
+			promotions: []Promotion{{Id: 1, Type: PromotionCategoryPercent, Value: 25, CategoryId: intPtr(5)}},
+			wantLines:  [][2]int64{{100, 1}, {0, 0}},
+			wantTotal:  700,
+		},
+		{
+			name:       "category percent covers subcategories",
+			lines:      []OrderProduct{testLine(1, 7, 400, 1), testLine(2, 6, 400, 1)},
+			promotions: []Promotion{subtree},
+			wantLines:  [][2]int64{{100, 1}, {0, 0}},
+			wantTotal:  700,
+		},
+		{
+			name:       "manual discount wins over a better promotion",
+			lines:      []OrderProduct{manual},
+			promotions: []Promotion{{Id: 1, Type: PromotionLinePercent, Value: 50, ProductId: intPtr(1)}},
+			wantLines:  [][2]int64{{50, 0}},
+			wantTotal:  950,
+		},
+		{
+			name:      "manual discount is capped at the line",
+			lines:     []OrderProduct{manualOverLine},
+			wantLines: [][2]int64{{1000, 0}},
+			wantTotal: 0,
+		},
+		{
+			name:       "buy two get one of the same product",
+			lines:      []OrderProduct{testLine(1, 0, 100, 7)},
+			promotions: []Promotion{{Id: 1, Type: PromotionBuyXGetY, ProductId: intPtr(1), BuyQty: 2, GetQty: 1}},
+			wantLines:  [][2]int64{{200, 1}},
+			wantTotal:  500,
+		},
+		{
+			name:  "buy two get one of another product",
+			lines: []OrderProduct{testLine(1, 0, 100, 4), testLine(2, 0, 300, 3)},
+			promotions: []Promotion{
+				{Id: 1, Type: PromotionBuyXGetY, ProductId: intPtr(1), BuyQty: 2, GetQty: 1, GetProductId: intPtr(2)},
+			},
+			wantLines: [][2]int64{{0, 0}, {600, 1}},
+			wantTotal: 700,
+		},
+		{
+			name:  "free units are spread over the lines of the product",
+			lines: []OrderProduct{testLine(1, 0, 100, 2), testLine(1, 0, 100, 4)},
+			promotions: []Promotion{
+				{Id: 1, Type: PromotionBuyXGetY, ProductId: intPtr(1), BuyQty: 1, GetQty: 1},
+			},
+			wantLines: [][2]int64{{200, 1}, {100, 1}},
+			wantTotal: 300,
+		},
+		{
+			name:  "free units go to the next line when a line keeps its discount",
+			lines: []OrderProduct{testLine(1, 0, 100, 1), testLine(2, 10, 300, 1), testLine(2, 0, 300, 1)},
+			promotions: []Promotion{
+				{Id: 1, Type: PromotionBuyXGetY, ProductId: intPtr(1), BuyQty: 1, GetQty: 1, GetProductId: intPtr(2)},
+				{Id: 2, Type: PromotionCategoryPercent, Value: 100, CategoryId: intPtr(10)},
+			},
+			wantLines: [][2]int64{{0, 0}, {300, 2}, {300, 1}},
+			wantTotal: 100,
+		},
+		{
+			name:          "order percent above the minimum subtotal",
+			lines:         []OrderProduct{testLine(1, 0, 1000, 2)},
+			promotions:    []Promotion{{Id: 1, Type: PromotionOrderPercent, Value: 10, MinSubtotal: NewMoney(2000)}},
+			wantLines:     [][2]int64{{0, 0}},
+			wantDiscount:  200,
+			wantPromotion: 1,
+			wantTotal:     1800,
+		},
+		{
+			name:       "order percent below the minimum subtotal",
+			lines:      []OrderProduct{testLine(1, 0, 1000, 2)},
+			promotions: []Promotion{{Id: 1, Type: PromotionOrderPercent, Value: 10, MinSubtotal: NewMoney(2001)}},
+			wantLines:  [][2]int64{{0, 0}},
+			wantTotal:  2000,
+		},
+		{
+			name:  "best order promotion wins",
+			lines: []OrderProduct{testLine(1, 0, 1000, 2)},
+			promotions: []Promotion{
+				{Id: 1, Type: PromotionOrderPercent, Value: 10},
+				{Id: 2, Type: PromotionOrderFixed, Amount: NewMoney(300)},
+			},
+			wantLines:     [][2]int64{{0, 0}},
+			wantDiscount:  300,
+			wantPromotion: 2,
+			wantTotal:     1700,
+		},
+		{
+			name:  "gift cards are never discounted",
+			lines: []OrderProduct{giftCard, testLine(1, 0, 1000, 1)},
+			promotions: []Promotion{
+				{Id: 1, Type: PromotionLinePercent, Value: 50, ProductId: intPtr(9)},
+				{Id: 2, Type: PromotionOrderFixed, Amount: NewMoney(2000)},
+			},
+			wantLines:     [][2]int64{{0, 0}, {0, 0}},
+			wantDiscount:  1000,
+			wantPromotion: 2,
+			wantTotal:     5000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &Order{Products: append([]OrderProduct(nil), tt.lines...)}
+			applyPromotions(order, tt.promotions)
+
+			for i, line := range order.Products {
+				promotion := 0
+				if line.PromotionId != nil {
+					promotion = *line.PromotionId
+				}
+				want := tt.wantLines[i]
+				if line.Discount.Amount != want[0] || int64(promotion) != want[1] {
+					t.Errorf("line %d discount = %d by promotion %d, want %d by %d", i, line.Discount.Amount, promotion, want[0], want[1])
+				}
+			}
+
+			promotion := 0
+			if order.PromotionId != nil {
+				promotion = *order.PromotionId
+			}
+			if order.Discount.Amount != tt.wantDiscount || promotion != tt.wantPromotion {
+				t.Errorf("order discount = %d by promotion %d, want %d by %d", order.Discount.Amount, promotion, tt.wantDiscount, tt.wantPromotion)
+			}
+			if order.TotalPrice.Amount != tt.wantTotal {
+				t.Errorf("total = %d, want %d", order.TotalPrice.Amount, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestApplyPromotionsResetsPreviousPricing(t *testing.T) {
+	order := &Order{Products: []OrderProduct{testLine(1, 0, 1000, 1)}}
+	applyPromotions(order, []Promotion{
+		{Id: 1, Type: PromotionLinePercent, Value: 10, ProductId: intPtr(1)},
+		{Id: 2, Type: PromotionOrderFixed, Amount: NewMoney(100)},
+	})
+	applyPromotions(order, nil)
+
+	line := order.Products[0]
+	if !line.Discount.IsZero() || line.PromotionId != nil || !order.Discount.IsZero() || order.PromotionId != nil {
+		t.Errorf("repricing without promotions kept line %+v and order discount %d", line, order.Discount.Amount)
+	}
+	if order.TotalPrice.Amount != 1000 {
+		t.Errorf("total = %d, want 1000", order.TotalPrice.Amount)
+	}
+}
@@ -0,0 +1,542 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	PurchaseDraft             = "draft"
+	PurchaseSent              = "sent"
+	PurchasePartiallyReceived = "partially_received"
+	PurchaseReceived          = "received"
+	PurchaseCancelled         = "cancelled"
+)
+
+var PurchaseOrderStatuses = []string{
+	PurchaseDraft,
+	PurchaseSent,
+	PurchasePartiallyReceived,
+	PurchaseReceived,
+	PurchaseCancelled,
+}
+
+// purchaseTransitions lists the statuses a purchase order may be moved to by hand.
+// The received statuses are only reached through goods receipts.
+var purchaseTransitions = map[string][]string{
+	PurchaseDraft:             {PurchaseSent, PurchaseCancelled},
+	PurchaseSent:              {PurchaseCancelled},
+	PurchasePartiallyReceived: {PurchaseCancelled},
+}
+
+var (
+	// ErrPurchaseOrderStatus is returned when the status of a purchase order does not
+	// allow the requested change.
+	ErrPurchaseOrderStatus = errors.New("not allowed in the purchase order's status")
+
+	// ErrReceiptExceedsOrder is returned when more is received than was ordered.
+	ErrReceiptExceedsOrder = errors.New("receipt exceeds ordered quantity")
+
+	// ErrUnknownSupplier and ErrUnknownProduct are returned when a purchase order
+	// refers to a supplier or product that does not exist.
+	ErrUnknownSupplier = errors.New("supplier does not exist")
+	ErrUnknownProduct  = errors.New("product does not exist")
+)
+
+type PurchaseOrderLine struct {
+	Id              int   `json:"id"`
+	PurchaseOrderId int   `json:"purchase_order_id"`
+	ProductId       int   `json:"product_id"`
+	Qty             int   `json:"qty"`
+	ReceivedQty     int   `json:"received_qty"`
+	UnitCost        Money `json:"unit_cost"`
+}
+
+type PurchaseOrder struct {
+	Id         int                 `json:"id"`
+	SupplierId int                 `json:"supplier_id"`
+	EmployeeId int                 `json:"employee_id"`
+	Status     string              `json:"status"`
+	Note       string              `json:"note"`
+	Total      Money               `json:"total"`
+	Lines      []PurchaseOrderLine `json:"lines"`
+	SentAt     *time.Time          `json:"sent_at"`
+	ReceivedAt *time.Time          `json:"received_at"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+}
+
+// GoodsReceiptLine books Qty units of a purchase order line into stock. A zero
+// UnitCost takes the cost agreed on the purchase order.
+type GoodsReceiptLine struct {
+	Id        int   `json:"id"`
+	ReceiptId int   `json:"receipt_id"`
+	LineId    int   `json:"line_id"`
+	ProductId int   `json:"product_id"`
+	Qty       int   `json:"qty"`
+	UnitCost  Money `json:"unit_cost"`
+}
+
+type GoodsReceipt struct {
+	Id              int                `json:"id"`
+	PurchaseOrderId int                `json:"purchase_order_id"`
+	EmployeeId      int                `json:"employee_id"`
+	Note            string             `json:"note"`
+	Lines           []GoodsReceiptLine `json:"lines"`
+	CreatedAt       time.Time          `json:"created_at"`
+}
+
+type PurchaseOrderModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidatePurchaseOrder(v *validator.Validator, po *PurchaseOrder) {
+	v.Check(po.SupplierId > 0, "supplier_id", "must be provided")
+	v.Check(len(po.Lines) > 0, "lines", "must contain at least one line")
+	for i, l := range po.Lines {
+		v.Check(l.ProductId > 0, fmt.Sprintf("lines[%d].product_id", i), "must be provided")
+		v.Check(l.Qty > 0, fmt.Sprintf("lines[%d].qty", i), "must be greater than zero")
+		v.Check(!l.UnitCost.IsNegative(), fmt.Sprintf("lines[%d].unit_cost", i), "must not be negative")
+	}
+}
+
+func ValidateGoodsReceipt(v *validator.Validator, receipt *GoodsReceipt) {
+	v.Check(len(receipt.Lines) > 0, "lines", "must contain at least one line")
+	for i, l := range receipt.Lines {
+		v.Check(l.LineId > 0, fmt.Sprintf("lines[%d].line_id", i), "must be provided")
+		v.Check(l.Qty > 0, fmt.Sprintf("lines[%d].qty", i), "must be greater than zero")
+		v.Check(!l.UnitCost.IsNegative(), fmt.Sprintf("lines[%d].unit_cost", i), "must not be negative")
+	}
+}
+
+// Create saves a new purchase order as a draft.
+func (m PurchaseOrderModel) Create(po *PurchaseOrder) error {
+	query := `
+		INSERT INTO purchase_orders (supplier_id, employee_id, status, note)
+		VALUES ($1, NULLIF($2, 0), $3, $4)
+		RETURNING id, created_at, updated_at
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	po.Status = PurchaseDraft
+	err = tx.QueryRowContext(ctx, query, po.SupplierId, po.EmployeeId, po.Status, po.Note).Scan(&po.Id, &po.CreatedAt, &po.UpdatedAt)
+	if err != nil {
+		return purchaseOrderError(err)
+	}
+
+	if err := insertPurchaseOrderLines(ctx, tx, po); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PurchaseOrderModel) Get(id int) (*PurchaseOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getPurchaseOrder(ctx, m.DB, id, false)
+}
+
+// GetAll returns a page of purchase orders, optionally of one status or supplier.
+func (m PurchaseOrderModel) GetAll(status string, supplierId int, filters Filters) ([]PurchaseOrder, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, supplier_id, COALESCE(employee_id, 0), status, note, sent_at, received_at, created_at, updated_at
+		FROM purchase_orders
+		WHERE (status = $1 OR $1 = '')
+		AND (supplier_id = $2 OR $2 = 0)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, supplierId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	orders := []PurchaseOrder{}
+	for rows.Next() {
+		var po PurchaseOrder
+		err := rows.Scan(&totalRecords, &po.Id, &po.SupplierId, &po.EmployeeId, &po.Status, &po.Note,
+			&po.SentAt, &po.ReceivedAt, &po.CreatedAt, &po.UpdatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		orders = append(orders, po)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	for i := range orders {
+		orders[i].Lines, err = getPurchaseOrderLines(ctx, m.DB, orders[i].Id)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		orders[i].Total = purchaseOrderTotal(orders[i].Lines)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return orders, metadata, nil
+}
+
+// Update replaces the supplier, note and lines of a draft purchase order.
+func (m PurchaseOrderModel) Update(id int, po *PurchaseOrder) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := getPurchaseOrder(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+	if current.Status != PurchaseDraft {
+		return fmt.Errorf("%w: only draft orders can be edited, order %d is %s", ErrPurchaseOrderStatus, id, current.Status)
+	}
+
+	query := `
+		UPDATE purchase_orders
+		SET supplier_id = $1, note = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING updated_at
+	`
+	err = tx.QueryRowContext(ctx, query, po.SupplierId, po.Note, id).Scan(&po.UpdatedAt)
+	if err != nil {
+		return purchaseOrderError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM purchase_order_lines WHERE purchase_order_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	po.Id = id
+	po.EmployeeId = current.EmployeeId
+	po.Status = current.Status
+	po.CreatedAt = current.CreatedAt
+	if err := insertPurchaseOrderLines(ctx, tx, po); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetStatus sends or cancels a purchase order.
+func (m PurchaseOrderModel) SetStatus(id int, status string) (*PurchaseOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	po, err := getPurchaseOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !validator.In(status, purchaseTransitions[po.Status]...) {
+		return nil, fmt.Errorf("%w: cannot move order %d from %s to %s", ErrPurchaseOrderStatus, id, po.Status, status)
+	}
+
+	query := `
+		UPDATE purchase_orders
+		SET status = $1, sent_at = CASE WHEN $1 = 'sent' THEN CURRENT_TIMESTAMP ELSE sent_at END, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING sent_at, updated_at
+	`
+	err = tx.QueryRowContext(ctx, query, status, id).Scan(&po.SentAt, &po.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	po.Status = status
+
+	return po, tx.Commit()
+}
+
+// Receive books a goods receipt against a sent purchase order: every line goes into
+// stock as a receipt movement, the product's cost price becomes the weighted average
+// of the stock on hand and the received units, and the order becomes partially
+// received or received.
+func (m PurchaseOrderModel) Receive(id int, receipt *GoodsReceipt) (*PurchaseOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	po, err := getPurchaseOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	if po.Status != PurchaseSent && po.Status != PurchasePartiallyReceived {
+		return nil, fmt.Errorf("%w: order %d is %s", ErrPurchaseOrderStatus, id, po.Status)
+	}
+
+	query := `
+		INSERT INTO goods_receipts (purchase_order_id, employee_id, note)
+		VALUES ($1, NULLIF($2, 0), $3)
+		RETURNING id, created_at
+	`
+	receipt.PurchaseOrderId = id
+	err = tx.QueryRowContext(ctx, query, id, receipt.EmployeeId, receipt.Note).Scan(&receipt.Id, &receipt.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make(map[int]*PurchaseOrderLine, len(po.Lines))
+	for i := range po.Lines {
+		lines[po.Lines[i].Id] = &po.Lines[i]
+	}
+
+	for i := range receipt.Lines {
+		rl := &receipt.Lines[i]
+
+		line, ok := lines[rl.LineId]
+		if !ok {
+			return nil, fmt.Errorf("%w: line %d is not part of purchase order %d", ErrRecordNotFound, rl.LineId, id)
+		}
+
+		line.ReceivedQty += rl.Qty
+		if line.ReceivedQty > line.Qty {
+			return nil, fmt.Errorf("%w: line %d ordered %d, %d would be received", ErrReceiptExceedsOrder, line.Id, line.Qty, line.ReceivedQty)
+		}
+
+		rl.ReceiptId = receipt.Id
+		rl.ProductId = line.ProductId
+		if rl.UnitCost.IsZero() {
+			rl.UnitCost = line.UnitCost
+		}
+
+		query := `
+			INSERT INTO goods_receipt_lines (receipt_id, line_id, product_id, qty, unit_cost)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`
+		err := tx.QueryRowContext(ctx, query, rl.ReceiptId, rl.LineId, rl.ProductId, rl.Qty, rl.UnitCost).Scan(&rl.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE purchase_order_lines SET received_qty = $1 WHERE id = $2`, line.ReceivedQty, line.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		movement := StockMovement{
+			ProductId:  rl.ProductId,
+			Type:       StockReceipt,
+			Qty:        rl.Qty,
+			EmployeeId: receipt.EmployeeId,
+			Reason:     receipt.Note,
+			Reference:  fmt.Sprintf("purchase_order:%d", id),
+		}
+		if err := moveStock(ctx, tx, &movement); err != nil {
+			return nil, err
+		}
+
+		// The amount already includes the received units.
+		query = `
+			UPDATE products
+			SET cost_price = CASE WHEN amount - $2 <= 0 THEN $1
+				ELSE ROUND((cost_price * (amount - $2) + $1 * $2)::NUMERIC / amount) END
+			WHERE id = $3
+		`
+		_, err = tx.ExecContext(ctx, query, rl.UnitCost, rl.Qty, rl.ProductId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	po.Status = PurchaseReceived
+	for _, l := range po.Lines {
+		if l.ReceivedQty < l.Qty {
+			po.Status = PurchasePartiallyReceived
+			break
+		}
+	}
+
+	query = `
+		UPDATE purchase_orders
+		SET status = $1, received_at = CASE WHEN $1 = 'received' THEN CURRENT_TIMESTAMP END, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING received_at, updated_at
+	`
+	err = tx.QueryRowContext(ctx, query, po.Status, id).Scan(&po.ReceivedAt, &po.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return po, tx.Commit()
+}
+
+// GetReceipts returns the goods receipts booked against a purchase order.
+func (m PurchaseOrderModel) GetReceipts(id int) ([]GoodsReceipt, error) {
+	query := `
+		SELECT r.id, r.purchase_order_id, COALESCE(r.employee_id, 0), r.note, r.created_at,
+			l.id, l.line_id, l.product_id, l.qty, l.unit_cost
+		FROM goods_receipts r
+		INNER JOIN goods_receipt_lines l ON l.receipt_id = r.id
+		WHERE r.purchase_order_id = $1
+		ORDER BY r.id, l.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []GoodsReceipt{}
+	for rows.Next() {
+		var r GoodsReceipt
+		var l GoodsReceiptLine
+		err := rows.Scan(&r.Id, &r.PurchaseOrderId, &r.EmployeeId, &r.Note, &r.CreatedAt,
+			&l.Id, &l.LineId, &l.ProductId, &l.Qty, &l.UnitCost)
+		if err != nil {
+			return nil, err
+		}
+		l.ReceiptId = r.Id
+
+		if n := len(receipts); n > 0 && receipts[n-1].Id == r.Id {
+			receipts[n-1].Lines = append(receipts[n-1].Lines, l)
+			continue
+		}
+		r.Lines = []GoodsReceiptLine{l}
+		receipts = append(receipts, r)
+	}
+
+	return receipts, rows.Err()
+}
+
+func getPurchaseOrder(ctx context.Context, q queryer, id int, forUpdate bool) (*PurchaseOrder, error) {
+	query := `
+		SELECT id, supplier_id, COALESCE(employee_id, 0), status, note, sent_at, received_at, created_at, updated_at
+		FROM purchase_orders
+		WHERE id = $1
+	`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var po PurchaseOrder
+	err := q.QueryRowContext(ctx, query, id).Scan(&po.Id, &po.SupplierId, &po.EmployeeId, &po.Status, &po.Note,
+		&po.SentAt, &po.ReceivedAt, &po.CreatedAt, &po.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	po.Lines, err = getPurchaseOrderLines(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	po.Total = purchaseOrderTotal(po.Lines)
+
+	return &po, nil
+}
+
+func getPurchaseOrderLines(ctx context.Context, q queryer, id int) ([]PurchaseOrderLine, error) {
+	query := `
+		SELECT id, purchase_order_id, product_id, qty, received_qty, unit_cost
+		FROM purchase_order_lines
+		WHERE purchase_order_id = $1
+		ORDER BY id
+	`
+	rows, err := q.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []PurchaseOrderLine{}
+	for rows.Next() {
+		var l PurchaseOrderLine
+		if err := rows.Scan(&l.Id, &l.PurchaseOrderId, &l.ProductId, &l.Qty, &l.ReceivedQty, &l.UnitCost); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+
+	return lines, rows.Err()
+}
+
+func insertPurchaseOrderLines(ctx context.Context, q queryer, po *PurchaseOrder) error {
+	query := `
+		INSERT INTO purchase_order_lines (purchase_order_id, product_id, qty, unit_cost)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	for i := range po.Lines {
+		l := &po.Lines[i]
+		l.PurchaseOrderId = po.Id
+		l.ReceivedQty = 0
+
+		err := q.QueryRowContext(ctx, query, l.PurchaseOrderId, l.ProductId, l.Qty, l.UnitCost).Scan(&l.Id)
+		if err != nil {
+			return purchaseOrderError(err)
+		}
+	}
+
+	po.Total = purchaseOrderTotal(po.Lines)
+	return nil
+}
+
+// purchaseOrderError turns foreign key violations on the supplier or a product into
+// ErrUnknownSupplier and ErrUnknownProduct.
+func purchaseOrderError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Constraint {
+		case "purchase_orders_supplier_id_fkey":
+			return ErrUnknownSupplier
+		case "purchase_order_lines_product_id_fkey":
+			return ErrUnknownProduct
+		}
+	}
+	return err
+}
+
+func purchaseOrderTotal(lines []PurchaseOrderLine) Money {
+	var total Money
+	for _, l := range lines {
+		total = total.Add(l.UnitCost.Times(l.Qty))
+	}
+	return total
+}
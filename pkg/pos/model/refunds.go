@@ -0,0 +1,385 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+)
+
+const (
+	RefundReasonDefective     = "defective"
+	RefundReasonDamaged       = "damaged"
+	RefundReasonWrongItem     = "wrong_item"
+	RefundReasonChangedMind   = "changed_mind"
+	RefundReasonPriceMismatch = "price_mismatch"
+	RefundReasonOther         = "other"
+)
+
+// RefundStoreCredit refunds onto a store credit card instead of a tender.
+const RefundStoreCredit = "store_credit"
+
+var RefundReasons = []string{
+	RefundReasonDefective,
+	RefundReasonDamaged,
+	RefundReasonWrongItem,
+	RefundReasonChangedMind,
+	RefundReasonPriceMismatch,
+	RefundReasonOther,
+}
+
+var (
+	// ErrOrderNotPaid is returned when an operation needs a paid order.
+	ErrOrderNotPaid = errors.New("order is not paid")
+
+	// ErrRefundExceedsSale is returned when a refund returns more than was sold or paid.
+	ErrRefundExceedsSale = errors.New("refund exceeds original sale")
+)
+
+type RefundLine struct {
+	Id             int     `json:"id"`
+	RefundId       int     `json:"refund_id"`
+	OrderProductId int     `json:"order_product_id"`
+	ProductId      int     `json:"product_id"`
+	Qty            int     `json:"qty"`
+	Amount         Money   `json:"amount"`
+	TaxRate        float64 `json:"tax_rate"`
+	Tax            Money   `json:"tax"`
+}
+
+type Refund struct {
+	Id           int          `json:"id"`
+	OrderId      int          `json:"order_id"`
+	EmployeeId   int          `json:"employee_id"`
+	ShiftId      *int         `json:"shift_id"`
+	Reason       string       `json:"reason"`
+	Note         string       `json:"note"`
+	Method       string       `json:"method"`
+	Restock      bool         `json:"restock"`
+	Amount       Money        `json:"amount"`
+	GiftCardId   *int         `json:"gift_card_id,omitempty"`
+	GiftCardCode string       `json:"gift_card_code,omitempty"`
+	Lines        []RefundLine `json:"lines"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+type RefundModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateRefund(v *validator.Validator, refund *Refund) {
+	v.Check(validator.In(refund.Reason, RefundReasons...), "reason", "must be a known reason code")
+	v.Check(validator.In(refund.Method, TenderCash, TenderCard, TenderTransfer, TenderPoints, RefundStoreCredit), "method", "must be cash, card, transfer, points or store_credit")
+	for i, l := range refund.Lines {
+		v.Check(l.OrderProductId > 0, fmt.Sprintf("lines[%d].order_product_id", i), "must be provided")
+		v.Check(l.Qty > 0, fmt.Sprintf("lines[%d].qty", i), "must be greater than zero")
+	}
+}
+
+// Create records a refund against a paid order. With no lines the refund returns
+// everything that has not been refunded yet; otherwise only the given quantities.
+// Line amounts are what the customer paid for the line after discounts and carry the
+// matching share of its tax; neither the quantities nor the total may exceed what was
+// sold and paid. With Restock set the returned quantities
+// go back into stock as refund movements in the same transaction. A points refund
+// credits the order's customer with the whole points the amount is worth, and the share of
+// the points the order earned that matches the refunded amount is taken back. A store
+// credit refund goes onto the card given by GiftCardCode, or a new store credit card
+// for the order's customer. Refunded gift card lines void their card and are never
+// restocked. An order refunded in full becomes refunded; voided orders cannot be
+// refunded. The idempotency key of the request, if any, is committed with the refund.
+func (m RefundModel) Create(orderId int, refund *Refund, by AuditActor, key *IdempotencyKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order, err := getOrder(ctx, tx, orderId, true)
+	if err != nil {
+		return err
+	}
+	before, err := auditSnapshot(order)
+	if err != nil {
+		return err
+	}
+
+	if order.PaidAt == nil {
+		return ErrOrderNotPaid
+	}
+	if order.Status == OrderVoided {
+		return fmt.Errorf("%w: order %d is voided", ErrInvalidTransition, orderId)
+	}
+
+	full, err := refundOrder(ctx, tx, order, refund)
+	if err != nil {
+		return err
+	}
+	if full {
+		if err := setOrderStatus(ctx, tx, order, OrderRefunded); err != nil {
+			return err
+		}
+	}
+	if err := auditOrder(ctx, tx, by, AuditUpdate, orderId, before); err != nil {
+		return err
+	}
+	if err := commitIdempotencyKey(ctx, tx, key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// refundOrder is Create on a locked, paid order inside a transaction. It reports
+// whether the order is now refunded in full.
+func refundOrder(ctx context.Context, q queryer, order *Order, refund *Refund) (bool, error) {
+	orderId := order.Id
+	refunded, err := getRefundedLines(ctx, q, orderId)
+	if err != nil {
+		return false, err
+	}
+
+	sold := make(map[int]OrderProduct, len(order.Products))
+	for _, p := range order.Products {
+		sold[p.Id] = p
+	}
+
+	if len(refund.Lines) == 0 {
+		for _, p := range order.Products {
+			if left := p.Qty - refunded[p.Id].Qty; left > 0 {
+				refund.Lines = append(refund.Lines, RefundLine{OrderProductId: p.Id, Qty: left})
+			}
+		}
+		if len(refund.Lines) == 0 {
+			return false, fmt.Errorf("%w: order %d is already fully refunded", ErrRefundExceedsSale, orderId)
+		}
+	}
+
+	refund.Amount = Money{}
+	for i := range refund.Lines {
+		line := &refund.Lines[i]
+
+		p, ok := sold[line.OrderProductId]
+		if !ok {
+			return false, fmt.Errorf("%w: line %d is not part of order %d", ErrRecordNotFound, line.OrderProductId, orderId)
+		}
+
+		r := refunded[p.Id]
+		qty := r.Qty + line.Qty
+		if qty > p.Qty {
+			return false, fmt.Errorf("%w: line %d sold %d, %d would be refunded", ErrRefundExceedsSale, p.Id, p.Qty, qty)
+		}
+
+		// The line's share of the sale is rounded over everything refunded of it so far,
+		// so refunding it in parts adds up to exactly what it cost.
+		share := float64(qty) / float64(p.Qty)
+		line.ProductId = p.ProductId
+		line.Amount = order.LineNet(p).Scale(share).Sub(r.Amount)
+		line.TaxRate = p.TaxRate
+		line.Tax = p.Tax.Scale(share).Sub(r.Tax)
+		refund.Amount = refund.Amount.Add(line.Amount)
+		refunded[p.Id] = refundedLine{Qty: qty, Amount: r.Amount.Add(line.Amount), Tax: r.Tax.Add(line.Tax)}
+	}
+
+	var previous Money
+	err = q.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE order_id = $1`, orderId).Scan(&previous)
+	if err != nil {
+		return false, err
+	}
+
+	collected := order.TotalPaid.Sub(order.TotalReturn)
+	if previous.Add(refund.Amount).Cmp(collected) > 0 {
+		return false, fmt.Errorf("%w: %s collected, %s already refunded, %s requested", ErrRefundExceedsSale, collected, previous, refund.Amount)
+	}
+
+	if refund.Method == TenderPoints && order.CustomerId == nil {
+		return false, ErrNoCustomer
+	}
+
+	refund.ShiftId, err = takingsShiftId(ctx, q, refund.EmployeeId)
+	if err != nil {
+		return false, err
+	}
+
+	if refund.Method == RefundStoreCredit {
+		if err := creditStoreCredit(ctx, q, order, refund); err != nil {
+			return false, err
+		}
+	}
+
+	query := `
+		INSERT INTO refunds (order_id, employee_id, shift_id, reason, note, method, restock, amount, gift_card_id)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at, updated_at
+	`
+	refund.OrderId = orderId
+	args := []interface{}{orderId, refund.EmployeeId, refund.ShiftId, refund.Reason, refund.Note, refund.Method, refund.Restock, refund.Amount, refund.GiftCardId}
+	err = q.QueryRowContext(ctx, query, args...).Scan(&refund.Id, &refund.CreatedAt, &refund.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	if refund.Restock {
+		ids := make([]int, 0, len(refund.Lines))
+		for _, line := range refund.Lines {
+			ids = append(ids, line.ProductId)
+		}
+		if err := lockProducts(ctx, q, ids); err != nil {
+			return false, err
+		}
+	}
+
+	query = `
+		INSERT INTO refund_lines (refund_id, order_product_id, product_id, qty, amount, tax_rate, tax)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+	for i := range refund.Lines {
+		line := &refund.Lines[i]
+		line.RefundId = refund.Id
+
+		args := []interface{}{line.RefundId, line.OrderProductId, line.ProductId, line.Qty, line.Amount, line.TaxRate, line.Tax}
+		if err := q.QueryRowContext(ctx, query, args...).Scan(&line.Id); err != nil {
+			return false, err
+		}
+
+		if p := sold[line.OrderProductId]; p.GiftCardId != nil {
+			if err := refundGiftCardLine(ctx, q, order, p, refund); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		if refund.Restock {
+			movement := StockMovement{
+				ProductId:  line.ProductId,
+				Type:       StockRefund,
+				Qty:        line.Qty,
+				EmployeeId: refund.EmployeeId,
+				Reason:     refund.Reason,
+				Reference:  fmt.Sprintf("refund:%d", refund.Id),
+			}
+			if err := moveStock(ctx, q, &movement); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if refund.Method == TenderPoints {
+		points, _ := PointsFor(refund.Amount)
+		t := LoyaltyTransaction{
+			CustomerId: *order.CustomerId,
+			OrderId:    &order.Id,
+			Type:       PointsRefund,
+			Points:     points,
+			EmployeeId: refund.EmployeeId,
+			Reason:     refund.Reason,
+		}
+		if points > 0 {
+			if err := movePoints(ctx, q, &t); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if err := reverseEarnedPoints(ctx, q, order, previous.Add(refund.Amount), collected, refund.EmployeeId); err != nil {
+		return false, err
+	}
+
+	for _, p := range order.Products {
+		if refunded[p.Id].Qty < p.Qty {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m RefundModel) GetAllForOrder(orderId int) ([]Refund, error) {
+	query := `
+		SELECT r.id, r.order_id, COALESCE(r.employee_id, 0), r.shift_id, r.reason, r.note, r.method, r.restock, r.amount, r.gift_card_id, COALESCE(g.code, ''),
+			r.created_at, r.updated_at, l.id, l.order_product_id, l.product_id, l.qty, l.amount, l.tax_rate, l.tax
+		FROM refunds r
+		INNER JOIN refund_lines l ON l.refund_id = r.id
+		LEFT JOIN gift_cards g ON g.id = r.gift_card_id
+		WHERE r.order_id = $1
+		ORDER BY r.id, l.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	for rows.Next() {
+		var r Refund
+		var l RefundLine
+		err := rows.Scan(&r.Id, &r.OrderId, &r.EmployeeId, &r.ShiftId, &r.Reason, &r.Note, &r.Method, &r.Restock, &r.Amount, &r.GiftCardId, &r.GiftCardCode,
+			&r.CreatedAt, &r.UpdatedAt, &l.Id, &l.OrderProductId, &l.ProductId, &l.Qty, &l.Amount, &l.TaxRate, &l.Tax)
+		if err != nil {
+			return nil, err
+		}
+		l.RefundId = r.Id
+
+		if n := len(refunds); n > 0 && refunds[n-1].Id == r.Id {
+			refunds[n-1].Lines = append(refunds[n-1].Lines, l)
+			continue
+		}
+		r.Lines = []RefundLine{l}
+		refunds = append(refunds, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// refundedLine is how much of an order line has been refunded so far.
+type refundedLine struct {
+	Qty    int
+	Amount Money
+	Tax    Money
+}
+
+// getRefundedLines returns how much of every line of the order has already been
+// refunded, keyed by order_product id.
+func getRefundedLines(ctx context.Context, q queryer, orderId int) (map[int]refundedLine, error) {
+	query := `
+		SELECT l.order_product_id, SUM(l.qty), SUM(l.amount), SUM(l.tax)
+		FROM refund_lines l
+		INNER JOIN refunds r ON r.id = l.refund_id
+		WHERE r.order_id = $1
+		GROUP BY l.order_product_id
+	`
+	rows, err := q.QueryContext(ctx, query, orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunded := make(map[int]refundedLine)
+	for rows.Next() {
+		var id int
+		var r refundedLine
+		if err := rows.Scan(&id, &r.Qty, &r.Amount, &r.Tax); err != nil {
+			return nil, err
+		}
+		refunded[id] = r
+	}
+
+	return refunded, rows.Err()
+}
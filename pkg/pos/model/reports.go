@@ -0,0 +1,168 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Sales report groupings and the key and label expressions they group by.
+var salesGroupings = map[string][2]string{
+	"day":      {`to_char(date_trunc('day', x.at), 'YYYY-MM-DD')`, `to_char(date_trunc('day', x.at), 'YYYY-MM-DD')`},
+	"hour":     {`to_char(date_trunc('hour', x.at), 'YYYY-MM-DD HH24:00')`, `to_char(date_trunc('hour', x.at), 'YYYY-MM-DD HH24:00')`},
+	"product":  {`x.product_id::TEXT`, `COALESCE(p.name, '')`},
+	"category": {`COALESCE(p.category_id::TEXT, '')`, `COALESCE(c.name, '')`},
+	"employee": {`COALESCE(x.employee_id::TEXT, '')`, `COALESCE(concat_ws(' ', e.name, e.surname), '')`},
+}
+
+// SalesGroupings lists the values accepted for the group_by parameter of Sales.
+var SalesGroupings = []string{"day", "hour", "product", "category", "employee"}
+
+// salesLines flattens sold lines of paid orders and refunded lines into one row set.
+// Sales count in the period the order was paid, refunds in the period they were made.
+// Order-level discounts are spread over the lines in proportion to their value; the
+// sums are NUMERIC and only rounded to minor units as they are scanned.
+// extra_tax is tax charged on top of tax-exclusive prices; tax is the VAT of the row,
+// negative for refunds.
+const salesLines = `
+	WITH x AS (
+		SELECT o.paid_at AS at, op.product_id, o.employee_id, op.tax_rate,
+			op.qty AS qty, 0 AS refunded_qty, op.total_normal_price::NUMERIC AS gross,
+			op.discount + COALESCE(o.discount::NUMERIC * (op.total_normal_price - op.discount) /
+				NULLIF(o.total_price + o.discount - CASE WHEN o.tax_inclusive THEN 0 ELSE o.tax END, 0), 0) AS discounts,
+			CASE WHEN o.tax_inclusive THEN 0 ELSE op.tax END::NUMERIC AS extra_tax,
+			op.tax::NUMERIC AS tax,
+			0::NUMERIC AS refunds
+		FROM order_product op
+		INNER JOIN orders o ON o.id = op.order_id
+		WHERE o.paid_at >= $1 AND o.paid_at < $2
+		UNION ALL
+		SELECT r.created_at, l.product_id, r.employee_id, l.tax_rate,
+			0, l.qty, 0,
+			0, 0, -l.tax,
+			l.amount
+		FROM refund_lines l
+		INNER JOIN refunds r ON r.id = l.refund_id
+		WHERE r.created_at >= $1 AND r.created_at < $2
+	)
+`
+
+type SalesRow struct {
+	Key              string `json:"key"`
+	Label            string `json:"label"`
+	Quantity         int    `json:"quantity"`
+	RefundedQuantity int    `json:"refunded_quantity"`
+	Gross            Money  `json:"gross"`
+	Discounts        Money  `json:"discounts"`
+	Refunds          Money  `json:"refunds"`
+	Tax              Money  `json:"tax"`
+	Net              Money  `json:"net"`
+}
+
+type ReportModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Sales aggregates sold and refunded lines between from (inclusive) and to
+// (exclusive), grouped by groupBy, one page at a time. The second result is the
+// total over the whole range.
+func (m ReportModel) Sales(from, to time.Time, groupBy string, filters Filters) ([]SalesRow, SalesRow, Metadata, error) {
+	grouping, ok := salesGroupings[groupBy]
+	if !ok {
+		panic("unsafe group_by parameter: " + groupBy)
+	}
+
+	query := fmt.Sprintf(salesLines+`
+		SELECT count(*) OVER(), %s AS key, %s AS label,
+			SUM(x.qty) AS quantity, SUM(x.refunded_qty) AS refunded_quantity,
+			SUM(x.gross) AS gross, SUM(x.discounts) AS discounts, SUM(x.refunds) AS refunds,
+			SUM(x.tax) AS tax, SUM(x.gross - x.discounts + x.extra_tax - x.refunds) AS net
+		FROM x
+		LEFT JOIN products p ON p.id = x.product_id
+		LEFT JOIN categories c ON c.id = p.category_id
+		LEFT JOIN employee e ON e.id = x.employee_id
+		GROUP BY 2, 3
+		ORDER BY %s %s, key ASC
+		LIMIT $3 OFFSET $4
+	`, grouping[0], grouping[1], filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, SalesRow{}, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	report := []SalesRow{}
+	for rows.Next() {
+		var r SalesRow
+		err := rows.Scan(&totalRecords, &r.Key, &r.Label, &r.Quantity, &r.RefundedQuantity,
+			&r.Gross, &r.Discounts, &r.Refunds, &r.Tax, &r.Net)
+		if err != nil {
+			return nil, SalesRow{}, Metadata{}, err
+		}
+		report = append(report, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, SalesRow{}, Metadata{}, err
+	}
+
+	query = salesLines + `
+		SELECT COALESCE(SUM(x.qty), 0), COALESCE(SUM(x.refunded_qty), 0),
+			COALESCE(SUM(x.gross), 0), COALESCE(SUM(x.discounts), 0), COALESCE(SUM(x.refunds), 0),
+			COALESCE(SUM(x.tax), 0), COALESCE(SUM(x.gross - x.discounts + x.extra_tax - x.refunds), 0)
+		FROM x
+	`
+	total := SalesRow{Key: "total", Label: "Total"}
+	err = m.DB.QueryRowContext(ctx, query, from, to).Scan(&total.Quantity, &total.RefundedQuantity,
+		&total.Gross, &total.Discounts, &total.Refunds, &total.Tax, &total.Net)
+	if err != nil {
+		return nil, SalesRow{}, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return report, total, metadata, nil
+}
+
+// Tax sums the VAT collected between from (inclusive) and to (exclusive) per rate,
+// net of refunds.
+func (m ReportModel) Tax(from, to time.Time) ([]TaxLine, error) {
+	query := salesLines + `
+		SELECT x.tax_rate, SUM(x.gross - x.discounts + x.extra_tax - x.refunds - x.tax), SUM(x.tax),
+			SUM(x.gross - x.discounts + x.extra_tax - x.refunds)
+		FROM x
+		GROUP BY x.tax_rate
+		ORDER BY x.tax_rate
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taxes := []TaxLine{}
+	for rows.Next() {
+		var t TaxLine
+		if err := rows.Scan(&t.Rate, &t.Net, &t.Tax, &t.Gross); err != nil {
+			return nil, err
+		}
+		taxes = append(taxes, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return taxes, nil
+}
@@ -0,0 +1,325 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	CashPayIn  = "pay_in"
+	CashPayOut = "pay_out"
+)
+
+var (
+	// ErrShiftAlreadyOpen is returned when an employee opens a second shift.
+	ErrShiftAlreadyOpen = errors.New("employee already has an open shift")
+
+	// ErrNoOpenShift is returned when an operation needs an open shift and there is none.
+	ErrNoOpenShift = errors.New("employee has no open shift")
+)
+
+type Shift struct {
+	Id           int        `json:"id"`
+	EmployeeId   int        `json:"employee_id"`
+	StoreId      string     `json:"store_id"`
+	StationId    *int       `json:"station_id"`
+	OpeningFloat Money      `json:"opening_float"`
+	ExpectedCash *Money     `json:"expected_cash"`
+	CountedCash  *Money     `json:"counted_cash"`
+	OpenedAt     time.Time  `json:"opened_at"`
+	ClosedAt     *time.Time `json:"closed_at"`
+}
+
+type CashMovement struct {
+	Id         int       `json:"id"`
+	ShiftId    int       `json:"shift_id"`
+	EmployeeId int       `json:"employee_id"`
+	Type       string    `json:"type"`
+	Amount     Money     `json:"amount"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ShiftReport is the X report of an open shift or the Z report of a closed one.
+type ShiftReport struct {
+	Type            string           `json:"type"`
+	Shift           *Shift           `json:"shift"`
+	Orders          int              `json:"orders"`
+	Sales           Money            `json:"sales"`
+	SalesByMethod   map[string]Money `json:"sales_by_method"`
+	Refunds         Money            `json:"refunds"`
+	RefundsByMethod map[string]Money `json:"refunds_by_method"`
+	PayIns          Money            `json:"pay_ins"`
+	PayOuts         Money            `json:"pay_outs"`
+	ExpectedCash    Money            `json:"expected_cash"`
+	CountedCash     *Money           `json:"counted_cash,omitempty"`
+	Variance        *Money           `json:"variance,omitempty"`
+}
+
+type ShiftModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateCashMovement(v *validator.Validator, m *CashMovement) {
+	v.Check(validator.In(m.Type, CashPayIn, CashPayOut), "type", "must be pay_in or pay_out")
+	v.Check(m.Amount.IsPositive(), "amount", "must be greater than zero")
+	v.Check(m.Reason != "", "reason", "must be provided")
+}
+
+func (m ShiftModel) Open(shift *Shift) error {
+	query := `
+		INSERT INTO shifts (employee_id, store_id, station_id, opening_float)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, opened_at
+	`
+	if shift.StoreId == "" {
+		shift.StoreId = DefaultStoreId
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, shift.EmployeeId, shift.StoreId, shift.StationId, shift.OpeningFloat).Scan(&shift.Id, &shift.OpenedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Constraint == "shifts_open_employee_idx" {
+			return ErrShiftAlreadyOpen
+		}
+		return err
+	}
+
+	return nil
+}
+
+// GetOpenForEmployee returns the employee's open shift or ErrNoOpenShift.
+func (m ShiftModel) GetOpenForEmployee(employeeId int) (*Shift, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getShift(ctx, m.DB, `WHERE employee_id = $1 AND closed_at IS NULL`, employeeId)
+}
+
+func (m ShiftModel) Get(id int) (*Shift, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	shift, err := getShift(ctx, m.DB, `WHERE id = $1`, id)
+	if errors.Is(err, ErrNoOpenShift) {
+		return nil, ErrRecordNotFound
+	}
+	return shift, err
+}
+
+func (m ShiftModel) AddCashMovement(movement *CashMovement) error {
+	query := `
+		INSERT INTO cash_movements (shift_id, employee_id, type, amount, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	args := []interface{}{movement.ShiftId, movement.EmployeeId, movement.Type, movement.Amount, movement.Reason}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movement.Id, &movement.CreatedAt)
+}
+
+// Report builds the X report of an open shift, or the Z report once it is closed.
+func (m ShiftModel) Report(shift *Shift) (*ShiftReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return shiftReport(ctx, m.DB, shift)
+}
+
+// Close records the counted cash, freezes the expected cash and closes the shift,
+// returning its Z report.
+func (m ShiftModel) Close(shift *Shift, countedCash Money) (*ShiftReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	locked, err := getShift(ctx, tx, `WHERE id = $1 AND closed_at IS NULL FOR UPDATE`, shift.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := shiftReport(ctx, tx, locked)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE shifts
+		SET expected_cash = $1, counted_cash = $2, closed_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING closed_at
+	`
+	err = tx.QueryRowContext(ctx, query, report.ExpectedCash, countedCash, locked.Id).Scan(&locked.ClosedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	locked.ExpectedCash = &report.ExpectedCash
+	locked.CountedCash = &countedCash
+	report.Type = "Z"
+	report.CountedCash = &countedCash
+	variance := countedCash.Sub(report.ExpectedCash)
+	report.Variance = &variance
+
+	return report, tx.Commit()
+}
+
+func getShift(ctx context.Context, q queryer, where string, args ...interface{}) (*Shift, error) {
+	query := `
+		SELECT id, employee_id, store_id, station_id, opening_float, expected_cash, counted_cash, opened_at, closed_at
+		FROM shifts
+	` + where
+
+	var s Shift
+	err := q.QueryRowContext(ctx, query, args...).Scan(&s.Id, &s.EmployeeId, &s.StoreId, &s.StationId, &s.OpeningFloat,
+		&s.ExpectedCash, &s.CountedCash, &s.OpenedAt, &s.ClosedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoOpenShift
+		}
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// openShiftId returns the id of the employee's open shift, or nil if there is none.
+// The shift row is share-locked until the transaction ends, so Close waits for
+// whatever is being booked to the shift and a shift closed in the meantime is not
+// found.
+func openShiftId(ctx context.Context, q queryer, employeeId int) (*int, error) {
+	var id int
+	err := q.QueryRowContext(ctx, `SELECT id FROM shifts WHERE employee_id = $1 AND closed_at IS NULL FOR SHARE`, employeeId).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &id, nil
+}
+
+// takingsShiftId returns the id of the employee's open shift, which money taken in or
+// paid out is booked to, or ErrNoOpenShift. Takings outside a shift would be in no
+// X or Z report.
+func takingsShiftId(ctx context.Context, q queryer, employeeId int) (*int, error) {
+	id, err := openShiftId(ctx, q, employeeId)
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, fmt.Errorf("%w: money is only taken and paid out on an open shift", ErrNoOpenShift)
+	}
+	return id, nil
+}
+
+// shiftIdAt returns the id of the employee's shift that was open at the given time,
+// closed since or not, or nil if there was none. Like openShiftId it share-locks the
+// shift.
+func shiftIdAt(ctx context.Context, q queryer, employeeId int, at time.Time) (*int, error) {
+	query := `
+		SELECT id
+		FROM shifts
+		WHERE employee_id = $1 AND opened_at <= $2 AND (closed_at IS NULL OR closed_at > $2)
+		ORDER BY opened_at DESC
+		LIMIT 1
+		FOR SHARE
+	`
+	var id int
+	err := q.QueryRowContext(ctx, query, employeeId, at).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &id, nil
+}
+
+func shiftReport(ctx context.Context, q queryer, shift *Shift) (*ShiftReport, error) {
+	report := &ShiftReport{
+		Type:            "X",
+		Shift:           shift,
+		SalesByMethod:   map[string]Money{},
+		RefundsByMethod: map[string]Money{},
+	}
+	if shift.ClosedAt != nil {
+		report.Type = "Z"
+		report.CountedCash = shift.CountedCash
+	}
+
+	err := q.QueryRowContext(ctx, `SELECT count(*) FROM orders WHERE shift_id = $1 AND paid_at IS NOT NULL`, shift.Id).Scan(&report.Orders)
+	if err != nil {
+		return nil, err
+	}
+
+	// Change is handed out of the drawer, so it is netted off the tender it came from.
+	sums := []struct {
+		query  string
+		byType map[string]Money
+		total  *Money
+	}{
+		{`SELECT method, SUM(amount - change) FROM payments WHERE shift_id = $1 GROUP BY method`, report.SalesByMethod, &report.Sales},
+		{`SELECT method, SUM(amount) FROM refunds WHERE shift_id = $1 GROUP BY method`, report.RefundsByMethod, &report.Refunds},
+	}
+	for _, s := range sums {
+		rows, err := q.QueryContext(ctx, s.query, shift.Id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var method string
+			var amount Money
+			if err := rows.Scan(&method, &amount); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			s.byType[method] = amount
+			*s.total = s.total.Add(amount)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+		SELECT COALESCE(SUM(amount) FILTER (WHERE type = 'pay_in'), 0),
+			COALESCE(SUM(amount) FILTER (WHERE type = 'pay_out'), 0)
+		FROM cash_movements
+		WHERE shift_id = $1
+	`
+	err = q.QueryRowContext(ctx, query, shift.Id).Scan(&report.PayIns, &report.PayOuts)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ExpectedCash = shift.OpeningFloat.Add(report.SalesByMethod[TenderCash]).Sub(report.RefundsByMethod[TenderCash]).
+		Add(report.PayIns).Sub(report.PayOuts)
+
+	if report.CountedCash != nil {
+		variance := report.CountedCash.Sub(report.ExpectedCash)
+		report.Variance = &variance
+	}
+
+	return report, nil
+}
@@ -0,0 +1,271 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Station is a registered till. Orders and shifts opened on it carry its id and its
+// store; its default tax rate applies to products whose own and category rate are
+// unset. A station authenticates with a device credential, which is only returned in
+// plain text when it is issued. A disabled station is refused on every request.
+type Station struct {
+	Id             int        `json:"id"`
+	Name           string     `json:"name"`
+	StoreId        string     `json:"store_id"`
+	PrinterWidth   int        `json:"printer_width"`
+	DefaultTaxRate *float64   `json:"default_tax_rate"`
+	Tenders        []string   `json:"tenders"`
+	Credential     string     `json:"credential,omitempty"`
+	DisabledAt     *time.Time `json:"disabled_at"`
+	DisabledReason string     `json:"disabled_reason"`
+	LastSeenAt     *time.Time `json:"last_seen_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type StationModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Disabled reports whether the station was disabled.
+func (s *Station) Disabled() bool {
+	return s.DisabledAt != nil
+}
+
+// AcceptsTender reports whether the station may take payments with method.
+func (s *Station) AcceptsTender(method string) bool {
+	return validator.In(method, s.Tenders...)
+}
+
+// ValidateStation checks a station; printerWidths are the receipt widths the renderer
+// supports.
+func ValidateStation(v *validator.Validator, s *Station, printerWidths ...int) {
+	v.Check(s.Name != "", "name", "must be provided")
+	v.Check(len(s.Name) <= 100, "name", "must not be more than 100 characters long")
+
+	widthOk := false
+	for _, w := range printerWidths {
+		widthOk = widthOk || s.PrinterWidth == w
+	}
+	v.Check(widthOk, "printer_width", fmt.Sprintf("must be one of %v", printerWidths))
+
+	v.Check(s.DefaultTaxRate == nil || *s.DefaultTaxRate >= 0, "default_tax_rate", "must not be negative")
+	v.Check(len(s.Tenders) > 0, "tenders", "must contain at least one tender")
+	v.Check(validator.Unique(s.Tenders), "tenders", "must not contain duplicate values")
+	for i, t := range s.Tenders {
+		v.Check(validator.In(t, Tenders...), fmt.Sprintf("tenders[%d]", i), "must be cash, card, transfer, points or gift_card")
+	}
+}
+
+// Register creates a station and issues its first credential.
+func (m StationModel) Register(s *Station) error {
+	plaintext, hash, err := generateStationCredential()
+	if err != nil {
+		return err
+	}
+
+	if s.StoreId == "" {
+		s.StoreId = DefaultStoreId
+	}
+
+	query := `
+		INSERT INTO stations (name, store_id, printer_width, default_tax_rate, tenders, credential_hash)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+	`
+	args := []interface{}{s.Name, s.StoreId, s.PrinterWidth, s.DefaultTaxRate, pq.Array(s.Tenders), hash}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&s.Id, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	s.Credential = plaintext
+	return nil
+}
+
+func (m StationModel) Get(id int) (*Station, error) {
+	query := `
+		SELECT ` + stationColumns + `
+		FROM stations
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s Station
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(stationFields(&s)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (m StationModel) GetAll() ([]Station, error) {
+	query := `
+		SELECT ` + stationColumns + `
+		FROM stations
+		ORDER BY store_id, name, id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stations := []Station{}
+	for rows.Next() {
+		var s Station
+		if err := rows.Scan(stationFields(&s)...); err != nil {
+			return nil, err
+		}
+		stations = append(stations, s)
+	}
+
+	return stations, rows.Err()
+}
+
+// GetForCredential returns the station a device credential belongs to and records that
+// the station was seen.
+func (m StationModel) GetForCredential(credential string) (*Station, error) {
+	hash := sha256.Sum256([]byte(credential))
+
+	query := `
+		UPDATE stations
+		SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE credential_hash = $1
+		RETURNING ` + stationColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s Station
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(stationFields(&s)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Update changes the configuration of a station. Its credential and disabled state are
+// left alone.
+func (m StationModel) Update(id int, s *Station) error {
+	if s.StoreId == "" {
+		s.StoreId = DefaultStoreId
+	}
+
+	query := `
+		UPDATE stations
+		SET name = $1, store_id = $2, printer_width = $3, default_tax_rate = $4, tenders = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6
+		RETURNING ` + stationColumns
+	args := []interface{}{s.Name, s.StoreId, s.PrinterWidth, s.DefaultTaxRate, pq.Array(s.Tenders), id}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(stationFields(s)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+// RotateCredential issues a new credential for a station; the old one stops working.
+func (m StationModel) RotateCredential(id int) (*Station, error) {
+	plaintext, hash, err := generateStationCredential()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE stations
+		SET credential_hash = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING ` + stationColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s Station
+	err = m.DB.QueryRowContext(ctx, query, hash, id).Scan(stationFields(&s)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	s.Credential = plaintext
+	return &s, nil
+}
+
+// SetDisabled disables a station with a reason, or enables it again.
+func (m StationModel) SetDisabled(id int, disabled bool, reason string) (*Station, error) {
+	query := `
+		UPDATE stations
+		SET disabled_at = CASE WHEN $1 THEN COALESCE(disabled_at, CURRENT_TIMESTAMP) END,
+			disabled_reason = CASE WHEN $1 THEN $2 ELSE '' END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING ` + stationColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s Station
+	err := m.DB.QueryRowContext(ctx, query, disabled, reason, id).Scan(stationFields(&s)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// stationColumns are the columns of a station in the order stationFields scans them.
+const stationColumns = `id, name, store_id, printer_width, default_tax_rate, tenders, disabled_at, disabled_reason,
+		last_seen_at, created_at, updated_at`
+
+// stationFields returns the scan destinations of stationColumns.
+func stationFields(s *Station) []interface{} {
+	return []interface{}{&s.Id, &s.Name, &s.StoreId, &s.PrinterWidth, &s.DefaultTaxRate, pq.Array(&s.Tenders),
+		&s.DisabledAt, &s.DisabledReason, &s.LastSeenAt, &s.CreatedAt, &s.UpdatedAt}
+}
+
+// generateStationCredential returns a random device credential and the hash stored
+// for it, encoded like authentication tokens.
+func generateStationCredential() (string, []byte, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", nil, err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+	return plaintext, hash[:], nil
+}
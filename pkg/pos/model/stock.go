@@ -0,0 +1,281 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+)
+
+const (
+	StockSale       = "sale"
+	StockRefund     = "refund"
+	StockReceipt    = "receipt"
+	StockAdjustment = "adjustment"
+	StockWriteOff   = "write_off"
+	StockTransfer   = "transfer"
+)
+
+// ManualStockMovements are the movement types employees may record directly; sales
+// and refunds are only written by the order and refund flows.
+var ManualStockMovements = []string{StockReceipt, StockAdjustment, StockWriteOff, StockTransfer}
+
+// StockMovement is an entry of the append-only stock ledger. Qty is the signed change
+// of the on-hand amount and Balance the amount right after it. Reference names the
+// document behind the movement, such as "order:12" or a supplier invoice number. A
+// transfer moves stock from FromStore to ToStore, one of which is this store, so it
+// is negative when the stock leaves and positive when it arrives.
+type StockMovement struct {
+	Id         int64     `json:"id"`
+	ProductId  int       `json:"product_id"`
+	Type       string    `json:"type"`
+	Qty        int       `json:"qty"`
+	Balance    int       `json:"balance"`
+	EmployeeId int       `json:"employee_id"`
+	Reason     string    `json:"reason"`
+	Reference  string    `json:"reference"`
+	FromStore  string    `json:"from_store"`
+	ToStore    string    `json:"to_store"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// StockDiscrepancy is a product whose on-hand amount disagrees with its ledger.
+type StockDiscrepancy struct {
+	ProductId int    `json:"product_id"`
+	Name      string `json:"name"`
+	Amount    int    `json:"amount"`
+	Ledger    int    `json:"ledger"`
+}
+
+type StockModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// ValidateStockMovement checks a movement recorded at store.
+func ValidateStockMovement(v *validator.Validator, m *StockMovement, store string) {
+	v.Check(validator.In(m.Type, ManualStockMovements...), "type", "must be receipt, adjustment, write_off or transfer")
+	v.Check(m.Qty != 0, "qty", "must not be zero")
+	v.Check(m.Type != StockReceipt || m.Qty > 0, "qty", "must be positive for a receipt")
+	v.Check(m.Type != StockWriteOff || m.Qty < 0, "qty", "must be negative for a write-off")
+	v.Check(m.Type == StockReceipt || m.Reason != "", "reason", "must be provided")
+
+	if m.Type != StockTransfer {
+		v.Check(m.FromStore == "", "from_store", "must only be provided for a transfer")
+		v.Check(m.ToStore == "", "to_store", "must only be provided for a transfer")
+		return
+	}
+	v.Check(m.FromStore != "", "from_store", "must be provided")
+	v.Check(m.ToStore != "", "to_store", "must be provided")
+	v.Check(m.FromStore != m.ToStore, "to_store", "must differ from from_store")
+	v.Check(m.FromStore == store || m.ToStore == store, "from_store", "either from_store or to_store must be "+store)
+	v.Check(m.FromStore != store || m.Qty < 0, "qty", "must be negative for a transfer out")
+	v.Check(m.ToStore != store || m.Qty > 0, "qty", "must be positive for a transfer in")
+}
+
+// Create records a movement and applies it to the product's amount in one
+// transaction.
+func (m StockModel) Create(movement *StockMovement) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := moveStock(ctx, tx, movement); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForProduct returns a page of the product's ledger.
+func (m StockModel) GetAllForProduct(productId int, filters Filters) ([]StockMovement, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, product_id, type, qty, balance, COALESCE(employee_id, 0), reason, reference, from_store, to_store, created_at
+		FROM stock_movements
+		WHERE product_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productId, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movements := []StockMovement{}
+	for rows.Next() {
+		var s StockMovement
+		err := rows.Scan(&totalRecords, &s.Id, &s.ProductId, &s.Type, &s.Qty, &s.Balance, &s.EmployeeId,
+			&s.Reason, &s.Reference, &s.FromStore, &s.ToStore, &s.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movements = append(movements, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movements, metadata, nil
+}
+
+// Discrepancies lists the products whose amount is not the sum of their ledger.
+func (m StockModel) Discrepancies() ([]StockDiscrepancy, error) {
+	query := `
+		SELECT p.id, p.name, p.amount, COALESCE(SUM(s.qty), 0) AS ledger
+		FROM products p
+		LEFT JOIN stock_movements s ON s.product_id = p.id
+		GROUP BY p.id
+		HAVING p.amount <> COALESCE(SUM(s.qty), 0)
+		ORDER BY p.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discrepancies := []StockDiscrepancy{}
+	for rows.Next() {
+		var d StockDiscrepancy
+		if err := rows.Scan(&d.ProductId, &d.Name, &d.Amount, &d.Ledger); err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, d)
+	}
+
+	return discrepancies, rows.Err()
+}
+
+// moveStock is the only way the on-hand amount of a product changes: it applies the
+// movement to products.amount and appends it to the ledger with the new balance. A
+// movement that would take the amount below zero fails with ErrOutOfStock.
+func moveStock(ctx context.Context, q queryer, m *StockMovement) error {
+	query := `
+		UPDATE products
+		SET amount = amount + $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND amount + $1 >= 0
+		RETURNING amount
+	`
+	err := q.QueryRowContext(ctx, query, m.Qty, m.ProductId).Scan(&m.Balance)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var amount int
+		err := q.QueryRowContext(ctx, `SELECT amount FROM products WHERE id = $1`, m.ProductId).Scan(&amount)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: product %d has %d left, %d requested", ErrOutOfStock, m.ProductId, amount, -m.Qty)
+	}
+
+	query = `
+		INSERT INTO stock_movements (product_id, type, qty, balance, employee_id, reason, reference, from_store, to_store)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8, $9)
+		RETURNING id, created_at
+	`
+	args := []interface{}{m.ProductId, m.Type, m.Qty, m.Balance, m.EmployeeId, m.Reason, m.Reference, m.FromStore, m.ToStore}
+	return q.QueryRowContext(ctx, query, args...).Scan(&m.Id, &m.CreatedAt)
+}
+
+func orderReference(id int) string {
+	return fmt.Sprintf("order:%d", id)
+}
+
+// LowStockItem is a product at or under its minimum stock with a reorder suggestion.
+// AverageDailySales comes from paid orders over the lookback window; DaysOfCover is
+// how long the amount on hand lasts at that pace, nil when nothing sold.
+type LowStockItem struct {
+	ProductId         int      `json:"product_id"`
+	Name              string   `json:"name"`
+	Amount            int      `json:"amount"`
+	MinStock          int      `json:"min_stock"`
+	ReorderQty        int      `json:"reorder_qty"`
+	AverageDailySales float64  `json:"average_daily_sales"`
+	DaysOfCover       *float64 `json:"days_of_cover"`
+	SuggestedQty      int      `json:"suggested_qty"`
+}
+
+// LowStock lists the products whose amount is at or under their minimum stock. The
+// suggested quantity tops the product up to the larger of its minimum stock and
+// coverDays of average sales, and is never less than its reorder quantity. Average
+// sales count the units paid for in the last lookbackDays that were kept: voided
+// orders and refunded units are left out.
+func (m StockModel) LowStock(lookbackDays, coverDays int, filters Filters) ([]LowStockItem, Metadata, error) {
+	query := fmt.Sprintf(`
+		WITH sales AS (
+			SELECT op.product_id,
+				GREATEST(SUM(op.qty - (SELECT COALESCE(SUM(l.qty), 0) FROM refund_lines l WHERE l.order_product_id = op.id)), 0)::FLOAT / $1::INT AS daily
+			FROM order_product op
+			INNER JOIN orders o ON o.id = op.order_id
+			WHERE o.paid_at >= CURRENT_TIMESTAMP - make_interval(days => $1::INT)
+			AND o.status <> $5
+			AND op.gift_card_id IS NULL
+			GROUP BY op.product_id
+		), x AS (
+			SELECT p.id, p.name, p.amount, p.min_stock, p.reorder_qty,
+				COALESCE(s.daily, 0) AS average_daily_sales,
+				p.amount / NULLIF(s.daily, 0) AS days_of_cover,
+				GREATEST(p.reorder_qty, GREATEST(p.min_stock, CEIL(COALESCE(s.daily, 0) * $2::INT)::INT) - p.amount) AS suggested_qty
+			FROM products p
+			LEFT JOIN sales s ON s.product_id = p.id
+			WHERE p.amount <= p.min_stock
+		)
+		SELECT count(*) OVER(), id, name, amount, min_stock, reorder_qty, average_daily_sales, days_of_cover, suggested_qty
+		FROM x
+		ORDER BY %s %s NULLS FIRST, id ASC
+		LIMIT $3 OFFSET $4
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, lookbackDays, coverDays, filters.limit(), filters.offset(), OrderVoided)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []LowStockItem{}
+	for rows.Next() {
+		var i LowStockItem
+		err := rows.Scan(&totalRecords, &i.ProductId, &i.Name, &i.Amount, &i.MinStock, &i.ReorderQty,
+			&i.AverageDailySales, &i.DaysOfCover, &i.SuggestedQty)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		items = append(items, i)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return items, metadata, nil
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrSupplierInUse is returned when a supplier still has purchase orders.
+var ErrSupplierInUse = errors.New("supplier has purchase orders")
+
+type Supplier struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	Contact   string    `json:"contact"`
+	Phone     string    `json:"phone"`
+	Email     string    `json:"email"`
+	Address   string    `json:"address"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type SupplierModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateSupplier(v *validator.Validator, s *Supplier) {
+	v.Check(s.Name != "", "name", "must be provided")
+	v.Check(s.Email == "" || validator.Matches(s.Email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+func (m SupplierModel) Create(s *Supplier) error {
+	query := `
+		INSERT INTO suppliers (name, contact, phone, email, address)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`
+	args := []interface{}{s.Name, s.Contact, s.Phone, s.Email, s.Address}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&s.Id, &s.CreatedAt, &s.UpdatedAt)
+}
+
+func (m SupplierModel) Get(id int) (*Supplier, error) {
+	query := `
+		SELECT id, name, contact, phone, email, address, created_at, updated_at
+		FROM suppliers
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var s Supplier
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&s.Id, &s.Name, &s.Contact, &s.Phone, &s.Email, &s.Address,
+		&s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (m SupplierModel) GetAll() ([]Supplier, error) {
+	query := `
+		SELECT id, name, contact, phone, email, address, created_at, updated_at
+		FROM suppliers
+		ORDER BY name, id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppliers := []Supplier{}
+	for rows.Next() {
+		var s Supplier
+		err := rows.Scan(&s.Id, &s.Name, &s.Contact, &s.Phone, &s.Email, &s.Address, &s.CreatedAt, &s.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		suppliers = append(suppliers, s)
+	}
+
+	return suppliers, rows.Err()
+}
+
+func (m SupplierModel) Update(id int, s *Supplier) error {
+	query := `
+		UPDATE suppliers
+		SET name = $1, contact = $2, phone = $3, email = $4, address = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6
+		RETURNING created_at, updated_at
+	`
+	args := []interface{}{s.Name, s.Contact, s.Phone, s.Email, s.Address, id}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&s.CreatedAt, &s.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	s.Id = id
+	return err
+}
+
+func (m SupplierModel) Delete(id int) error {
+	query := `
+		DELETE FROM suppliers
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return ErrSupplierInUse
+	}
+	return err
+}
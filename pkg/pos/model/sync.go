@@ -0,0 +1,649 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"pos-rs/pkg/pos/validator"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Entities and operations of the catalog change feed.
+const (
+	CatalogProduct  = "product"
+	CatalogCategory = "category"
+
+	CatalogUpsert = "upsert"
+	CatalogDelete = "delete"
+)
+
+// Outcomes of an uploaded offline order.
+const (
+	SyncCreated   = "created"
+	SyncDuplicate = "duplicate"
+	SyncRejected  = "rejected"
+)
+
+// Conflicts found while recording an offline order. None of them stops the order from
+// being recorded; each says how it was resolved.
+const (
+	ConflictPriceChanged    = "price_changed"
+	ConflictOversold        = "oversold"
+	ConflictUnknownCustomer = "unknown_customer"
+	ConflictUnderpaid       = "underpaid"
+)
+
+// OfflineTenders are the tenders a till can take while it cannot reach the server;
+// points and gift cards need a live balance.
+var OfflineTenders = []string{TenderCash, TenderCard, TenderTransfer}
+
+// maxOfflineBatch is the largest number of orders a till may upload at once.
+const maxOfflineBatch = 100
+
+// syncTimeout bounds reading a whole catalog snapshot.
+const syncTimeout = 30 * time.Second
+
+var (
+	// ErrInvalidCursor is returned when a sync cursor cannot be parsed.
+	ErrInvalidCursor = errors.New("invalid sync cursor")
+
+	// ErrPriceBelowCurrent is returned when an offline line was sold below the current
+	// price by an employee who may not give manual discounts.
+	ErrPriceBelowCurrent = errors.New("sold below the current price without the discounts:manual permission")
+)
+
+// SyncCursor is a position in the catalog change feed. Changes are ordered by the id
+// of the transaction that made them, then by their own id, and a cursor never passes
+// a transaction that may still commit, so no change is ever skipped.
+type SyncCursor struct {
+	Xid int64
+	Id  int64
+}
+
+// ParseSyncCursor reads a cursor in the form String returns. An empty cursor is the
+// start of the feed.
+func ParseSyncCursor(s string) (SyncCursor, error) {
+	if s == "" {
+		return SyncCursor{}, nil
+	}
+
+	xid, id, ok := strings.Cut(s, ".")
+	if !ok {
+		return SyncCursor{}, ErrInvalidCursor
+	}
+
+	var c SyncCursor
+	var errXid, errId error
+	c.Xid, errXid = strconv.ParseInt(xid, 10, 64)
+	c.Id, errId = strconv.ParseInt(id, 10, 64)
+	if errXid != nil || errId != nil || c.Xid < 0 || c.Id < 0 {
+		return SyncCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func (c SyncCursor) String() string {
+	return fmt.Sprintf("%d.%d", c.Xid, c.Id)
+}
+
+func (c SyncCursor) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// CatalogSnapshot is the whole catalog as of Cursor; the changes after it are read
+// with SyncModel.Changes.
+type CatalogSnapshot struct {
+	Cursor     SyncCursor `json:"cursor"`
+	Categories []Category `json:"categories"`
+	Products   []Product  `json:"products"`
+}
+
+// CatalogChange says that an entity changed. Upserts carry the entity as it is now,
+// so applying the same change twice is harmless.
+type CatalogChange struct {
+	Entity   string    `json:"entity"`
+	EntityId int       `json:"entity_id"`
+	Op       string    `json:"op"`
+	Product  *Product  `json:"product,omitempty"`
+	Category *Category `json:"category,omitempty"`
+}
+
+// CatalogChanges is a page of the change feed. Cursor is where the next page starts.
+type CatalogChanges struct {
+	Cursor  SyncCursor      `json:"cursor"`
+	HasMore bool            `json:"has_more"`
+	Changes []CatalogChange `json:"changes"`
+}
+
+// OfflineLine is an order line taken offline with the unit Price the till charged.
+type OfflineLine struct {
+	OrderProduct
+	Price *Money `json:"price"`
+}
+
+// OfflineOrder is an order a till took while offline. ClientId is a UUID the till
+// generates, which makes uploading the same order again harmless.
+type OfflineOrder struct {
+	ClientId   string        `json:"client_id"`
+	CustomerId *int          `json:"customer_id"`
+	Products   []OfflineLine `json:"products"`
+	Tenders    []Payment     `json:"tenders"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+type SyncConflict struct {
+	Type      string `json:"type"`
+	ProductId *int   `json:"product_id,omitempty"`
+	Detail    string `json:"detail"`
+}
+
+// SyncResult is what became of one uploaded order. A rejected order is not recorded
+// and Error says why.
+type SyncResult struct {
+	ClientId  string         `json:"client_id"`
+	Status    string         `json:"status"`
+	OrderId   int            `json:"order_id,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Conflicts []SyncConflict `json:"conflicts"`
+}
+
+type SyncModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+func ValidateOfflineOrders(v *validator.Validator, batch []OfflineOrder) {
+	v.Check(len(batch) > 0, "orders", "must contain at least one order")
+	v.Check(len(batch) <= maxOfflineBatch, "orders", fmt.Sprintf("must not contain more than %d orders", maxOfflineBatch))
+
+	seen := make(map[string]bool, len(batch))
+	for i, o := range batch {
+		key := fmt.Sprintf("orders[%d]", i)
+		v.Check(validator.Matches(o.ClientId, validator.UUIDRX), key+".client_id", "must be a UUID")
+		v.Check(!seen[strings.ToLower(o.ClientId)], key+".client_id", "must be unique within the batch")
+		seen[strings.ToLower(o.ClientId)] = true
+		v.Check(!o.CreatedAt.IsZero(), key+".created_at", "must be provided")
+
+		v.Check(len(o.Products) > 0, key+".products", "must contain at least one product")
+		for j, l := range o.Products {
+			line := fmt.Sprintf("%s.products[%d]", key, j)
+			v.Check(l.ProductId > 0, line+".product_id", "must be provided")
+			v.Check(l.Qty > 0, line+".qty", "must be greater than zero")
+			v.Check(l.Price != nil, line+".price", "must be provided")
+			v.Check(l.Price == nil || !l.Price.IsNegative(), line+".price", "must not be negative")
+			v.Check(!l.ManualDiscount.IsNegative(), line+".manual_discount", "must not be negative")
+			v.Check(l.GiftCardCode == "", line+".gift_card_code", "gift cards cannot be sold offline")
+			for k, m := range l.Modifiers {
+				v.Check(m.ModifierId > 0, fmt.Sprintf("%s.modifiers[%d].modifier_id", line, k), "must be provided")
+			}
+		}
+
+		for j, t := range o.Tenders {
+			tender := fmt.Sprintf("%s.tenders[%d]", key, j)
+			v.Check(validator.In(t.Method, OfflineTenders...), tender+".method", "must be cash, card or transfer")
+			v.Check(t.Amount.IsPositive(), tender+".amount", "must be greater than zero")
+		}
+	}
+}
+
+// Snapshot returns the whole catalog and the cursor to follow its changes from.
+func (m SyncModel) Snapshot() (*CatalogSnapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The cursor is read in the same snapshot as the catalog: every change before it
+	// is part of the catalog, and changes after it that already are get sent again,
+	// which is harmless.
+	query := `
+		SELECT xid, id
+		FROM catalog_changes
+		WHERE xid < txid_snapshot_xmin(txid_current_snapshot())
+		ORDER BY xid DESC, id DESC
+		LIMIT 1
+	`
+	var snapshot CatalogSnapshot
+	err = tx.QueryRowContext(ctx, query).Scan(&snapshot.Cursor.Xid, &snapshot.Cursor.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	snapshot.Categories, err = getCatalogCategories(ctx, tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Products, err = getCatalogProducts(ctx, tx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, tx.Commit()
+}
+
+// Changes returns up to limit changes after the cursor. Several changes of the same
+// entity within the page are folded into the last one.
+func (m SyncModel) Changes(cursor SyncCursor, limit int) (*CatalogChanges, error) {
+	query := `
+		SELECT xid, id, entity, entity_id, op
+		FROM catalog_changes
+		WHERE (xid, id) > ($1, $2)
+		AND xid < txid_snapshot_xmin(txid_current_snapshot())
+		ORDER BY xid, id
+		LIMIT $3
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, cursor.Xid, cursor.Id, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := CatalogChanges{Cursor: cursor}
+	var feed []CatalogChange
+	for rows.Next() {
+		if len(feed) == limit {
+			page.HasMore = true
+			break
+		}
+
+		var c CatalogChange
+		if err := rows.Scan(&page.Cursor.Xid, &page.Cursor.Id, &c.Entity, &c.EntityId, &c.Op); err != nil {
+			return nil, err
+		}
+		feed = append(feed, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	type entityKey struct {
+		entity string
+		id     int
+	}
+	seen := make(map[entityKey]bool)
+	upserted := make(map[string][]int)
+	for i := len(feed) - 1; i >= 0; i-- {
+		key := entityKey{feed[i].Entity, feed[i].EntityId}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		page.Changes = append(page.Changes, feed[i])
+		if feed[i].Op == CatalogUpsert {
+			upserted[feed[i].Entity] = append(upserted[feed[i].Entity], feed[i].EntityId)
+		}
+	}
+	for i, j := 0, len(page.Changes)-1; i < j; i, j = i+1, j-1 {
+		page.Changes[i], page.Changes[j] = page.Changes[j], page.Changes[i]
+	}
+
+	categories, err := getCatalogCategories(ctx, tx, upserted[CatalogCategory])
+	if err != nil {
+		return nil, err
+	}
+	products, err := getCatalogProducts(ctx, tx, upserted[CatalogProduct])
+	if err != nil {
+		return nil, err
+	}
+
+	byCategory := make(map[int]*Category, len(categories))
+	for i := range categories {
+		byCategory[categories[i].Id] = &categories[i]
+	}
+	byProduct := make(map[int]*Product, len(products))
+	for i := range products {
+		byProduct[products[i].Id] = &products[i]
+	}
+
+	// An entity deleted after the page was written down is sent as deleted.
+	for i := range page.Changes {
+		c := &page.Changes[i]
+		if c.Op != CatalogUpsert {
+			continue
+		}
+		switch c.Entity {
+		case CatalogCategory:
+			c.Category = byCategory[c.EntityId]
+			if c.Category == nil {
+				c.Op = CatalogDelete
+			}
+		case CatalogProduct:
+			c.Product = byProduct[c.EntityId]
+			if c.Product == nil {
+				c.Op = CatalogDelete
+			}
+		}
+	}
+
+	if page.Changes == nil {
+		page.Changes = []CatalogChange{}
+	}
+	return &page, tx.Commit()
+}
+
+// UploadOrders records a batch of offline orders, each in its own transaction, with
+// the employee, store, station and tax mode of header. manualDiscounts says whether
+// the employee may give manual discounts. Orders are recorded oldest
+// first, so the outcome does not depend on the order they were sent in; the results
+// are returned in the order of the batch.
+//
+// An order is already sold, so conflicts are resolved in its favour where possible:
+// lines keep the price charged offline, promotions are those valid when the order was
+// taken, stock that does not cover a line is sold down to zero and unknown customers
+// are dropped. A line sold below the current price is a manual discount: it keeps
+// the current price with the difference as its discount, and the order is rejected
+// unless manualDiscounts is set. Tenders that do not cover the total leave the order open with the rest
+// due. Orders that cannot be recorded at all, such as orders of deleted products, are
+// rejected.
+func (m SyncModel) UploadOrders(header Order, batch []OfflineOrder, manualDiscounts bool, by AuditActor) ([]SyncResult, error) {
+	byAge := make([]int, len(batch))
+	for i := range byAge {
+		byAge[i] = i
+	}
+	sort.SliceStable(byAge, func(a, b int) bool {
+		oa, ob := batch[byAge[a]], batch[byAge[b]]
+		if !oa.CreatedAt.Equal(ob.CreatedAt) {
+			return oa.CreatedAt.Before(ob.CreatedAt)
+		}
+		return strings.ToLower(oa.ClientId) < strings.ToLower(ob.ClientId)
+	})
+
+	results := make([]SyncResult, len(batch))
+	for _, i := range byAge {
+		result, err := m.uploadOrder(header, &batch[i], manualDiscounts, by)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = *result
+	}
+
+	return results, nil
+}
+
+func (m SyncModel) uploadOrder(header Order, offline *OfflineOrder, manualDiscounts bool, by AuditActor) (*SyncResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := &SyncResult{ClientId: offline.ClientId, Conflicts: []SyncConflict{}}
+
+	id, err := orderIdForClient(ctx, m.DB, offline.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if id != 0 {
+		result.Status = SyncDuplicate
+		result.OrderId = id
+		return result, nil
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = syncOrder(ctx, tx, header, offline, manualDiscounts, result)
+	if err == nil {
+		err = auditOrder(ctx, tx, by, AuditCreate, result.OrderId, nil)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	var pqErr *pq.Error
+	switch {
+	case err == nil:
+		result.Status = SyncCreated
+	case errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "orders_client_id_key":
+		// The same order was uploaded concurrently and got there first.
+		tx.Rollback()
+		id, err := orderIdForClient(ctx, m.DB, offline.ClientId)
+		if err != nil {
+			return nil, err
+		}
+		*result = SyncResult{ClientId: offline.ClientId, Status: SyncDuplicate, OrderId: id, Conflicts: []SyncConflict{}}
+	case errors.Is(err, ErrRecordNotFound), errors.Is(err, ErrProductHasVariants),
+		errors.Is(err, ErrInvalidModifiers), errors.Is(err, ErrTenderExceedsDue), errors.Is(err, ErrPriceBelowCurrent),
+		errors.Is(err, ErrNoOpenShift):
+		*result = SyncResult{ClientId: offline.ClientId, Status: SyncRejected, Error: err.Error(), Conflicts: []SyncConflict{}}
+	default:
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// syncOrder records an offline order and pays it with its tenders, noting the
+// conflicts it resolves in result.
+func syncOrder(ctx context.Context, q queryer, header Order, offline *OfflineOrder, manualDiscounts bool, result *SyncResult) error {
+	order := header
+	order.ClientId = &offline.ClientId
+	order.CustomerId = offline.CustomerId
+	order.CreatedAt = offline.CreatedAt
+	order.Products = make([]OrderProduct, len(offline.Products))
+
+	err := checkCustomer(ctx, q, order.CustomerId)
+	if errors.Is(err, ErrUnknownCustomer) {
+		result.conflict(ConflictUnknownCustomer, nil, fmt.Sprintf("customer %d does not exist; the order is kept without a customer", *order.CustomerId))
+		order.CustomerId = nil
+	} else if err != nil {
+		return err
+	}
+
+	ids := make([]int, 0, len(offline.Products))
+	for _, l := range offline.Products {
+		ids = append(ids, l.ProductId)
+	}
+	if err := lockProducts(ctx, q, ids); err != nil {
+		return err
+	}
+	for i, l := range offline.Products {
+		line := l.OrderProduct
+		err := lockLineProduct(ctx, q, &line, order.StationId)
+		if errors.Is(err, ErrRecordNotFound) {
+			return fmt.Errorf("%w: product %d", ErrRecordNotFound, line.ProductId)
+		}
+		if err != nil {
+			return err
+		}
+		switch l.Price.Cmp(line.Price) {
+		case 1:
+			result.conflict(ConflictPriceChanged, &line.ProductId, fmt.Sprintf("sold at %s offline, the price is now %s; the offline price is kept", *l.Price, line.Price))
+			line.Price = *l.Price
+		case -1:
+			if !manualDiscounts {
+				return fmt.Errorf("%w: product %d sold at %s, the price is %s", ErrPriceBelowCurrent, line.ProductId, *l.Price, line.Price)
+			}
+			discount := line.Price.Sub(*l.Price).Times(line.Qty)
+			result.conflict(ConflictPriceChanged, &line.ProductId, fmt.Sprintf("sold at %s offline, the price is now %s; %s is booked as a manual discount", *l.Price, line.Price, discount))
+			line.ManualDiscount = line.ManualDiscount.Add(discount)
+		}
+		order.Products[i] = line
+	}
+
+	if err := priceOrderAt(ctx, q, &order, order.CreatedAt); err != nil {
+		return err
+	}
+
+	// The order and its takings belong to the shift it was taken in, which may have
+	// been closed by the time the till is back online.
+	order.ShiftId, err = shiftIdAt(ctx, q, order.EmployeeID, order.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if order.ShiftId == nil && len(offline.Tenders) > 0 {
+		return fmt.Errorf("%w: employee %d had no shift open at %s", ErrNoOpenShift, order.EmployeeID, order.CreatedAt.Format(time.RFC3339))
+	}
+	if err := insertOrder(ctx, q, &order); err != nil {
+		return err
+	}
+	result.OrderId = order.Id
+
+	for i := range order.Products {
+		line := &order.Products[i]
+		line.OrderId = order.Id
+		if err := insertOrderProduct(ctx, q, line); err != nil {
+			return err
+		}
+
+		missing, err := sellOfflineStock(ctx, q, &order, line)
+		if err != nil {
+			return err
+		}
+		if missing > 0 {
+			result.conflict(ConflictOversold, &line.ProductId, fmt.Sprintf("%d sold offline, %d were in stock; stock is now 0", line.Qty, line.Qty-missing))
+		}
+	}
+
+	if len(offline.Tenders) == 0 {
+		return nil
+	}
+
+	payment, err := payOrder(ctx, q, &order, order.EmployeeID, order.ShiftId, offline.Tenders, order.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if payment.AmountDue.IsPositive() {
+		result.conflict(ConflictUnderpaid, nil, fmt.Sprintf("tenders cover %s of %s; the order stays open with %s due", order.TotalPaid, order.TotalPrice, payment.AmountDue))
+	}
+
+	return nil
+}
+
+func (r *SyncResult) conflict(kind string, productId *int, detail string) {
+	var id *int
+	if productId != nil {
+		v := *productId
+		id = &v
+	}
+	r.Conflicts = append(r.Conflicts, SyncConflict{Type: kind, ProductId: id, Detail: detail})
+}
+
+// sellOfflineStock is sellStock for a sale that already happened: when the stock does
+// not cover the line, what is left is sold and the missing quantity returned.
+func sellOfflineStock(ctx context.Context, q queryer, order *Order, line *OrderProduct) (int, error) {
+	err := sellStock(ctx, q, order, line)
+	if !errors.Is(err, ErrOutOfStock) {
+		return 0, err
+	}
+
+	var left int
+	if err := q.QueryRowContext(ctx, `SELECT amount FROM products WHERE id = $1`, line.ProductId).Scan(&left); err != nil {
+		return 0, err
+	}
+
+	if left > 0 {
+		movement := StockMovement{
+			ProductId:  line.ProductId,
+			Type:       StockSale,
+			Qty:        -left,
+			EmployeeId: order.EmployeeID,
+			Reason:     "oversold offline",
+			Reference:  orderReference(order.Id),
+		}
+		if err := moveStock(ctx, q, &movement); err != nil {
+			return 0, err
+		}
+	}
+
+	line.Product.Amount = 0
+	return line.Qty - left, nil
+}
+
+// orderIdForClient returns the id of the order uploaded with a client id, or 0.
+func orderIdForClient(ctx context.Context, q queryer, clientId string) (int, error) {
+	var id int
+	err := q.QueryRowContext(ctx, `SELECT id FROM orders WHERE client_id = $1`, clientId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// getCatalogCategories returns the categories with the given ids, or all of them for
+// nil ids.
+func getCatalogCategories(ctx context.Context, q queryer, ids []int) ([]Category, error) {
+	query := `
+		SELECT id, name, tax_rate, parent_id, position, version, created_at, updated_at
+		FROM categories
+		WHERE $1::int[] IS NULL OR id = ANY($1)
+		ORDER BY id
+	`
+	categories := []Category{}
+	if ids != nil && len(ids) == 0 {
+		return categories, nil
+	}
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.Id, &c.Name, &c.TaxRate, &c.ParentId, &c.Position, &c.Version, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+
+	return categories, rows.Err()
+}
+
+// getCatalogProducts returns the products and variants with the given ids, or all of
+// them for nil ids.
+func getCatalogProducts(ctx context.Context, q queryer, ids []int) ([]Product, error) {
+	query := `
+		SELECT ` + productColumns + `
+		FROM products p
+		WHERE $1::int[] IS NULL OR p.id = ANY($1)
+		ORDER BY p.id
+	`
+	products := []Product{}
+	if ids != nil && len(ids) == 0 {
+		return products, nil
+	}
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p Product
+		err := rows.Scan(&p.Id, &p.Name, &p.CategoryId, &p.Price, &p.CostPrice, &p.Description, &p.Amount,
+			&p.MinStock, &p.ReorderQty, &p.TaxRate, &p.Sku, &p.ParentId, &p.Attributes, pq.Array(&p.Barcodes),
+			&p.Version, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+
+	return products, rows.Err()
+}
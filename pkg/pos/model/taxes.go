@@ -0,0 +1,100 @@
+package model
+
+import "sort"
+
+// TaxLine is the tax collected at a single rate. Net excludes tax, Gross includes it.
+type TaxLine struct {
+	Rate  float64 `json:"rate"`
+	Net   Money   `json:"net"`
+	Tax   Money   `json:"tax"`
+	Gross Money   `json:"gross"`
+}
+
+// applyTaxes computes the tax of every line from its discounted amount, including
+// its share of the order discount. With tax-inclusive pricing the tax is carved out
+// of the price; otherwise it is added on top and raises the order total.
+func applyTaxes(order *Order) {
+	order.Tax = Money{}
+	for i := range order.Products {
+		line := &order.Products[i]
+		amount := order.lineAmount(*line)
+
+		if order.TaxInclusive {
+			line.Tax = amount.Scale(line.TaxRate / (100 + line.TaxRate))
+		} else {
+			line.Tax = amount.Percent(line.TaxRate)
+		}
+		order.Tax = order.Tax.Add(line.Tax)
+	}
+
+	if !order.TaxInclusive {
+		order.TotalPrice = order.TotalPrice.Add(order.Tax)
+	}
+	order.Taxes = summarizeTaxes(order)
+}
+
+// summarizeTaxes groups the line taxes of the order by rate.
+func summarizeTaxes(order *Order) []TaxLine {
+	byRate := make(map[float64]*TaxLine)
+	for _, line := range order.Products {
+		t, ok := byRate[line.TaxRate]
+		if !ok {
+			t = &TaxLine{Rate: line.TaxRate}
+			byRate[line.TaxRate] = t
+		}
+		gross := order.LineNet(line)
+		t.Gross = t.Gross.Add(gross)
+		t.Tax = t.Tax.Add(line.Tax)
+		t.Net = t.Net.Add(gross.Sub(line.Tax))
+	}
+
+	taxes := []TaxLine{}
+	for _, t := range byRate {
+		taxes = append(taxes, *t)
+	}
+	sort.Slice(taxes, func(i, j int) bool { return taxes[i].Rate < taxes[j].Rate })
+
+	return taxes
+}
+
+// subtotal is the sum of the lines after line discounts and before the order
+// discount and any exclusive tax.
+func (o *Order) subtotal() Money {
+	var subtotal Money
+	for _, line := range o.Products {
+		subtotal = subtotal.Add(line.TotalNormalPrice.Sub(line.Discount))
+	}
+	return subtotal
+}
+
+// discountable is the part of the subtotal order discounts apply to, which leaves
+// out gift cards.
+func (o *Order) discountable() Money {
+	var amount Money
+	for _, line := range o.Products {
+		if line.GiftCardId == nil {
+			amount = amount.Add(line.TotalNormalPrice.Sub(line.Discount))
+		}
+	}
+	return amount
+}
+
+// lineAmount is the line's price after the line discount and its share of the order
+// discount, before any exclusive tax.
+func (o *Order) lineAmount(line OrderProduct) Money {
+	amount := line.TotalNormalPrice.Sub(line.Discount)
+	if line.GiftCardId != nil {
+		return amount
+	}
+	return amount.Sub(o.Discount.Share(amount, o.discountable()))
+}
+
+// LineNet is what the customer actually paid for a line: its price after discounts
+// plus, with tax-exclusive pricing, its tax.
+func (o *Order) LineNet(line OrderProduct) Money {
+	net := o.lineAmount(line)
+	if !o.TaxInclusive {
+		net = net.Add(line.Tax)
+	}
+	return net
+}
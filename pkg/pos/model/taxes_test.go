@@ -0,0 +1,102 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+// taxedLine is a line of qty units of product at price minor units, taxed at rate.
+func taxedLine(product int, price int64, qty int, rate float64) OrderProduct {
+	return OrderProduct{
+		ProductId: product,
+		Qty:       qty,
+		Price:     NewMoney(price),
+		TaxRate:   rate,
+		Product:   Product{Id: product},
+	}
+}
+
+func TestApplyTaxes(t *testing.T) {
+	product := 1
+
+	tests := []struct {
+		name       string
+		inclusive  bool
+		lines      []OrderProduct
+		promotions []Promotion
+		wantLines  []int64
+		wantTax    int64
+		wantTotal  int64
+		wantTaxes  []TaxLine
+	}{
+		{
+			name:      "exclusive tax is added on top",
+			lines:     []OrderProduct{taxedLine(1, 1000, 1, 12), taxedLine(2, 500, 2, 0)},
+			wantLines: []int64{120, 0},
+			wantTax:   120,
+			wantTotal: 2120,
+			wantTaxes: []TaxLine{
+				{Rate: 0, Net: NewMoney(1000), Tax: NewMoney(0), Gross: NewMoney(1000)},
+				{Rate: 12, Net: NewMoney(1000), Tax: NewMoney(120), Gross: NewMoney(1120)},
+			},
+		},
+		{
+			name:      "inclusive tax is carved out of the price",
+			inclusive: true,
+			lines:     []OrderProduct{taxedLine(1, 1120, 1, 12), taxedLine(2, 1000, 1, 12)},
+			wantLines: []int64{120, 107},
+			wantTax:   227,
+			wantTotal: 2120,
+			wantTaxes: []TaxLine{
+				{Rate: 12, Net: NewMoney(1893), Tax: NewMoney(227), Gross: NewMoney(2120)},
+			},
+		},
+		{
+			name:       "the order discount is shared out before tax",
+			lines:      []OrderProduct{taxedLine(1, 1000, 1, 10), taxedLine(2, 1000, 1, 20)},
+			promotions: []Promotion{{Id: 1, Type: PromotionOrderPercent, Value: 10}},
+			wantLines:  []int64{90, 180},
+			wantTax:    270,
+			wantTotal:  2070,
+			wantTaxes: []TaxLine{
+				{Rate: 10, Net: NewMoney(900), Tax: NewMoney(90), Gross: NewMoney(990)},
+				{Rate: 20, Net: NewMoney(900), Tax: NewMoney(180), Gross: NewMoney(1080)},
+			},
+		},
+		{
+			name:       "line discounts are taxed after the discount",
+			inclusive:  true,
+			lines:      []OrderProduct{taxedLine(1, 1200, 1, 20)},
+			promotions: []Promotion{{Id: 1, Type: PromotionLinePercent, Value: 50, ProductId: &product}},
+			wantLines:  []int64{100},
+			wantTax:    100,
+			wantTotal:  600,
+			wantTaxes: []TaxLine{
+				{Rate: 20, Net: NewMoney(500), Tax: NewMoney(100), Gross: NewMoney(600)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &Order{TaxInclusive: tt.inclusive, Products: append([]OrderProduct(nil), tt.lines...)}
+			applyPromotions(order, tt.promotions)
+			applyTaxes(order)
+
+			for i, line := range order.Products {
+				if line.Tax.Amount != tt.wantLines[i] {
+					t.Errorf("line %d tax = %d, want %d", i, line.Tax.Amount, tt.wantLines[i])
+				}
+			}
+			if order.Tax.Amount != tt.wantTax {
+				t.Errorf("tax = %d, want %d", order.Tax.Amount, tt.wantTax)
+			}
+			if order.TotalPrice.Amount != tt.wantTotal {
+				t.Errorf("total = %d, want %d", order.TotalPrice.Amount, tt.wantTotal)
+			}
+			if !reflect.DeepEqual(order.Taxes, tt.wantTaxes) {
+				t.Errorf("taxes = %+v, want %+v", order.Taxes, tt.wantTaxes)
+			}
+		})
+	}
+}
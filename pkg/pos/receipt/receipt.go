@@ -0,0 +1,279 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"pos-rs/pkg/pos/model"
+)
+
+// Printer paper widths in millimetres and the number of characters a line holds in
+// the printer's default font.
+const (
+	Width58 = 58
+	Width80 = 80
+)
+
+var lineChars = map[int]int{
+	Width58: 32,
+	Width80: 48,
+}
+
+// Line is a single sold item as it appears on the receipt. Price already includes the
+// price deltas of its modifiers. GiftCard is the masked code of a sold gift card.
+type Line struct {
+	Name      string      `json:"name"`
+	Qty       int         `json:"qty"`
+	Modifiers []Modifier  `json:"modifiers"`
+	GiftCard  string      `json:"gift_card,omitempty"`
+	Price     model.Money `json:"price"`
+	Discount  model.Money `json:"discount"`
+	Total     model.Money `json:"total"`
+}
+
+// Modifier is an add-on chosen for a line as it appears on the receipt.
+type Modifier struct {
+	Name       string      `json:"name"`
+	PriceDelta model.Money `json:"price_delta"`
+}
+
+// Tax is the VAT collected at one rate as it appears on the receipt.
+type Tax struct {
+	Rate  float64     `json:"rate"`
+	Net   model.Money `json:"net"`
+	Tax   model.Money `json:"tax"`
+	Gross model.Money `json:"gross"`
+}
+
+// Tender is a single payment as it appears on the receipt. GiftCard is the masked code
+// of the card a gift card payment was taken from.
+type Tender struct {
+	Method   string      `json:"method"`
+	GiftCard string      `json:"gift_card,omitempty"`
+	Amount   model.Money `json:"amount"`
+}
+
+// Receipt is the printable view of a paid order.
+type Receipt struct {
+	StoreId   string      `json:"store_id"`
+	ReceiptID string      `json:"receipt_id"`
+	OrderId   int         `json:"order_id"`
+	Employee  int         `json:"employee_id"`
+	Lines     []Line      `json:"lines"`
+	Tenders   []Tender    `json:"tenders"`
+	Taxes     []Tax       `json:"taxes"`
+	Currency  string      `json:"currency"`
+	Discount  model.Money `json:"discount"`
+	Tax       model.Money `json:"tax"`
+	TaxIncl   bool        `json:"tax_inclusive"`
+	Total     model.Money `json:"total"`
+	Paid      model.Money `json:"paid"`
+	Change    model.Money `json:"change"`
+	PaidAt    *time.Time  `json:"paid_at"`
+}
+
+// New builds a receipt from an order and the payments recorded against it.
+func New(order *model.Order, payments []model.Payment) *Receipt {
+	r := &Receipt{
+		StoreId:   order.StoreId,
+		ReceiptID: order.ReceiptID,
+		OrderId:   order.Id,
+		Employee:  order.EmployeeID,
+		Lines:     []Line{},
+		Tenders:   []Tender{},
+		Taxes:     []Tax{},
+		Currency:  order.TotalPrice.Currency,
+		Discount:  order.Discount,
+		Tax:       order.Tax,
+		TaxIncl:   order.TaxInclusive,
+		Total:     order.TotalPrice,
+		Paid:      order.TotalPaid,
+		Change:    order.TotalReturn,
+		PaidAt:    order.PaidAt,
+	}
+
+	for _, p := range order.Products {
+		line := Line{
+			Name:      p.Product.Name,
+			Qty:       p.Qty,
+			Modifiers: []Modifier{},
+			GiftCard:  masked(p.GiftCardCode),
+			Price:     p.Price,
+			Discount:  p.Discount,
+			Total:     p.TotalNormalPrice.Sub(p.Discount),
+		}
+		for _, m := range p.Modifiers {
+			line.Modifiers = append(line.Modifiers, Modifier{Name: m.Name, PriceDelta: m.PriceDelta})
+		}
+		r.Lines = append(r.Lines, line)
+	}
+
+	for _, t := range order.Taxes {
+		r.Taxes = append(r.Taxes, Tax{Rate: t.Rate, Net: t.Net, Tax: t.Tax, Gross: t.Gross})
+	}
+
+	for _, p := range payments {
+		r.Tenders = append(r.Tenders, Tender{Method: p.Method, GiftCard: masked(p.GiftCardCode), Amount: p.Amount})
+	}
+
+	return r
+}
+
+// ValidWidth reports whether the paper width is one the renderer supports.
+func ValidWidth(width int) bool {
+	_, ok := lineChars[width]
+	return ok
+}
+
+// Text renders the receipt as plain text sized for the given paper width.
+func (r *Receipt) Text(width int) string {
+	n := lineChars[widthOrDefault(width)]
+
+	var b strings.Builder
+	center := func(s string) {
+		if runes(s) < n {
+			s = strings.Repeat(" ", (n-runes(s))/2) + s
+		}
+		b.WriteString(s + "\n")
+	}
+	pair := func(left, right string) {
+		space := n - runes(left) - runes(right)
+		if space < 1 {
+			b.WriteString(left + "\n")
+			left, space = "", n-runes(right)
+		}
+		b.WriteString(left + strings.Repeat(" ", space) + right + "\n")
+	}
+	rule := func() {
+		b.WriteString(strings.Repeat("-", n) + "\n")
+	}
+
+	center(strings.ToUpper(r.StoreId))
+	center("RECEIPT " + r.ReceiptID)
+	if r.PaidAt != nil {
+		center(r.PaidAt.Format("2006-01-02 15:04:05"))
+	}
+	rule()
+
+	for _, l := range r.Lines {
+		b.WriteString(truncate(l.Name, n) + "\n")
+		for _, m := range l.Modifiers {
+			mod := "  + " + m.Name
+			if !m.PriceDelta.IsZero() {
+				mod += " (" + delta(m.PriceDelta) + ")"
+			}
+			b.WriteString(truncate(mod, n) + "\n")
+		}
+		if l.GiftCard != "" {
+			b.WriteString(truncate("  CARD "+l.GiftCard, n) + "\n")
+		}
+		pair(fmt.Sprintf("  %d x %s", l.Qty, amount(l.Price)), amount(l.Total.Add(l.Discount)))
+		if l.Discount.IsPositive() {
+			pair("  DISCOUNT", "-"+amount(l.Discount))
+		}
+	}
+	rule()
+
+	if r.Discount.IsPositive() {
+		pair("ORDER DISCOUNT", "-"+amount(r.Discount))
+	}
+	if !r.TaxIncl && r.Tax.IsPositive() {
+		pair("TAX", amount(r.Tax))
+	}
+	pair("TOTAL "+r.Currency, amount(r.Total))
+	for _, t := range r.Tenders {
+		method := strings.ToUpper(t.Method)
+		if t.GiftCard != "" {
+			method += " " + t.GiftCard
+		}
+		pair(method, amount(t.Amount))
+	}
+	pair("CHANGE", amount(r.Change))
+	rule()
+
+	if len(r.Taxes) > 0 {
+		for _, t := range r.Taxes {
+			pair(fmt.Sprintf("VAT %s%% ON %s", rate(t.Rate), amount(t.Net)), amount(t.Tax))
+		}
+		rule()
+	}
+	center(fmt.Sprintf("Order #%d  Cashier #%d", r.OrderId, r.Employee))
+
+	return b.String()
+}
+
+// ESC/POS control sequences used by ESCPOS.
+var (
+	escInit      = []byte{0x1B, 0x40}
+	escAlignLeft = []byte{0x1B, 0x61, 0x00}
+	escBoldOn    = []byte{0x1B, 0x45, 0x01}
+	escBoldOff   = []byte{0x1B, 0x45, 0x00}
+	escFeed      = []byte{0x1B, 0x64, 0x04}
+	gsCutPartial = []byte{0x1D, 0x56, 0x01}
+)
+
+// ESCPOS renders the receipt as a byte stream for ESC/POS thermal printers: the
+// printer is reset, the text body is printed with the header in bold, then the
+// paper is fed and cut.
+func (r *Receipt) ESCPOS(width int) []byte {
+	rule := strings.Repeat("-", lineChars[widthOrDefault(width)]) + "\n"
+	header, body, _ := strings.Cut(r.Text(width), rule)
+
+	var b bytes.Buffer
+	b.Write(escInit)
+	b.Write(escAlignLeft)
+	b.Write(escBoldOn)
+	b.WriteString(header)
+	b.Write(escBoldOff)
+	b.WriteString(rule)
+	b.WriteString(body)
+	b.Write(escFeed)
+	b.Write(gsCutPartial)
+
+	return b.Bytes()
+}
+
+func widthOrDefault(width int) int {
+	if ValidWidth(width) {
+		return width
+	}
+	return Width80
+}
+
+func masked(code string) string {
+	if code == "" {
+		return ""
+	}
+	return model.MaskGiftCardCode(code)
+}
+
+func amount(v model.Money) string {
+	return v.String()
+}
+
+// delta formats a price delta with its sign.
+func delta(v model.Money) string {
+	if v.IsNegative() {
+		return amount(v)
+	}
+	return "+" + amount(v)
+}
+
+func rate(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func runes(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+func truncate(s string, n int) string {
+	if runes(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
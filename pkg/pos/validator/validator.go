@@ -6,6 +6,9 @@ var (
 	// EmailRX is a regex for sanity checking the format of email addresses.
 	// The regex pattern used is taken from  https://html.spec.whatwg.org/#valid-e-mail-address.
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+	// UUIDRX is a regex for checking the canonical textual form of a UUID.
+	UUIDRX = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
 )
 
 // Validator struct type contains a map of validation errors.